@@ -0,0 +1,393 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"hawx.me/code/arboretum/internal/data"
+	"hawx.me/code/arboretum/internal/discover"
+	"hawx.me/code/arboretum/internal/garden"
+	"hawx.me/code/arboretum/internal/subscriptions"
+)
+
+// cli runs the commands that manage the database without starting the server.
+type cli struct {
+	db     data.Store
+	out    io.Writer
+	client *http.Client
+
+	// user is the name of the user whose subscriptions are managed, it can be
+	// left empty when there is only one
+	user string
+
+	// refresh and options are used when fetching a feed
+	refresh time.Duration
+	options []garden.Option
+}
+
+// run runs the command named by the first of args, passing it the rest.
+func (c cli) run(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return errors.New("no command given")
+	}
+
+	name, args := args[0], args[1:]
+
+	switch name {
+	case "list":
+		return c.noArgs(ctx, name, args, c.list)
+	case "add":
+		return c.oneArg(ctx, name, args, c.add)
+	case "remove":
+		return c.oneArg(ctx, name, args, c.remove)
+	case "export":
+		return c.noArgs(ctx, name, args, c.export)
+	case "refresh":
+		return c.oneArg(ctx, name, args, c.refreshFeed)
+	case "prune":
+		return c.noArgs(ctx, name, args, c.prune)
+	case "retention":
+		if len(args) != 2 {
+			return errors.New("retention takes a URL and the items to keep")
+		}
+		return c.retention(ctx, args[0], args[1])
+	case "stats":
+		return c.noArgs(ctx, name, args, c.stats)
+	case "users":
+		return c.noArgs(ctx, name, args, c.users)
+	default:
+		return fmt.Errorf("unknown command %q", name)
+	}
+}
+
+func (c cli) noArgs(ctx context.Context, name string, args []string, fn func(context.Context) error) error {
+	if len(args) != 0 {
+		return fmt.Errorf("%s takes no arguments", name)
+	}
+
+	return fn(ctx)
+}
+
+func (c cli) oneArg(ctx context.Context, name string, args []string, fn func(context.Context, string) error) error {
+	if len(args) != 1 {
+		return fmt.Errorf("%s takes a single URL", name)
+	}
+
+	return fn(ctx, args[0])
+}
+
+// owner finds the user whose subscriptions are managed: the one named by
+// --user, or else the only user. Before anyone has signed in the subscriptions
+// are kept for whoever signs in first.
+func (c cli) owner(ctx context.Context) (data.User, error) {
+	if c.user != "" {
+		user, ok, err := c.db.UserByName(ctx, c.user)
+		if err != nil {
+			return data.User{}, err
+		}
+		if !ok {
+			return data.User{}, fmt.Errorf("no user called %q", c.user)
+		}
+		return user, nil
+	}
+
+	users, err := c.db.Users(ctx)
+	if err != nil {
+		return data.User{}, err
+	}
+	users = slices.DeleteFunc(users, func(user data.User) bool { return user.Name == "" })
+
+	switch len(users) {
+	case 0:
+		return c.db.UnclaimedUser(ctx)
+	case 1:
+		return users[0], nil
+	default:
+		return data.User{}, errors.New("there is more than one user, choose one with --user")
+	}
+}
+
+func (c cli) subscribed(ctx context.Context, user data.User, uri string) (bool, error) {
+	subs, err := c.db.SubscriptionDetails(ctx, user.ID)
+	if err != nil {
+		return false, err
+	}
+
+	return slices.ContainsFunc(subs, func(sub data.Subscription) bool { return sub.URL == uri }), nil
+}
+
+// list prints the feeds the user subscribes to, with when each was last
+// updated and how fetching it is going.
+func (c cli) list(ctx context.Context) error {
+	user, err := c.owner(ctx)
+	if err != nil {
+		return err
+	}
+
+	subs, err := c.db.SubscriptionDetails(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+
+	feeds, err := c.db.ListFeeds(ctx)
+	if err != nil {
+		return err
+	}
+	feeds = slices.DeleteFunc(feeds, func(feed data.Feed) bool {
+		return !slices.ContainsFunc(subs, func(sub data.Subscription) bool { return sub.URL == feed.URL })
+	})
+
+	tw := tabwriter.NewWriter(c.out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "URL\tTITLE\tUPDATED\tSTATUS")
+	for _, feed := range feeds {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", feed.URL, feed.Title, formatTime(feed.UpdatedAt), feedStatus(feed))
+	}
+
+	return tw.Flush()
+}
+
+func feedStatus(feed data.Feed) string {
+	switch {
+	case feed.Status.Gone:
+		return "gone"
+	case feed.Status.Dead:
+		return "dead since " + formatTime(feed.Status.FailingSince) + ": " + feed.Status.LastError
+	case feed.Status.ErrorCount > 0:
+		return fmt.Sprintf("failing (%d): %s", feed.Status.ErrorCount, feed.Status.LastError)
+	case feed.UpdatedAt.IsZero():
+		return "not fetched"
+	default:
+		return "ok"
+	}
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+
+	return t.Local().Format(time.DateTime)
+}
+
+// add subscribes to the feed at uri, or the feed offered by the website at
+// uri.
+func (c cli) add(ctx context.Context, uri string) error {
+	feeds, err := discover.Find(ctx, c.client, uri)
+	if err != nil {
+		return err
+	}
+
+	switch len(feeds) {
+	case 0:
+		return fmt.Errorf("no feeds found at %s", uri)
+	case 1:
+		uri = feeds[0].URL
+	default:
+		for _, feed := range feeds {
+			fmt.Fprintf(c.out, "%s\t%s\n", feed.URL, feed.Title)
+		}
+		return fmt.Errorf("found %d feeds at %s, add one of them instead", len(feeds), uri)
+	}
+
+	user, err := c.owner(ctx)
+	if err != nil {
+		return err
+	}
+
+	if ok, err := c.subscribed(ctx, user, uri); err != nil {
+		return err
+	} else if ok {
+		fmt.Fprintln(c.out, "already subscribed to", uri)
+		return nil
+	}
+
+	if err := c.db.Subscribe(ctx, user.ID, uri); err != nil {
+		return err
+	}
+
+	fmt.Fprintln(c.out, "subscribed to", uri)
+	return nil
+}
+
+// remove unsubscribes from the feed at uri, deleting its items when no one
+// else subscribes to it.
+func (c cli) remove(ctx context.Context, uri string) error {
+	user, err := c.owner(ctx)
+	if err != nil {
+		return err
+	}
+
+	if ok, err := c.subscribed(ctx, user, uri); err != nil {
+		return err
+	} else if !ok {
+		return fmt.Errorf("not subscribed to %s", uri)
+	}
+
+	if _, err := c.db.Unsubscribe(ctx, user.ID, uri); err != nil {
+		return err
+	}
+
+	fmt.Fprintln(c.out, "unsubscribed from", uri)
+	return nil
+}
+
+// export writes the subscriptions as OPML.
+func (c cli) export(ctx context.Context) error {
+	user, err := c.owner(ctx)
+	if err != nil {
+		return err
+	}
+
+	return subscriptions.Export(ctx, c.db, user.ID, c.out)
+}
+
+// refreshFeed fetches the feed at uri straight away, printing what it now
+// contains.
+func (c cli) refreshFeed(ctx context.Context, uri string) error {
+	user, err := c.owner(ctx)
+	if err != nil {
+		return err
+	}
+
+	if ok, err := c.subscribed(ctx, user, uri); err != nil {
+		return err
+	} else if !ok {
+		return fmt.Errorf("not subscribed to %s", uri)
+	}
+
+	fetch, err := garden.FetchOnce(ctx, c.db, c.refresh, uri, c.options...)
+	if err != nil {
+		return err
+	}
+
+	if fetch.URL != uri {
+		fmt.Fprintln(c.out, "moved to", fetch.URL)
+	}
+	if fetch.Status.ErrorCount > 0 {
+		return fmt.Errorf("fetching %s: %s", fetch.URL, fetch.Status.LastError)
+	}
+
+	feeds, err := c.db.ReadAll(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+
+	for _, feed := range feeds {
+		if feed.URL != fetch.URL {
+			continue
+		}
+
+		fmt.Fprintf(c.out, "%s (%d items)\n", feed.Title, len(feed.Items))
+		tw := tabwriter.NewWriter(c.out, 0, 8, 2, ' ', 0)
+		for _, item := range feed.Items {
+			fmt.Fprintf(tw, "  %s\t%s\t%s\n", formatTime(item.PubDate), item.Title, item.Link)
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+	}
+
+	fmt.Fprintln(c.out, "next poll at", formatTime(fetch.NextPoll))
+	return nil
+}
+
+// prune removes any items that fall outside of the retention.
+func (c cli) prune(ctx context.Context) error {
+	removed, err := c.db.Prune(ctx)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(c.out, "removed %d items\n", removed)
+	return nil
+}
+
+// retention sets how many items are kept for the feed at uri: keep is a number
+// of items like "10", a number of days like "90d", or "default" to go back to
+// using --keep-items and --keep-days. The items already stored are pruned to
+// match straight away.
+func (c cli) retention(ctx context.Context, uri, keep string) error {
+	subs, err := c.db.Subscriptions(ctx)
+	if err != nil {
+		return err
+	}
+	if !slices.Contains(subs, uri) {
+		return fmt.Errorf("not subscribed to %s", uri)
+	}
+
+	var retention *data.Retention
+	switch {
+	case keep == "default":
+	case strings.HasSuffix(keep, "d"):
+		days, err := strconv.Atoi(strings.TrimSuffix(keep, "d"))
+		if err != nil || days < 0 {
+			return fmt.Errorf("invalid number of days %q", keep)
+		}
+		retention = &data.Retention{Days: days}
+	default:
+		items, err := strconv.Atoi(keep)
+		if err != nil || items < 0 {
+			return fmt.Errorf("invalid number of items %q, give a number like 10, days like 90d, or default", keep)
+		}
+		retention = &data.Retention{Items: items}
+	}
+
+	if err := c.db.SetFeedRetention(ctx, uri, retention); err != nil {
+		return err
+	}
+
+	removed, err := c.db.Prune(ctx)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(c.out, "retention for %s set to %s, removed %d items\n", uri, keep, removed)
+	return nil
+}
+
+// stats prints counts of what is stored.
+func (c cli) stats(ctx context.Context) error {
+	stats, err := c.db.Stats(ctx)
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(c.out, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "feeds\t%d\n", stats.Feeds)
+	fmt.Fprintf(tw, "  failing\t%d\n", stats.Failing)
+	fmt.Fprintf(tw, "  dead\t%d\n", stats.Dead)
+	fmt.Fprintf(tw, "  gone\t%d\n", stats.Gone)
+	fmt.Fprintf(tw, "items\t%d\n", stats.Items)
+	fmt.Fprintf(tw, "  unread\t%d\n", stats.Unread)
+	fmt.Fprintf(tw, "newest item\t%s\n", formatTime(stats.NewestItem))
+	fmt.Fprintf(tw, "users\t%d\n", stats.Users)
+
+	return tw.Flush()
+}
+
+// users prints every user with the profile URL they sign in with.
+func (c cli) users(ctx context.Context) error {
+	users, err := c.db.Users(ctx)
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(c.out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tME")
+	for _, user := range users {
+		if user.Name == "" {
+			continue
+		}
+		fmt.Fprintf(tw, "%s\t%s\n", user.Name, user.Me)
+	}
+
+	return tw.Flush()
+}
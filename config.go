@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// envPrefix starts the name of the environment variable for each flag, so
+// --keep-items can be given as ARBORETUM_KEEP_ITEMS.
+const envPrefix = "ARBORETUM_"
+
+// devSecret was the default for --secret, so is known to anyone that has read
+// the source. It is only allowed with --dev.
+const devSecret = "GpgGqpnfFkpjgXj7u3RCdKkoOf/tQqbHkOuuys90Ds4="
+
+// secretSetting is the key the generated cookie secret is stored under.
+const secretSetting = "cookie-secret"
+
+func envName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// configure fills in the flags that were not given on the command line, first
+// from environment variables and then from the JSON config file at path, if
+// given. Flags that are in neither keep their default.
+func configure(flags *flag.FlagSet, path string, lookupEnv func(string) (string, bool)) error {
+	given := map[string]bool{}
+	flags.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+
+	file := map[string]string{}
+	if path != "" {
+		var err error
+		if file, err = readConfig(path); err != nil {
+			return err
+		}
+
+		for name := range file {
+			if name == "config" || flags.Lookup(name) == nil {
+				return fmt.Errorf("unknown setting %q in %s", name, path)
+			}
+		}
+	}
+
+	var err error
+	flags.VisitAll(func(f *flag.Flag) {
+		if err != nil || given[f.Name] {
+			return
+		}
+
+		value, ok := lookupEnv(envName(f.Name))
+		if !ok {
+			value, ok = file[f.Name]
+		}
+		if !ok {
+			return
+		}
+
+		if serr := flags.Set(f.Name, value); serr != nil {
+			err = fmt.Errorf("setting %s: %w", f.Name, serr)
+		}
+	})
+
+	return err
+}
+
+// readConfig reads a JSON object of flag names to values from the file at
+// path.
+func readConfig(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var raw map[string]any
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+
+	settings := map[string]string{}
+	for name, value := range raw {
+		switch v := value.(type) {
+		case string:
+			settings[name] = v
+		case bool:
+			settings[name] = strconv.FormatBool(v)
+		case float64:
+			settings[name] = strconv.FormatFloat(v, 'f', -1, 64)
+		default:
+			return nil, fmt.Errorf("setting %q in %s must be a string, number or boolean", name, path)
+		}
+	}
+
+	return settings, nil
+}
+
+// cookieSecret decides the secret to use for cookies. When none is given a
+// random secret is generated on first run, then stored so that sessions last
+// across restarts.
+func cookieSecret(ctx context.Context, store interface {
+	Setting(context.Context, string) (string, bool, error)
+	SetSetting(context.Context, string, string) error
+}, secret string, dev bool) (string, error) {
+	if secret == devSecret && !dev {
+		return "", errors.New("refusing to use the built-in --secret, remove it to have a secret generated or run with --dev")
+	}
+	if secret != "" {
+		return secret, nil
+	}
+	if dev {
+		return devSecret, nil
+	}
+
+	stored, ok, err := store.Setting(ctx, secretSetting)
+	if err != nil {
+		return "", err
+	}
+	if ok {
+		return stored, nil
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	generated := base64.StdEncoding.EncodeToString(b)
+
+	if err := store.SetSetting(ctx, secretSetting, generated); err != nil {
+		return "", err
+	}
+
+	slog.Info("generated cookie secret")
+	return generated, nil
+}
@@ -0,0 +1,217 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+)
+
+// A Subscription is a feed that is subscribed to, along with how it is
+// organised.
+type Subscription struct {
+	URL        string
+	WebsiteURL string
+	Title      string
+	// Category is the path of folder names the feed is filed under, it is empty
+	// for feeds that are not in a folder.
+	Category []string
+}
+
+// AddSubscription subscribes user to the feed, filing it under its category.
+// If already subscribed the feed is moved to the category, and the title and
+// website are only set if not yet known.
+func (d *DB) AddSubscription(ctx context.Context, user int64, sub Subscription) (err error) {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		action := tx.Rollback
+		if err == nil {
+			action = tx.Commit
+		}
+
+		if rerr := action(); rerr != nil {
+			slog.Error("completing transaction", slog.Any("err", rerr))
+		}
+	}()
+
+	categoryID, err := ensureCategory(ctx, tx, sub.Category)
+	if err != nil {
+		return err
+	}
+
+	if _, err = tx.ExecContext(ctx,
+		`INSERT INTO feeds (URL, WebsiteURL, Title)
+		VALUES (?,   ?,          ?)
+		ON CONFLICT (URL) DO UPDATE SET
+			WebsiteURL = COALESCE(NULLIF(feeds.WebsiteURL, ''), excluded.WebsiteURL),
+			Title = COALESCE(NULLIF(feeds.Title, ''), excluded.Title)`,
+		sub.URL,
+		sub.WebsiteURL,
+		sub.Title); err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO subscriptions (UserID, FeedURL, CategoryID)
+		VALUES (?,      ?,       ?)
+		ON CONFLICT (UserID, FeedURL) DO UPDATE SET
+			CategoryID = excluded.CategoryID`,
+		user,
+		sub.URL,
+		categoryID)
+
+	return err
+}
+
+// SetCategory files the feed at uri under category for user. An empty
+// category removes the feed from any folder.
+func (d *DB) SetCategory(ctx context.Context, user int64, uri string, category []string) (err error) {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		action := tx.Rollback
+		if err == nil {
+			action = tx.Commit
+		}
+
+		if rerr := action(); rerr != nil {
+			slog.Error("completing transaction", slog.Any("err", rerr))
+		}
+	}()
+
+	categoryID, err := ensureCategory(ctx, tx, category)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx,
+		"UPDATE subscriptions SET CategoryID = ? WHERE UserID = ? AND FeedURL = ?",
+		categoryID,
+		user,
+		uri)
+
+	return err
+}
+
+// SubscriptionDetails lists every subscription of user with its title and
+// category.
+func (d *DB) SubscriptionDetails(ctx context.Context, user int64) ([]Subscription, error) {
+	paths, err := d.categoryPaths(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := d.db.QueryContext(ctx,
+		`SELECT f.URL, f.WebsiteURL, f.Title, s.CategoryID
+		 FROM subscriptions s
+		 JOIN feeds f ON f.URL = s.FeedURL
+		 WHERE s.UserID = ?
+		 ORDER BY f.URL`,
+		user)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var subs []Subscription
+	for rows.Next() {
+		var (
+			sub               Subscription
+			websiteURL, title sql.NullString
+			categoryID        sql.NullInt64
+		)
+		if err := rows.Scan(&sub.URL, &websiteURL, &title, &categoryID); err != nil {
+			return nil, fmt.Errorf("scanning row: %w", err)
+		}
+
+		sub.WebsiteURL = websiteURL.String
+		sub.Title = title.String
+		sub.Category = paths[categoryID.Int64]
+		subs = append(subs, sub)
+	}
+
+	return subs, rows.Err()
+}
+
+// ensureCategory finds the category at path, creating any folders that do not
+// exist. An empty path gives a NULL ID.
+func ensureCategory(ctx context.Context, tx sqlTx, path []string) (sql.NullInt64, error) {
+	var id int64
+	for _, name := range path {
+		if _, err := tx.ExecContext(ctx,
+			"INSERT INTO categories (Name, ParentID) VALUES (?, ?) ON CONFLICT DO NOTHING",
+			name,
+			id); err != nil {
+			return sql.NullInt64{}, fmt.Errorf("creating category %q: %w", name, err)
+		}
+
+		if err := tx.QueryRowContext(ctx,
+			"SELECT ID FROM categories WHERE Name = ? AND ParentID = ?",
+			name,
+			id).Scan(&id); err != nil {
+			return sql.NullInt64{}, fmt.Errorf("finding category %q: %w", name, err)
+		}
+	}
+
+	return sql.NullInt64{Int64: id, Valid: id != 0}, nil
+}
+
+// categoryPaths maps the ID of every category to the names of the folders
+// leading to it.
+func (d *DB) categoryPaths(ctx context.Context) (map[int64][]string, error) {
+	rows, err := d.db.QueryContext(ctx, "SELECT ID, Name, ParentID FROM categories")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	type category struct {
+		name   string
+		parent int64
+	}
+
+	categories := map[int64]category{}
+	for rows.Next() {
+		var (
+			id int64
+			c  category
+		)
+		if err := rows.Scan(&id, &c.name, &c.parent); err != nil {
+			return nil, fmt.Errorf("scanning row: %w", err)
+		}
+		categories[id] = c
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	paths := map[int64][]string{}
+	var pathOf func(id int64, depth int) []string
+	pathOf = func(id int64, depth int) []string {
+		if path, ok := paths[id]; ok {
+			return path
+		}
+
+		c, ok := categories[id]
+		// the depth check guards against a cycle in the stored data
+		if !ok || depth > len(categories) {
+			return nil
+		}
+
+		parent := pathOf(c.parent, depth+1)
+		path := append(parent[:len(parent):len(parent)], c.name)
+		paths[id] = path
+		return path
+	}
+
+	for id := range categories {
+		pathOf(id, 0)
+	}
+
+	return paths, nil
+}
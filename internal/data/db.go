@@ -18,7 +18,52 @@ type Feed struct {
 	WebsiteURL string
 	Title      string
 	UpdatedAt  time.Time
-	Items      []FeedItem
+	// Category is the path of folder names the feed is filed under.
+	Category []string
+	Status   FeedStatus
+	Settings FeedSettings
+	Items    []FeedItem
+}
+
+// FeedSettings are the choices a user has made for a feed they subscribe to,
+// taking the place of what the feed or the global options would otherwise
+// decide.
+type FeedSettings struct {
+	// Title is shown instead of the title the feed gives, if set.
+	Title string
+	// Refresh is how long to wait between polls, or 0 to use the default.
+	Refresh time.Duration
+	// Paused is set to stop the feed being polled.
+	Paused bool
+}
+
+// FeedStatus records how fetching a feed has been going.
+type FeedStatus struct {
+	// ErrorCount is the number of consecutive failed fetches.
+	ErrorCount int
+	LastError  string
+	// FailingSince is when the current run of failures started.
+	FailingSince  time.Time
+	LastSuccessAt time.Time
+	// Dead is set when the feed has been failing for too long.
+	Dead bool
+	// Gone is set when the server has said the feed no longer exists, it
+	// should not be fetched again.
+	Gone bool
+}
+
+// FeedReport is how polling a feed is going, as last seen by the garden.
+type FeedReport struct {
+	URL string
+	// LastFetch is when the feed was last fetched, or zero if it never has
+	// been.
+	LastFetch time.Time
+	// StatusCode is the status of the last response, -1 if no response was
+	// received, or 0 if the feed has not been fetched since starting.
+	StatusCode int
+	// Error is the reason the last fetch failed, if it did.
+	Error    string
+	NextPoll time.Time
 }
 
 type FeedItem struct {
@@ -27,56 +72,115 @@ type FeedItem struct {
 	PubDate   time.Time
 	Title     string
 	Link      string
+	// Summary is a short plain text description of the item.
+	Summary string
+	// Content is the body of the item as sanitized HTML.
+	Content   string
+	Author    string
+	Enclosure Enclosure
+	// Read is set once the item has been marked as read.
+	Read bool
+}
+
+// Enclosure is a file attached to an item, such as a podcast episode. The URL
+// is empty when there is no enclosure.
+type Enclosure struct {
+	URL    string
+	Type   string
+	Length int64
+}
+
+// Retention limits the items kept for a feed. A zero value for either field
+// means there is no limit of that kind.
+type Retention struct {
+	// Items is the maximum number of items to keep.
+	Items int
+	// Days is the number of days after publication that an item is kept for.
+	Days int
 }
 
+// DefaultRetention keeps the seven newest items of each feed.
+var DefaultRetention = Retention{Items: 7}
+
+// DB stores everything in either SQLite or PostgreSQL.
 type DB struct {
-	db *sql.DB
+	db        sqlDB
+	retention Retention
+	// search is set when the full-text index is available.
+	search bool
 }
 
+// Open opens the database at path, applying any pending migrations. A
+// postgres:// URL opens a PostgreSQL database, anything else is the path to a
+// SQLite database.
 func Open(path string) (*DB, error) {
-	sqlite, err := sql.Open("sqlite3", path)
+	db, err := OpenWithoutMigrating(path)
 	if err != nil {
 		return nil, err
 	}
 
-	db := &DB{db: sqlite}
+	if err := db.migrate(); err != nil {
+		db.Close()
+		return nil, err
+	}
 
-	return db, db.migrate()
+	if err := db.buildSearchIndex(context.Background()); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return db, nil
 }
 
-func (d *DB) migrate() error {
-	_, err := d.db.Exec(`
-		CREATE TABLE IF NOT EXISTS feeds (
-			URL         TEXT NOT NULL PRIMARY KEY,
-			WebsiteURL  TEXT,
-			Title       TEXT,
-			UpdatedAt   DATETIME
-		);
+// OpenWithoutMigrating opens the database at path leaving the schema as it is.
+func OpenWithoutMigrating(path string) (*DB, error) {
+	dialect := dialectFor(path)
 
-		CREATE TABLE IF NOT EXISTS feedItems (
-			Key       TEXT NOT NULL,
-			FeedURL   TEXT NOT NULL,
-			PermaLink TEXT,
-			PubDate   DATETIME,
-			Title     TEXT,
-			Link      TEXT,
-			PRIMARY KEY (Key, FeedURL)
-		);
-`)
+	db, err := sql.Open(dialect.driver, path)
+	if err != nil {
+		return nil, err
+	}
 
-	return err
+	// each connection to ":memory:" gets its own empty database, so make sure
+	// only one is ever opened
+	if path == ":memory:" {
+		db.SetMaxOpenConns(1)
+	}
+
+	return &DB{db: sqlDB{DB: db, dialect: dialect}, retention: DefaultRetention}, nil
 }
 
 func (d *DB) Close() error {
 	return d.db.Close()
 }
 
-func (d *DB) ReadAll(ctx context.Context) ([]Feed, error) {
+// Ping checks that the database can still be reached.
+func (d *DB) Ping(ctx context.Context) error {
+	return d.db.PingContext(ctx)
+}
+
+// ReadAll returns the feeds that user subscribes to, with their items. Feeds
+// that have never been fetched successfully are included without any items,
+// so that their status can still be shown.
+func (d *DB) ReadAll(ctx context.Context, user int64) ([]Feed, error) {
+	paths, err := d.categoryPaths(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	rows, err := d.db.QueryContext(ctx,
-		`SELECT i.Key, i.PermaLink, i.PubDate, i.Title, i.Link, f.WebsiteURL, f.Title, f.UpdatedAt, f.URL
-		 FROM feedItems i
-		 JOIN feeds f ON f.URL = i.FeedURL
-		 ORDER BY FeedURL, PubDate DESC`)
+		`SELECT i.Key, i.PermaLink, i.PubDate, i.Title, i.Link,
+		        i.Summary, i.Content, i.Author, i.EnclosureURL, i.EnclosureType, i.EnclosureLength,
+		        f.WebsiteURL, f.Title, f.UpdatedAt, f.URL,
+		        f.ErrorCount, f.LastError, f.Dead, f.Gone, r.ReadAt IS NOT NULL, s.CategoryID,
+		        s.CustomTitle, s.RefreshSeconds, s.Paused
+		 FROM subscriptions s
+		 JOIN feeds f ON f.URL = s.FeedURL
+		 LEFT JOIN feedItems i ON i.FeedURL = s.FeedURL
+		 LEFT JOIN itemReads r ON r.UserID = s.UserID AND r.Key = i.Key AND r.FeedURL = i.FeedURL
+		 WHERE s.UserID = ?
+		 ORDER BY f.URL, i.PubDate DESC`,
+		user)
 	if err != nil {
 		return nil, err
 	}
@@ -86,25 +190,77 @@ func (d *DB) ReadAll(ctx context.Context) ([]Feed, error) {
 
 	for rows.Next() {
 		var (
-			websiteURL, title, feedURL string
-			updatedAt                  time.Time
-			item                       FeedItem
+			feedURL                                   string
+			websiteURL, title                         sql.NullString
+			updatedAt                                 *time.Time
+			key, permaLink, itemTitle, link           sql.NullString
+			summary, content, author, encURL, encType sql.NullString
+			pubDate                                   *time.Time
+			encLength                                 sql.NullInt64
+			read                                      bool
+			errorCount                                int
+			lastError                                 sql.NullString
+			dead, gone, paused                        bool
+			categoryID, refresh                       sql.NullInt64
+			customTitle                               sql.NullString
 		)
-		if err = rows.Scan(&item.Key, &item.PermaLink, &item.PubDate, &item.Title, &item.Link, &websiteURL, &title, &updatedAt, &feedURL); err != nil {
+		if err = rows.Scan(&key, &permaLink, &pubDate, &itemTitle, &link,
+			&summary, &content, &author, &encURL, &encType, &encLength,
+			&websiteURL, &title, &updatedAt, &feedURL,
+			&errorCount, &lastError, &dead, &gone, &read, &categoryID,
+			&customTitle, &refresh, &paused); err != nil {
 			return nil, fmt.Errorf("scanning row: %w", err)
 		}
 
-		if feed, ok := feedsMap[feedURL]; ok {
-			feed.Items = append(feed.Items, item)
-		} else {
-			feedsMap[feedURL] = &Feed{
+		feed, ok := feedsMap[feedURL]
+		if !ok {
+			feed = &Feed{
 				URL:        feedURL,
-				WebsiteURL: websiteURL,
-				Title:      title,
-				UpdatedAt:  updatedAt,
-				Items:      []FeedItem{item},
+				WebsiteURL: websiteURL.String,
+				Title:      title.String,
+				Category:   paths[categoryID.Int64],
+				Status: FeedStatus{
+					ErrorCount: errorCount,
+					LastError:  lastError.String,
+					Dead:       dead,
+					Gone:       gone,
+				},
+				Settings: FeedSettings{
+					Title:   customTitle.String,
+					Refresh: time.Duration(refresh.Int64) * time.Second,
+					Paused:  paused,
+				},
+			}
+			if updatedAt != nil {
+				feed.UpdatedAt = *updatedAt
 			}
+			feedsMap[feedURL] = feed
 		}
+
+		// a feed without items still has a row, with every item column NULL
+		if !key.Valid {
+			continue
+		}
+
+		item := FeedItem{
+			Key:       key.String,
+			PermaLink: permaLink.String,
+			Title:     itemTitle.String,
+			Link:      link.String,
+			Summary:   summary.String,
+			Content:   content.String,
+			Author:    author.String,
+			Enclosure: Enclosure{
+				URL:    encURL.String,
+				Type:   encType.String,
+				Length: encLength.Int64,
+			},
+			Read: read,
+		}
+		if pubDate != nil {
+			item.PubDate = *pubDate
+		}
+		feed.Items = append(feed.Items, item)
 	}
 
 	var feeds []Feed
@@ -116,7 +272,14 @@ func (d *DB) ReadAll(ctx context.Context) ([]Feed, error) {
 		return feeds, fmt.Errorf("rows err: %w", err)
 	}
 
+	// feeds with the newest items come first, those without any last
 	sort.Slice(feeds, func(i, j int) bool {
+		if len(feeds[i].Items) == 0 || len(feeds[j].Items) == 0 {
+			if len(feeds[i].Items) == len(feeds[j].Items) {
+				return feeds[i].URL < feeds[j].URL
+			}
+			return len(feeds[i].Items) > 0
+		}
 		return feeds[i].Items[0].PubDate.After(feeds[j].Items[0].PubDate)
 	})
 
@@ -149,12 +312,118 @@ func (d *DB) UpdatedAt(ctx context.Context, uri string) (time.Time, error) {
 	return *updatedAt, nil
 }
 
+// NextPoll returns when the feed at uri is next due to be fetched. It returns
+// the zero time if it has never been scheduled.
+func (d *DB) NextPoll(ctx context.Context, uri string) (time.Time, error) {
+	row := d.db.QueryRowContext(ctx,
+		"SELECT NextPollAt FROM feeds WHERE URL = ?",
+		uri)
+
+	var nextPoll *time.Time
+	if err := row.Scan(&nextPoll); err != nil {
+		return time.Time{}, fmt.Errorf("scanning feed row: %w", err)
+	}
+
+	if nextPoll == nil {
+		return time.Time{}, nil
+	}
+
+	return *nextPoll, nil
+}
+
+func (d *DB) SetNextPoll(ctx context.Context, uri string, nextPoll time.Time) error {
+	_, err := d.db.ExecContext(ctx,
+		"UPDATE feeds SET NextPollAt = ? WHERE URL = ?",
+		nextPoll,
+		uri)
+
+	return err
+}
+
+func (d *DB) FeedStatus(ctx context.Context, uri string) (FeedStatus, error) {
+	row := d.db.QueryRowContext(ctx,
+		"SELECT ErrorCount, LastError, FailingSince, LastSuccessAt, Dead, Gone FROM feeds WHERE URL = ?",
+		uri)
+
+	var (
+		status                      FeedStatus
+		lastError                   sql.NullString
+		failingSince, lastSuccessAt *time.Time
+	)
+	if err := row.Scan(&status.ErrorCount, &lastError, &failingSince, &lastSuccessAt, &status.Dead, &status.Gone); err != nil {
+		return FeedStatus{}, fmt.Errorf("scanning feed row: %w", err)
+	}
+
+	status.LastError = lastError.String
+	if failingSince != nil {
+		status.FailingSince = *failingSince
+	}
+	if lastSuccessAt != nil {
+		status.LastSuccessAt = *lastSuccessAt
+	}
+
+	return status, nil
+}
+
+func (d *DB) SetFeedStatus(ctx context.Context, uri string, status FeedStatus) error {
+	_, err := d.db.ExecContext(ctx,
+		`UPDATE feeds
+		 SET ErrorCount = ?, LastError = ?, FailingSince = ?, LastSuccessAt = ?, Dead = ?, Gone = ?
+		 WHERE URL = ?`,
+		status.ErrorCount,
+		nullString(status.LastError),
+		nullTime(status.FailingSince),
+		nullTime(status.LastSuccessAt),
+		status.Dead,
+		status.Gone,
+		uri)
+
+	return err
+}
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+func nullTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+
+	return &t
+}
+
+// Validators returns the ETag and Last-Modified values last given by the server
+// for the feed at uri.
+func (d *DB) Validators(ctx context.Context, uri string) (etag, lastModified string, err error) {
+	row := d.db.QueryRowContext(ctx,
+		"SELECT ETag, LastModified FROM feeds WHERE URL = ?",
+		uri)
+
+	var e, l sql.NullString
+	if err := row.Scan(&e, &l); err != nil {
+		return "", "", fmt.Errorf("scanning feed row: %w", err)
+	}
+
+	return e.String, l.String, nil
+}
+
+func (d *DB) SetValidators(ctx context.Context, uri, etag, lastModified string) error {
+	_, err := d.db.ExecContext(ctx,
+		"UPDATE feeds SET ETag = ?, LastModified = ? WHERE URL = ?",
+		etag,
+		lastModified,
+		uri)
+
+	return err
+}
+
 func (d *DB) UpdateFeed(ctx context.Context, feed Feed) (err error) {
 	if len(feed.Items) == 0 {
 		return nil
 	}
 
-	tx, err := d.db.Begin()
+	tx, err := d.db.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
@@ -169,81 +438,409 @@ func (d *DB) UpdateFeed(ctx context.Context, feed Feed) (err error) {
 		}
 	}()
 
-	row := tx.QueryRowContext(ctx,
-		`SELECT Key FROM feedItems WHERE FeedURL = ? ORDER BY PubDate DESC LIMIT 1`,
-		feed.URL)
-
-	var lastKey string
-	if err := row.Scan(&lastKey); err != nil && err != sql.ErrNoRows {
-		return err
-	}
-
-	_, err = tx.ExecContext(ctx,
-		`REPLACE INTO feeds (URL, WebsiteURL, Title, UpdatedAt)
-		VALUES (?,   ?,          ?,     ?)`,
-		feed.URL,
+	// the feed is only updated, so that a fetch finishing after the last
+	// subscriber has left does not bring it back
+	result, err := tx.ExecContext(ctx,
+		"UPDATE feeds SET WebsiteURL = ?, Title = ?, UpdatedAt = ? WHERE URL = ?",
 		feed.WebsiteURL,
 		feed.Title,
-		feed.UpdatedAt)
+		feed.UpdatedAt,
+		feed.URL)
 	if err != nil {
 		return err
 	}
-
-	sort.Slice(feed.Items, func(i, j int) bool {
-		return feed.Items[i].PubDate.After(feed.Items[j].PubDate)
-	})
-	if feed.Items[0].Key == lastKey {
-		return nil
+	if updated, err := result.RowsAffected(); err != nil || updated == 0 {
+		return err
 	}
 
-	_, err = tx.ExecContext(ctx,
-		`DELETE FROM feedItems WHERE FeedURL = ?`,
-		feed.URL)
+	stmt, err := tx.PrepareContext(ctx, `INSERT INTO feedItems (Key, FeedURL, PermaLink, PubDate, Title, Link, Summary, Content, Author, EnclosureURL, EnclosureType, EnclosureLength)
+																					VALUES (?,   ?,       ?,         ?,       ?,     ?,    ?,       ?,       ?,      ?,            ?,             ?)
+		ON CONFLICT (Key, FeedURL) DO UPDATE SET
+			PermaLink = excluded.PermaLink,
+			PubDate = excluded.PubDate,
+			Title = excluded.Title,
+			Link = excluded.Link,
+			Summary = excluded.Summary,
+			Content = excluded.Content,
+			Author = excluded.Author,
+			EnclosureURL = excluded.EnclosureURL,
+			EnclosureType = excluded.EnclosureType,
+			EnclosureLength = excluded.EnclosureLength`)
 	if err != nil {
 		return err
 	}
+	defer stmt.Close()
+
+	for _, item := range feed.Items {
+		_, err = stmt.ExecContext(ctx, item.Key, feed.URL, item.PermaLink, item.PubDate, item.Title, item.Link,
+			item.Summary, item.Content, item.Author, item.Enclosure.URL, item.Enclosure.Type, item.Enclosure.Length)
+		if err != nil {
+			return fmt.Errorf("%s: %w", item.Key, err)
+		}
+	}
 
-	stmt, err := tx.Prepare(`INSERT INTO feedItems (Key, FeedURL, PermaLink, PubDate, Title, Link)
-																					VALUES (?,   ?,       ?,         ?,       ?,     ?)`)
+	return d.prune(ctx, tx, feed.URL)
+}
+
+// prune removes the items of the feed at uri that fall outside of its
+// retention. The newest item is always kept so that quiet feeds still appear.
+func (d *DB) prune(ctx context.Context, tx sqlTx, uri string) error {
+	retention, err := d.feedRetention(ctx, tx, uri)
 	if err != nil {
 		return err
 	}
-	defer stmt.Close()
 
-	if len(feed.Items) > 7 {
-		feed.Items = feed.Items[:7]
+	if retention.Items > 0 {
+		_, err := tx.ExecContext(ctx,
+			`DELETE FROM feedItems
+			 WHERE FeedURL = ? AND Key NOT IN (
+				 SELECT Key FROM feedItems WHERE FeedURL = ? ORDER BY PubDate DESC LIMIT ?
+			 )`,
+			uri,
+			uri,
+			retention.Items)
+		if err != nil {
+			return fmt.Errorf("pruning by count: %w", err)
+		}
 	}
 
-	for _, item := range feed.Items {
-		_, err = stmt.ExecContext(ctx, item.Key, feed.URL, item.PermaLink, item.PubDate, item.Title, item.Link)
+	if retention.Days > 0 {
+		_, err := tx.ExecContext(ctx,
+			`DELETE FROM feedItems
+			 WHERE FeedURL = ? AND PubDate < ? AND Key NOT IN (
+				 SELECT Key FROM feedItems WHERE FeedURL = ? ORDER BY PubDate DESC LIMIT 1
+			 )`,
+			uri,
+			time.Now().AddDate(0, 0, -retention.Days),
+			uri)
 		if err != nil {
-			return fmt.Errorf("%s: %w", item.Key, err)
+			return fmt.Errorf("pruning by age: %w", err)
 		}
 	}
 
+	_, err = tx.ExecContext(ctx,
+		`DELETE FROM itemReads
+		 WHERE FeedURL = ? AND Key NOT IN (
+			 SELECT Key FROM feedItems WHERE FeedURL = ?
+		 )`,
+		uri,
+		uri)
+	if err != nil {
+		return fmt.Errorf("pruning read state: %w", err)
+	}
+
 	return nil
 }
 
-func (d *DB) Subscribe(ctx context.Context, uri string) error {
-	_, err := d.db.ExecContext(ctx, "INSERT OR IGNORE INTO feeds (URL) VALUES (?)",
+func (d *DB) feedRetention(ctx context.Context, tx sqlTx, uri string) (Retention, error) {
+	row := tx.QueryRowContext(ctx,
+		"SELECT KeepItems, KeepDays FROM feeds WHERE URL = ?",
+		uri)
+
+	var items, days sql.NullInt64
+	if err := row.Scan(&items, &days); err != nil {
+		return Retention{}, fmt.Errorf("scanning feed retention: %w", err)
+	}
+
+	retention := d.retention
+	if items.Valid {
+		retention.Items = int(items.Int64)
+	}
+	if days.Valid {
+		retention.Days = int(days.Int64)
+	}
+
+	return retention, nil
+}
+
+// SetRetention sets the retention used for feeds that do not have their own.
+func (d *DB) SetRetention(retention Retention) {
+	d.retention = retention
+}
+
+// SetFeedRetention overrides the retention for the feed at uri. Passing nil
+// returns the feed to using the default retention.
+func (d *DB) SetFeedRetention(ctx context.Context, uri string, retention *Retention) error {
+	var items, days sql.NullInt64
+	if retention != nil {
+		items = sql.NullInt64{Int64: int64(retention.Items), Valid: true}
+		days = sql.NullInt64{Int64: int64(retention.Days), Valid: true}
+	}
+
+	_, err := d.db.ExecContext(ctx,
+		"UPDATE feeds SET KeepItems = ?, KeepDays = ? WHERE URL = ?",
+		items,
+		days,
+		uri)
+
+	return err
+}
+
+// FeedSettings returns the settings user has chosen for the feed at uri.
+func (d *DB) FeedSettings(ctx context.Context, user int64, uri string) (FeedSettings, error) {
+	row := d.db.QueryRowContext(ctx,
+		"SELECT CustomTitle, RefreshSeconds, Paused FROM subscriptions WHERE UserID = ? AND FeedURL = ?",
+		user,
+		uri)
+
+	var (
+		settings FeedSettings
+		title    sql.NullString
+		refresh  sql.NullInt64
+	)
+	if err := row.Scan(&title, &refresh, &settings.Paused); err != nil {
+		return FeedSettings{}, fmt.Errorf("scanning feed settings: %w", err)
+	}
+
+	settings.Title = title.String
+	settings.Refresh = time.Duration(refresh.Int64) * time.Second
+	return settings, nil
+}
+
+// SetFeedSettings replaces the settings user has chosen for the feed at uri.
+// The refresh is stored to the second. It returns false, changing nothing, if
+// user does not subscribe to uri.
+func (d *DB) SetFeedSettings(ctx context.Context, user int64, uri string, settings FeedSettings) (bool, error) {
+	var refresh sql.NullInt64
+	if seconds := int64(settings.Refresh / time.Second); seconds > 0 {
+		refresh = sql.NullInt64{Int64: seconds, Valid: true}
+	}
+
+	result, err := d.db.ExecContext(ctx,
+		"UPDATE subscriptions SET CustomTitle = ?, RefreshSeconds = ?, Paused = ? WHERE UserID = ? AND FeedURL = ?",
+		nullString(settings.Title),
+		refresh,
+		settings.Paused,
+		user,
+		uri)
+	if err != nil {
+		return false, err
+	}
+
+	changed, err := result.RowsAffected()
+	return changed > 0, err
+}
+
+// PollSettings combines the settings of everyone subscribed to the feed at uri
+// into how it should be polled. It is only paused once every subscriber has
+// paused it, and is refreshed as often as the shortest refresh chosen by those
+// that have not, or by anyone when it is paused. The title is left empty.
+func (d *DB) PollSettings(ctx context.Context, uri string) (FeedSettings, error) {
+	row := d.db.QueryRowContext(ctx,
+		`SELECT COUNT(*),
+		        COUNT(CASE WHEN Paused THEN 1 END),
+		        MIN(CASE WHEN NOT Paused THEN RefreshSeconds END),
+		        MIN(RefreshSeconds)
+		 FROM subscriptions
+		 WHERE FeedURL = ?`,
+		uri)
+
+	var (
+		subscribers, paused int
+		active, all         sql.NullInt64
+	)
+	if err := row.Scan(&subscribers, &paused, &active, &all); err != nil {
+		return FeedSettings{}, fmt.Errorf("scanning poll settings: %w", err)
+	}
+
+	settings := FeedSettings{
+		Refresh: time.Duration(active.Int64) * time.Second,
+		Paused:  subscribers > 0 && paused == subscribers,
+	}
+	if settings.Paused {
+		settings.Refresh = time.Duration(all.Int64) * time.Second
+	}
+
+	return settings, nil
+}
+
+// Subscribe adds the feed at uri to the subscriptions of user.
+func (d *DB) Subscribe(ctx context.Context, user int64, uri string) (err error) {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		action := tx.Rollback
+		if err == nil {
+			action = tx.Commit
+		}
+
+		if rerr := action(); rerr != nil {
+			slog.Error("completing transaction", slog.Any("err", rerr))
+		}
+	}()
+
+	if _, err = tx.ExecContext(ctx, "INSERT INTO feeds (URL) VALUES (?) ON CONFLICT DO NOTHING", uri); err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, "INSERT INTO subscriptions (UserID, FeedURL) VALUES (?, ?) ON CONFLICT DO NOTHING",
+		user,
 		uri)
 
 	return err
 }
 
-func (d *DB) Unsubscribe(ctx context.Context, uri string) error {
-	_, err := d.db.ExecContext(ctx, "DELETE FROM feedItems WHERE FeedURL = ?", uri)
+// Unsubscribe removes the feed at uri from the subscriptions of user, along
+// with what they have read. When no one else subscribes to the feed it is
+// deleted, with its items, and removed is true.
+func (d *DB) Unsubscribe(ctx context.Context, user int64, uri string) (removed bool, err error) {
+	tx, err := d.db.BeginTx(ctx, nil)
 	if err != nil {
+		return false, err
+	}
+	defer func() {
+		action := tx.Rollback
+		if err == nil {
+			action = tx.Commit
+		}
+
+		if rerr := action(); rerr != nil {
+			slog.Error("completing transaction", slog.Any("err", rerr))
+		}
+	}()
+
+	if _, err = tx.ExecContext(ctx, "DELETE FROM itemReads WHERE UserID = ? AND FeedURL = ?", user, uri); err != nil {
+		return false, err
+	}
+
+	if _, err = tx.ExecContext(ctx, "DELETE FROM subscriptions WHERE UserID = ? AND FeedURL = ?", user, uri); err != nil {
+		return false, err
+	}
+
+	var subscribed bool
+	if err = tx.QueryRowContext(ctx,
+		"SELECT EXISTS (SELECT 1 FROM subscriptions WHERE FeedURL = ?)",
+		uri).Scan(&subscribed); err != nil {
+		return false, err
+	}
+	if subscribed {
+		return false, nil
+	}
+
+	return true, deleteFeed(ctx, tx, uri)
+}
+
+// deleteFeed removes the feed at uri and everything stored about it.
+func deleteFeed(ctx context.Context, tx sqlTx, uri string) error {
+	for _, query := range []string{
+		"DELETE FROM hubSubscriptions WHERE FeedURL = ?",
+		"DELETE FROM itemReads WHERE FeedURL = ?",
+		"DELETE FROM subscriptions WHERE FeedURL = ?",
+		"DELETE FROM feedItems WHERE FeedURL = ?",
+		"DELETE FROM feeds WHERE URL = ?",
+	} {
+		if _, err := tx.ExecContext(ctx, query, uri); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// RenameFeed moves the subscriptions, and any stored items, from one URL to
+// another. If the new URL is already subscribed to the old feed is removed,
+// with anyone subscribed to only the old feed subscribed to the new one.
+func (d *DB) RenameFeed(ctx context.Context, from, to string) (err error) {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		action := tx.Rollback
+		if err == nil {
+			action = tx.Commit
+		}
+
+		if rerr := action(); rerr != nil {
+			slog.Error("completing transaction", slog.Any("err", rerr))
+		}
+	}()
+
+	var exists bool
+	if err = tx.QueryRowContext(ctx,
+		"SELECT EXISTS (SELECT 1 FROM feeds WHERE URL = ?)",
+		to).Scan(&exists); err != nil {
 		return err
 	}
 
-	_, err = d.db.ExecContext(ctx, "DELETE FROM feeds WHERE URL = ?", uri)
+	if exists {
+		if _, err = tx.ExecContext(ctx,
+			`UPDATE subscriptions SET FeedURL = ?
+			 WHERE FeedURL = ? AND UserID NOT IN (SELECT UserID FROM subscriptions WHERE FeedURL = ?)`,
+			to,
+			from,
+			to); err != nil {
+			return err
+		}
+
+		return deleteFeed(ctx, tx, from)
+	}
+
+	for _, query := range []string{
+		"UPDATE feeds SET URL = ? WHERE URL = ?",
+		"UPDATE subscriptions SET FeedURL = ? WHERE FeedURL = ?",
+		"UPDATE feedItems SET FeedURL = ? WHERE FeedURL = ?",
+		"UPDATE itemReads SET FeedURL = ? WHERE FeedURL = ?",
+		"UPDATE hubSubscriptions SET FeedURL = ? WHERE FeedURL = ?",
+	} {
+		if _, err = tx.ExecContext(ctx, query, to, from); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// MarkItemRead marks the item with key in the feed at uri as read by user.
+func (d *DB) MarkItemRead(ctx context.Context, user int64, uri, key string) error {
+	_, err := d.db.ExecContext(ctx,
+		`INSERT INTO itemReads (UserID, Key, FeedURL, ReadAt)
+		 SELECT s.UserID, i.Key, i.FeedURL, CURRENT_TIMESTAMP
+		 FROM feedItems i
+		 JOIN subscriptions s ON s.FeedURL = i.FeedURL
+		 WHERE s.UserID = ? AND i.FeedURL = ? AND i.Key = ?
+		 ON CONFLICT DO NOTHING`,
+		user,
+		uri,
+		key)
 
 	return err
 }
 
+// MarkFeedRead marks every item currently in the feed at uri as read by user.
+func (d *DB) MarkFeedRead(ctx context.Context, user int64, uri string) error {
+	_, err := d.db.ExecContext(ctx,
+		`INSERT INTO itemReads (UserID, Key, FeedURL, ReadAt)
+		 SELECT s.UserID, i.Key, i.FeedURL, CURRENT_TIMESTAMP
+		 FROM feedItems i
+		 JOIN subscriptions s ON s.FeedURL = i.FeedURL
+		 WHERE s.UserID = ? AND i.FeedURL = ?
+		 ON CONFLICT DO NOTHING`,
+		user,
+		uri)
+
+	return err
+}
+
+// MarkAllRead marks every item currently in the garden of user as read.
+func (d *DB) MarkAllRead(ctx context.Context, user int64) error {
+	_, err := d.db.ExecContext(ctx,
+		`INSERT INTO itemReads (UserID, Key, FeedURL, ReadAt)
+		 SELECT s.UserID, i.Key, i.FeedURL, CURRENT_TIMESTAMP
+		 FROM feedItems i
+		 JOIN subscriptions s ON s.FeedURL = i.FeedURL
+		 WHERE s.UserID = ?
+		 ON CONFLICT DO NOTHING`,
+		user)
+
+	return err
+}
+
+// Subscriptions lists every feed that anyone subscribes to.
 func (d *DB) Subscriptions(ctx context.Context) (list []string, err error) {
-	rows, err := d.db.QueryContext(ctx, "SELECT URL FROM feeds")
+	rows, err := d.db.QueryContext(ctx, "SELECT DISTINCT FeedURL FROM subscriptions")
 	if err != nil {
 		return
 	}
@@ -260,3 +857,89 @@ func (d *DB) Subscriptions(ctx context.Context) (list []string, err error) {
 	err = rows.Err()
 	return
 }
+
+// ListFeeds returns every subscribed feed along with its status, ordered by
+// URL. Unlike ReadAll it includes feeds that have no items, and does not
+// return the items themselves.
+func (d *DB) ListFeeds(ctx context.Context) ([]Feed, error) {
+	rows, err := d.db.QueryContext(ctx,
+		`SELECT URL, WebsiteURL, Title, UpdatedAt, ErrorCount, LastError, FailingSince, LastSuccessAt, Dead, Gone
+		 FROM feeds
+		 ORDER BY URL`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var feeds []Feed
+	for rows.Next() {
+		var (
+			feed                                   Feed
+			websiteURL, title, lastError           sql.NullString
+			updatedAt, failingSince, lastSuccessAt *time.Time
+		)
+		if err := rows.Scan(&feed.URL, &websiteURL, &title, &updatedAt,
+			&feed.Status.ErrorCount, &lastError, &failingSince, &lastSuccessAt, &feed.Status.Dead, &feed.Status.Gone); err != nil {
+			return nil, fmt.Errorf("scanning feed row: %w", err)
+		}
+
+		feed.WebsiteURL = websiteURL.String
+		feed.Title = title.String
+		feed.Status.LastError = lastError.String
+		if updatedAt != nil {
+			feed.UpdatedAt = *updatedAt
+		}
+		if failingSince != nil {
+			feed.Status.FailingSince = *failingSince
+		}
+		if lastSuccessAt != nil {
+			feed.Status.LastSuccessAt = *lastSuccessAt
+		}
+
+		feeds = append(feeds, feed)
+	}
+
+	return feeds, rows.Err()
+}
+
+// Prune removes the items of every feed that fall outside of its retention,
+// returning the number of items removed. Items are usually only pruned when a
+// feed is updated, so this is needed after lowering the retention.
+func (d *DB) Prune(ctx context.Context) (removed int64, err error) {
+	feeds, err := d.Subscriptions(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		action := tx.Rollback
+		if err == nil {
+			action = tx.Commit
+		}
+
+		if rerr := action(); rerr != nil {
+			slog.Error("completing transaction", slog.Any("err", rerr))
+		}
+	}()
+
+	var before, after int64
+	if err = tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM feedItems").Scan(&before); err != nil {
+		return 0, err
+	}
+
+	for _, uri := range feeds {
+		if err = d.prune(ctx, tx, uri); err != nil {
+			return 0, fmt.Errorf("pruning %v: %w", uri, err)
+		}
+	}
+
+	if err = tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM feedItems").Scan(&after); err != nil {
+		return 0, err
+	}
+
+	return before - after, nil
+}
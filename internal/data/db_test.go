@@ -2,71 +2,138 @@ package data
 
 import (
 	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
 	"sort"
+	"strings"
 	"testing"
 	"time"
 
 	"hawx.me/code/assert"
 )
 
+// testDB opens an empty database for t. It is a SQLite database in memory,
+// unless ARBORETUM_TEST_POSTGRES is set to the URL of a PostgreSQL database to
+// create a schema in for each test.
+func testDB(t *testing.T) *DB {
+	dsn := os.Getenv("ARBORETUM_TEST_POSTGRES")
+	if dsn == "" {
+		db, err := Open("file:" + t.Name() + "?cache=shared&mode=memory")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { db.Close() })
+
+		return db
+	}
+
+	admin, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { admin.Close() })
+
+	schema := "test_" + strings.ToLower(t.Name())
+	if _, err := admin.Exec("DROP SCHEMA IF EXISTS " + schema + " CASCADE; CREATE SCHEMA " + schema); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if _, err := admin.Exec("DROP SCHEMA " + schema + " CASCADE"); err != nil {
+			t.Error(err)
+		}
+	})
+
+	u, err := url.Parse(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := u.Query()
+	query.Set("search_path", schema)
+	query.Set("timezone", "UTC")
+	u.RawQuery = query.Encode()
+
+	db, err := Open(u.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	return db
+}
+
+// testUser returns the ID of a user to subscribe feeds for.
+func testUser(t *testing.T, db *DB) int64 {
+	user, err := db.UnclaimedUser(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return user.ID
+}
+
 func TestReadAll(t *testing.T) {
 	assert := assert.Wrap(t)
 
-	db, err := Open("file:TestReadAll?cache=shared&mode=memory")
-	assert(err).Must.Nil()
-	defer db.Close()
+	db := testDB(t)
+	user := testUser(t, db)
 
 	feed := Feed{
 		URL:        "feed-url",
 		WebsiteURL: "website-url",
 		Title:      "feed-title",
-		UpdatedAt:  time.Now().Add(-2 * time.Hour).UTC(),
+		UpdatedAt:  time.Now().Add(-2 * time.Hour).UTC().Truncate(time.Microsecond),
 		Items: []FeedItem{
 			{
 				Key:       "item-key",
 				PermaLink: "item-permalink",
-				PubDate:   time.Now().Add(-5 * time.Minute).UTC(),
+				PubDate:   time.Now().Add(-5 * time.Minute).UTC().Truncate(time.Microsecond),
 				Title:     "item-title",
 				Link:      "item-link",
 			},
 			{
 				Key:       "item2-key",
 				PermaLink: "item2-permalink",
-				PubDate:   time.Now().Add(-10 * time.Minute).UTC(),
+				PubDate:   time.Now().Add(-10 * time.Minute).UTC().Truncate(time.Microsecond),
 				Title:     "item2-title",
 				Link:      "item2-link",
 			},
 		},
 	}
 
+	assert(db.Subscribe(context.Background(), user, feed.URL)).Must.Nil()
 	assert(db.UpdateFeed(context.Background(), feed)).Must.Nil()
 
 	feed2 := Feed{
 		URL:        "feed2-url",
 		WebsiteURL: "website2-url",
 		Title:      "feed2-title",
-		UpdatedAt:  time.Now().Add(-time.Hour).UTC(),
+		UpdatedAt:  time.Now().Add(-time.Hour).UTC().Truncate(time.Microsecond),
 		Items: []FeedItem{
 			{
 				Key:       "2item-key",
 				PermaLink: "2item-permalink",
-				PubDate:   time.Now().Add(-5*time.Minute - time.Hour).UTC(),
+				PubDate:   time.Now().Add(-5*time.Minute - time.Hour).UTC().Truncate(time.Microsecond),
 				Title:     "2item-title",
 				Link:      "2item-link",
 			},
 			{
 				Key:       "2item2-key",
 				PermaLink: "2item2-permalink",
-				PubDate:   time.Now().Add(-10*time.Minute - time.Hour).UTC(),
+				PubDate:   time.Now().Add(-10*time.Minute - time.Hour).UTC().Truncate(time.Microsecond),
 				Title:     "2item2-title",
 				Link:      "2item2-link",
 			},
 		},
 	}
 
+	assert(db.Subscribe(context.Background(), user, feed2.URL)).Must.Nil()
 	assert(db.UpdateFeed(context.Background(), feed2)).Must.Nil()
 
-	result, err := db.ReadAll(context.Background())
+	result, err := db.ReadAll(context.Background(), user)
 	assert(err).Must.Nil()
 	assert(result).Equal([]Feed{feed, feed2})
 }
@@ -74,9 +141,7 @@ func TestReadAll(t *testing.T) {
 func TestUpdatedAt(t *testing.T) {
 	assert := assert.Wrap(t)
 
-	db, err := Open("file:TestUpdatedAt?cache=shared&mode=memory")
-	assert(err).Must.Nil()
-	defer db.Close()
+	db := testDB(t)
 
 	updatedAt := time.Now()
 	url := "a url"
@@ -92,9 +157,7 @@ func TestUpdatedAt(t *testing.T) {
 func TestSetUpdatedAt(t *testing.T) {
 	assert := assert.Wrap(t)
 
-	db, err := Open("file:TestUpdatedAt?cache=shared&mode=memory")
-	assert(err).Must.Nil()
-	defer db.Close()
+	db := testDB(t)
 
 	updatedAt := time.Now().Add(-5 * time.Minute)
 	url := "a url"
@@ -103,7 +166,7 @@ func TestSetUpdatedAt(t *testing.T) {
 		updatedAt, url)
 
 	newUpdatedAt := time.Now()
-	err = db.SetUpdatedAt(context.Background(), url, newUpdatedAt)
+	err := db.SetUpdatedAt(context.Background(), url, newUpdatedAt)
 	assert(err).Must.Nil()
 
 	result, err := db.UpdatedAt(context.Background(), url)
@@ -111,12 +174,94 @@ func TestSetUpdatedAt(t *testing.T) {
 	assert(result.Unix()).Equal(newUpdatedAt.Unix())
 }
 
-func TestUpdateFeed(t *testing.T) {
+func TestNextPoll(t *testing.T) {
 	assert := assert.Wrap(t)
+	ctx := context.Background()
 
-	db, err := Open("file:TestUpdateFeed?cache=shared&mode=memory")
+	db := testDB(t)
+	user := testUser(t, db)
+
+	url := "a url"
+	assert(db.Subscribe(ctx, user, url)).Must.Nil()
+
+	result, err := db.NextPoll(ctx, url)
 	assert(err).Must.Nil()
-	defer db.Close()
+	assert(result.IsZero()).True()
+
+	nextPoll := time.Now().Add(time.Hour)
+	assert(db.SetNextPoll(ctx, url, nextPoll)).Must.Nil()
+
+	result, err = db.NextPoll(ctx, url)
+	assert(err).Must.Nil()
+	assert(result.Unix()).Equal(nextPoll.Unix())
+}
+
+func TestFeedStatus(t *testing.T) {
+	assert := assert.Wrap(t)
+	ctx := context.Background()
+
+	db := testDB(t)
+	user := testUser(t, db)
+
+	url := "a url"
+	assert(db.Subscribe(ctx, user, url)).Must.Nil()
+
+	result, err := db.FeedStatus(ctx, url)
+	assert(err).Must.Nil()
+	assert(result).Equal(FeedStatus{})
+
+	status := FeedStatus{
+		ErrorCount:    3,
+		LastError:     "oops",
+		FailingSince:  time.Now().Add(-time.Hour).UTC().Truncate(time.Microsecond),
+		LastSuccessAt: time.Now().Add(-2 * time.Hour).UTC().Truncate(time.Microsecond),
+		Dead:          true,
+	}
+	assert(db.SetFeedStatus(ctx, url, status)).Must.Nil()
+
+	result, err = db.FeedStatus(ctx, url)
+	assert(err).Must.Nil()
+	assert(result).Equal(status)
+}
+
+func TestValidators(t *testing.T) {
+	assert := assert.Wrap(t)
+	ctx := context.Background()
+
+	db := testDB(t)
+	user := testUser(t, db)
+
+	url := "a url"
+	assert(db.Subscribe(ctx, user, url)).Must.Nil()
+
+	etag, lastModified, err := db.Validators(ctx, url)
+	assert(err).Must.Nil()
+	assert(etag).Equal("")
+	assert(lastModified).Equal("")
+
+	assert(db.SetValidators(ctx, url, `W/"abc"`, "Wed, 21 Oct 2015 07:28:00 GMT")).Must.Nil()
+
+	// validators must not be lost when the feed content is updated
+	assert(db.UpdateFeed(ctx, Feed{
+		URL:       url,
+		Title:     "feed-title",
+		UpdatedAt: time.Now(),
+		Items: []FeedItem{
+			{Key: "item-key", PubDate: time.Now()},
+		},
+	})).Must.Nil()
+
+	etag, lastModified, err = db.Validators(ctx, url)
+	assert(err).Must.Nil()
+	assert(etag).Equal(`W/"abc"`)
+	assert(lastModified).Equal("Wed, 21 Oct 2015 07:28:00 GMT")
+}
+
+func TestUpdateFeed(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	db := testDB(t)
+	user := testUser(t, db)
 
 	feed := Feed{
 		URL:        "feed-url",
@@ -141,9 +286,14 @@ func TestUpdateFeed(t *testing.T) {
 		},
 	}
 
-	err = db.UpdateFeed(context.Background(), feed)
+	assert(db.Subscribe(context.Background(), user, feed.URL)).Must.Nil()
+	err := db.UpdateFeed(context.Background(), feed)
 	assert(err).Must.Nil()
 
+	// a feed no one subscribes to is not stored
+	feed.URL = "unsubscribed-url"
+	assert(db.UpdateFeed(context.Background(), feed)).Must.Nil()
+
 	var feedsCount int
 	assert(db.db.QueryRow("SELECT COUNT(1) FROM feeds").Scan(&feedsCount)).Must.Nil()
 	assert(feedsCount).Equal(1)
@@ -153,16 +303,165 @@ func TestUpdateFeed(t *testing.T) {
 	assert(itemsCount).Equal(2)
 }
 
-func TestSubscribe(t *testing.T) {
+func TestUpdateFeedKeepsExistingItems(t *testing.T) {
 	assert := assert.Wrap(t)
+	ctx := context.Background()
+
+	db := testDB(t)
+	user := testUser(t, db)
+
+	db.SetRetention(Retention{Items: 3})
 
-	db, err := Open("file:TestSubscribe?cache=shared&mode=memory")
+	feed := Feed{URL: "feed-url", Title: "feed-title", UpdatedAt: time.Now()}
+	assert(db.Subscribe(ctx, user, feed.URL)).Must.Nil()
+	for i := 0; i < 5; i++ {
+		feed.Items = []FeedItem{{
+			Key:     fmt.Sprintf("item-%d", i),
+			PubDate: time.Now().Add(time.Duration(i) * time.Minute),
+		}}
+		assert(db.UpdateFeed(ctx, feed)).Must.Nil()
+	}
+
+	var keys []string
+	rows, err := db.db.Query("SELECT Key FROM feedItems ORDER BY PubDate DESC")
 	assert(err).Must.Nil()
-	defer db.Close()
+	for rows.Next() {
+		var key string
+		assert(rows.Scan(&key)).Must.Nil()
+		keys = append(keys, key)
+	}
+	assert(keys).Equal([]string{"item-4", "item-3", "item-2"})
+}
+
+func TestUpdateFeedWithFeedRetention(t *testing.T) {
+	assert := assert.Wrap(t)
+	ctx := context.Background()
+
+	db := testDB(t)
+	user := testUser(t, db)
+
+	assert(db.Subscribe(ctx, user, "feed-url")).Must.Nil()
+	assert(db.SetFeedRetention(ctx, "feed-url", &Retention{Days: 90})).Must.Nil()
+
+	feed := Feed{
+		URL:       "feed-url",
+		Title:     "feed-title",
+		UpdatedAt: time.Now(),
+		Items: []FeedItem{
+			{Key: "new", PubDate: time.Now().AddDate(0, 0, -1)},
+			{Key: "recent", PubDate: time.Now().AddDate(0, 0, -80)},
+			{Key: "old", PubDate: time.Now().AddDate(0, 0, -100)},
+		},
+	}
+	for i := 0; i < 10; i++ {
+		feed.Items = append(feed.Items, FeedItem{
+			Key:     fmt.Sprintf("item-%d", i),
+			PubDate: time.Now().AddDate(0, 0, -2),
+		})
+	}
+	assert(db.UpdateFeed(ctx, feed)).Must.Nil()
+
+	var itemsCount int
+	assert(db.db.QueryRow("SELECT COUNT(1) FROM feedItems").Scan(&itemsCount)).Must.Nil()
+	assert(itemsCount).Equal(12)
+
+	var oldCount int
+	assert(db.db.QueryRow("SELECT COUNT(1) FROM feedItems WHERE Key = 'old'").Scan(&oldCount)).Must.Nil()
+	assert(oldCount).Equal(0)
+
+	// the newest item is kept regardless of age
+	feed.Items = []FeedItem{{Key: "ancient", PubDate: time.Now().AddDate(-1, 0, 0)}}
+	assert(db.UpdateFeed(ctx, feed)).Must.Nil()
+
+	assert(db.SetFeedRetention(ctx, "feed-url", nil)).Must.Nil()
+	assert(db.UpdateFeed(ctx, feed)).Must.Nil()
+
+	assert(db.db.QueryRow("SELECT COUNT(1) FROM feedItems").Scan(&itemsCount)).Must.Nil()
+	assert(itemsCount).Equal(DefaultRetention.Items)
+}
+
+func TestPrune(t *testing.T) {
+	assert := assert.Wrap(t)
+	ctx := context.Background()
+
+	db := testDB(t)
+	user := testUser(t, db)
+
+	feed := Feed{URL: "feed-url", Title: "feed-title", UpdatedAt: time.Now()}
+	assert(db.Subscribe(ctx, user, feed.URL)).Must.Nil()
+	for i := 0; i < 5; i++ {
+		feed.Items = append(feed.Items, FeedItem{
+			Key:     fmt.Sprintf("item-%d", i),
+			PubDate: time.Now().AddDate(0, 0, -i),
+		})
+	}
+	assert(db.UpdateFeed(ctx, feed)).Must.Nil()
+
+	db.SetRetention(Retention{Items: 2})
+
+	removed, err := db.Prune(ctx)
+	assert(err).Must.Nil()
+	assert(removed).Equal(int64(3))
+
+	removed, err = db.Prune(ctx)
+	assert(err).Must.Nil()
+	assert(removed).Equal(int64(0))
+}
+
+func TestStats(t *testing.T) {
+	assert := assert.Wrap(t)
+	ctx := context.Background()
+
+	db := testDB(t)
+	user := testUser(t, db)
+
+	stats, err := db.Stats(ctx)
+	assert(err).Must.Nil()
+	assert(stats).Equal(Stats{})
+
+	newest := time.Now().Add(-time.Hour)
+	assert(db.Subscribe(ctx, user, "feed-url")).Must.Nil()
+	assert(db.UpdateFeed(ctx, Feed{
+		URL:       "feed-url",
+		UpdatedAt: time.Now(),
+		Items: []FeedItem{
+			{Key: "a", PubDate: newest},
+			{Key: "b", PubDate: newest.Add(-time.Hour)},
+		},
+	})).Must.Nil()
+	assert(db.MarkItemRead(ctx, user, "feed-url", "a")).Must.Nil()
+
+	assert(db.Subscribe(ctx, user, "failing-url")).Must.Nil()
+	assert(db.SetFeedStatus(ctx, "failing-url", FeedStatus{ErrorCount: 3, Dead: true})).Must.Nil()
+
+	stats, err = db.Stats(ctx)
+	assert(err).Must.Nil()
+	assert(stats.Feeds).Equal(2)
+	assert(stats.Failing).Equal(1)
+	assert(stats.Dead).Equal(1)
+	assert(stats.Gone).Equal(0)
+	assert(stats.Items).Equal(2)
+	assert(stats.Unread).Equal(1)
+	assert(stats.NewestItem.Unix()).Equal(newest.Unix())
+
+	health, err := db.FeedHealth(ctx)
+	assert(err).Must.Nil()
+	assert(health).Equal(map[string]int{"ok": 1, "dead": 1})
+
+	items, err := db.ItemCount(ctx)
+	assert(err).Must.Nil()
+	assert(items).Equal(2)
+}
+
+func TestSubscribe(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	db := testDB(t)
+	user := testUser(t, db)
 
 	url := "a-uri"
 
-	err = db.Subscribe(context.Background(), url)
+	err := db.Subscribe(context.Background(), user, url)
 	assert(err).Must.Nil()
 
 	var feedsCount int
@@ -177,34 +476,406 @@ func TestSubscribe(t *testing.T) {
 func TestUnsubscribe(t *testing.T) {
 	assert := assert.Wrap(t)
 
-	db, err := Open("file:TestUnsubscribe?cache=shared&mode=memory")
-	assert(err).Must.Nil()
-	defer db.Close()
+	db := testDB(t)
+	user := testUser(t, db)
 
 	url := "a-uri"
 
-	err = db.Subscribe(context.Background(), url)
+	err := db.Subscribe(context.Background(), user, url)
 	assert(err).Must.Nil()
 
-	err = db.Unsubscribe(context.Background(), url)
+	removed, err := db.Unsubscribe(context.Background(), user, url)
 	assert(err).Must.Nil()
+	assert(removed).True()
 
 	var feedsCount int
 	assert(db.db.QueryRow("SELECT COUNT(1) FROM feeds").Scan(&feedsCount)).Must.Nil()
 	assert(feedsCount).Equal(0)
 }
 
-func TestSubscriptions(t *testing.T) {
+func TestRenameFeed(t *testing.T) {
+	assert := assert.Wrap(t)
+	ctx := context.Background()
+
+	db := testDB(t)
+	user := testUser(t, db)
+
+	assert(db.Subscribe(ctx, user, "old")).Must.Nil()
+	assert(db.UpdateFeed(ctx, Feed{
+		URL:       "old",
+		Title:     "feed-title",
+		UpdatedAt: time.Now(),
+		Items:     []FeedItem{{Key: "item-key", PubDate: time.Now()}},
+	})).Must.Nil()
+	assert(db.SetValidators(ctx, "old", "etag", "")).Must.Nil()
+
+	assert(db.RenameFeed(ctx, "old", "new")).Must.Nil()
+
+	subs, err := db.Subscriptions(ctx)
+	assert(err).Must.Nil()
+	assert(subs).Equal([]string{"new"})
+
+	etag, _, err := db.Validators(ctx, "new")
+	assert(err).Must.Nil()
+	assert(etag).Equal("etag")
+
+	var feedURL string
+	assert(db.db.QueryRow("SELECT FeedURL FROM feedItems").Scan(&feedURL)).Must.Nil()
+	assert(feedURL).Equal("new")
+}
+
+func TestRenameFeedToExistingSubscription(t *testing.T) {
 	assert := assert.Wrap(t)
 	ctx := context.Background()
 
-	db, err := Open("file:TestSubscriptions?cache=shared&mode=memory")
+	db := testDB(t)
+	user := testUser(t, db)
+
+	assert(db.Subscribe(ctx, user, "old")).Must.Nil()
+	assert(db.UpdateFeed(ctx, Feed{
+		URL:       "old",
+		Title:     "feed-title",
+		UpdatedAt: time.Now(),
+		Items:     []FeedItem{{Key: "item-key", PubDate: time.Now()}},
+	})).Must.Nil()
+	assert(db.Subscribe(ctx, user, "new")).Must.Nil()
+
+	assert(db.RenameFeed(ctx, "old", "new")).Must.Nil()
+
+	subs, err := db.Subscriptions(ctx)
+	assert(err).Must.Nil()
+	assert(subs).Equal([]string{"new"})
+
+	var itemsCount int
+	assert(db.db.QueryRow("SELECT COUNT(1) FROM feedItems").Scan(&itemsCount)).Must.Nil()
+	assert(itemsCount).Equal(0)
+}
+
+func TestMarkRead(t *testing.T) {
+	assert := assert.Wrap(t)
+	ctx := context.Background()
+
+	db := testDB(t)
+	user := testUser(t, db)
+
+	now := time.Now().UTC()
+	feed := func(uri string) Feed {
+		return Feed{
+			URL:       uri,
+			Title:     "feed-title",
+			UpdatedAt: now,
+			Items: []FeedItem{
+				{Key: "a", PubDate: now},
+				{Key: "b", PubDate: now.Add(-time.Hour)},
+			},
+		}
+	}
+
+	read := func() map[string]bool {
+		feeds, err := db.ReadAll(ctx, user)
+		assert(err).Must.Nil()
+
+		m := map[string]bool{}
+		for _, feed := range feeds {
+			for _, item := range feed.Items {
+				m[feed.URL+" "+item.Key] = item.Read
+			}
+		}
+		return m
+	}
+
+	assert(db.Subscribe(ctx, user, "one")).Must.Nil()
+	assert(db.Subscribe(ctx, user, "two")).Must.Nil()
+	assert(db.UpdateFeed(ctx, feed("one"))).Must.Nil()
+	assert(db.UpdateFeed(ctx, feed("two"))).Must.Nil()
+
+	assert(db.MarkItemRead(ctx, user, "one", "a")).Must.Nil()
+	assert(read()).Equal(map[string]bool{"one a": true, "one b": false, "two a": false, "two b": false})
+
+	// read state is kept when the feed is fetched again
+	assert(db.UpdateFeed(ctx, feed("one"))).Must.Nil()
+	assert(read()).Equal(map[string]bool{"one a": true, "one b": false, "two a": false, "two b": false})
+
+	assert(db.MarkFeedRead(ctx, user, "two")).Must.Nil()
+	assert(read()).Equal(map[string]bool{"one a": true, "one b": false, "two a": true, "two b": true})
+
+	assert(db.MarkAllRead(ctx, user)).Must.Nil()
+	assert(read()).Equal(map[string]bool{"one a": true, "one b": true, "two a": true, "two b": true})
+
+	assert(db.RenameFeed(ctx, "two", "three")).Must.Nil()
+	assert(read()).Equal(map[string]bool{"one a": true, "one b": true, "three a": true, "three b": true})
+
+	_, err := db.Unsubscribe(ctx, user, "three")
+	assert(err).Must.Nil()
+	var readsCount int
+	assert(db.db.QueryRow("SELECT COUNT(1) FROM itemReads").Scan(&readsCount)).Must.Nil()
+	assert(readsCount).Equal(2)
+}
+
+func TestAddSubscription(t *testing.T) {
+	assert := assert.Wrap(t)
+	ctx := context.Background()
+
+	db := testDB(t)
+	user := testUser(t, db)
+
+	assert(db.AddSubscription(ctx, user, Subscription{URL: "a", Title: "A", Category: []string{"news", "tech"}})).Must.Nil()
+	assert(db.AddSubscription(ctx, user, Subscription{URL: "b", Title: "B", Category: []string{"news"}})).Must.Nil()
+	assert(db.AddSubscription(ctx, user, Subscription{URL: "c", Title: "C"})).Must.Nil()
+
+	var categoriesCount int
+	assert(db.db.QueryRow("SELECT COUNT(1) FROM categories").Scan(&categoriesCount)).Must.Nil()
+	assert(categoriesCount).Equal(2)
+
+	// fetching the feed replaces the title, re-adding moves the folder but keeps
+	// the fetched title
+	assert(db.UpdateFeed(ctx, Feed{
+		URL:       "b",
+		Title:     "Fetched B",
+		UpdatedAt: time.Now().UTC(),
+		Items:     []FeedItem{{Key: "1", PubDate: time.Now().UTC()}},
+	})).Must.Nil()
+	assert(db.AddSubscription(ctx, user, Subscription{URL: "b", Title: "B", Category: []string{"news", "tech"}})).Must.Nil()
+	assert(db.SetCategory(ctx, user, "c", []string{"misc"})).Must.Nil()
+
+	subs, err := db.SubscriptionDetails(ctx, user)
+	assert(err).Must.Nil()
+	assert(subs).Equal([]Subscription{
+		{URL: "a", Title: "A", Category: []string{"news", "tech"}},
+		{URL: "b", Title: "Fetched B", Category: []string{"news", "tech"}},
+		{URL: "c", Title: "C", Category: []string{"misc"}},
+	})
+
+	// the fetched feed comes first, before those without items
+	feeds, err := db.ReadAll(ctx, user)
+	assert(err).Must.Nil()
+	assert(len(feeds)).Must.Equal(3)
+	assert(feeds[0].URL).Equal("b")
+	assert(feeds[0].Category).Equal([]string{"news", "tech"})
+
+	assert(db.SetCategory(ctx, user, "b", nil)).Must.Nil()
+
+	feeds, err = db.ReadAll(ctx, user)
+	assert(err).Must.Nil()
+	assert(feeds[0].Category).Equal([]string(nil))
+}
+
+func TestSearch(t *testing.T) {
+	assert := assert.Wrap(t)
+	ctx := context.Background()
+
+	db := testDB(t)
+	// added first, as it would otherwise claim user
+	other, err := db.AddUser(ctx, "other", "https://other.example/")
+	assert(err).Must.Nil()
+	user := testUser(t, db)
+
+	if _, err := db.Search(ctx, SearchQuery{Text: "anything"}); errors.Is(err, ErrSearchUnavailable) {
+		t.Skip(err)
+	}
+
+	assert(db.Subscribe(ctx, user, "one")).Must.Nil()
+	assert(db.Subscribe(ctx, other.ID, "two")).Must.Nil()
+
+	now := time.Now().UTC()
+	assert(db.UpdateFeed(ctx, Feed{
+		URL:       "one",
+		Title:     "One",
+		UpdatedAt: now,
+		Items: []FeedItem{
+			{Key: "a", Title: "Gardening in winter", PubDate: now},
+			{Key: "b", Title: "Winter is coming, winter again", PubDate: now.AddDate(0, 0, -10)},
+		},
+	})).Must.Nil()
+	assert(db.UpdateFeed(ctx, Feed{
+		URL:       "two",
+		Title:     "Two",
+		UpdatedAt: now,
+		Items: []FeedItem{
+			{Key: "c", Title: "Summer gardening", PubDate: now},
+		},
+	})).Must.Nil()
+
+	keys := func(query SearchQuery) []string {
+		results, err := db.Search(ctx, query)
+		assert(err).Must.Nil()
+
+		var keys []string
+		for _, result := range results {
+			keys = append(keys, result.Item.Key)
+		}
+		return keys
+	}
+
+	assert(keys(SearchQuery{Text: "winter", AllUsers: true})).Equal([]string{"b", "a"})
+	assert(keys(SearchQuery{Text: "gardening", AllUsers: true})).Equal([]string{"c", "a"})
+	assert(keys(SearchQuery{Text: "gardening", AllUsers: true, FeedURL: "two"})).Equal([]string{"c"})
+	assert(keys(SearchQuery{Text: "winter", AllUsers: true, Since: now.AddDate(0, 0, -1)})).Equal([]string{"a"})
+	assert(keys(SearchQuery{Text: "winter", AllUsers: true, Until: now.AddDate(0, 0, -1)})).Equal([]string{"b"})
+	assert(keys(SearchQuery{Text: `"unbalanced`, AllUsers: true})).Equal([]string(nil))
+
+	assert(keys(SearchQuery{Text: "gardening", User: user})).Equal([]string{"a"})
+	assert(keys(SearchQuery{Text: "gardening", User: other.ID})).Equal([]string{"c"})
+	assert(keys(SearchQuery{Text: "gardening"})).Equal([]string(nil))
+
+	// the index follows changes to items
+	assert(db.UpdateFeed(ctx, Feed{
+		URL:       "two",
+		Title:     "Two",
+		UpdatedAt: now,
+		Items: []FeedItem{
+			{Key: "c", Title: "Summer in winter", PubDate: now},
+		},
+	})).Must.Nil()
+	assert(keys(SearchQuery{Text: "gardening", AllUsers: true})).Equal([]string{"a"})
+
+	_, err = db.Unsubscribe(ctx, user, "one")
+	assert(err).Must.Nil()
+	assert(keys(SearchQuery{Text: "winter", AllUsers: true})).Equal([]string{"c"})
+}
+
+func TestSearchIndexBuiltOnce(t *testing.T) {
+	assert := assert.Wrap(t)
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "db")
+
+	db, err := Open(path)
+	assert(err).Must.Nil()
+	if _, err := db.Search(ctx, SearchQuery{Text: "anything"}); errors.Is(err, ErrSearchUnavailable) {
+		db.Close()
+		t.Skip(err)
+	}
+
+	// an entry only in the index is lost if the index is rebuilt
+	_, err = db.db.Exec("INSERT INTO itemSearch (rowid, Title, Summary) VALUES (1000, 'marker', '')")
+	assert(err).Must.Nil()
+	assert(db.Close()).Must.Nil()
+
+	indexed := func(db *DB) (count int) {
+		assert(db.db.QueryRow("SELECT COUNT(*) FROM itemSearch WHERE itemSearch MATCH 'marker'").Scan(&count)).Must.Nil()
+		return count
+	}
+
+	db, err = Open(path)
+	assert(err).Must.Nil()
+	assert(indexed(db)).Equal(1)
+
+	// a missing trigger means items may have changed unseen
+	_, err = db.db.Exec("DROP TRIGGER itemSearchInsert")
+	assert(err).Must.Nil()
+	assert(db.Close()).Must.Nil()
+
+	db, err = Open(path)
 	assert(err).Must.Nil()
 	defer db.Close()
+	assert(indexed(db)).Equal(0)
+}
+
+func TestSearchAfterVacuum(t *testing.T) {
+	assert := assert.Wrap(t)
+	ctx := context.Background()
+
+	db := testDB(t)
+	user := testUser(t, db)
+
+	if _, err := db.Search(ctx, SearchQuery{Text: "anything"}); errors.Is(err, ErrSearchUnavailable) {
+		t.Skip(err)
+	}
+
+	now := time.Now().UTC()
+	for _, feed := range []Feed{
+		{URL: "one", Items: []FeedItem{{Key: "a", Title: "Autumn leaves", PubDate: now}}},
+		{URL: "two", Items: []FeedItem{{Key: "b", Title: "Spring bulbs", PubDate: now}}},
+	} {
+		assert(db.Subscribe(ctx, user, feed.URL)).Must.Nil()
+		assert(db.UpdateFeed(ctx, feed)).Must.Nil()
+	}
+
+	// removing the first items leaves a gap that VACUUM could close
+	_, err := db.Unsubscribe(ctx, user, "one")
+	assert(err).Must.Nil()
+	_, err = db.db.Exec("VACUUM")
+	assert(err).Must.Nil()
+
+	results, err := db.Search(ctx, SearchQuery{Text: "spring", User: user})
+	assert(err).Must.Nil()
+	assert(results).Must.Len(1)
+	assert(results[0].Item.Key).Equal("b")
+}
+
+func TestHubSubscription(t *testing.T) {
+	assert := assert.Wrap(t)
+	ctx := context.Background()
+
+	db := testDB(t)
+	user := testUser(t, db)
 
-	assert(db.Subscribe(ctx, "a")).Must.Nil()
-	assert(db.Subscribe(ctx, "b")).Must.Nil()
-	assert(db.Subscribe(ctx, "c")).Must.Nil()
+	assert(db.Subscribe(ctx, user, "old")).Must.Nil()
+
+	sub, err := db.HubSubscription(ctx, "old")
+	assert(err).Must.Nil()
+	assert(sub).Equal(HubSubscription{})
+
+	expiresAt := time.Now().Add(time.Hour)
+	assert(db.SetHubSubscription(ctx, HubSubscription{
+		FeedURL:   "old",
+		ID:        "id",
+		Hub:       "hub",
+		Topic:     "topic",
+		Secret:    "secret",
+		ExpiresAt: expiresAt,
+		RenewAt:   expiresAt.Add(-time.Minute),
+	})).Must.Nil()
+
+	assert(db.RenameFeed(ctx, "old", "new")).Must.Nil()
+
+	sub, err = db.HubSubscriptionByID(ctx, "id")
+	assert(err).Must.Nil()
+	assert(sub.FeedURL).Equal("new")
+	assert(sub.Hub).Equal("hub")
+	assert(sub.Topic).Equal("topic")
+	assert(sub.Secret).Equal("secret")
+	assert(sub.ExpiresAt.Unix()).Equal(expiresAt.Unix())
+	assert(sub.Active(time.Now())).True()
+	assert(sub.Active(expiresAt.Add(time.Second))).False()
+
+	_, err = db.Unsubscribe(ctx, user, "new")
+	assert(err).Must.Nil()
+
+	sub, err = db.HubSubscription(ctx, "new")
+	assert(err).Must.Nil()
+	assert(sub.ID).Equal("")
+}
+
+func TestSetting(t *testing.T) {
+	assert := assert.Wrap(t)
+	ctx := context.Background()
+
+	db := testDB(t)
+
+	_, ok, err := db.Setting(ctx, "key")
+	assert(err).Must.Nil()
+	assert(ok).False()
+
+	assert(db.SetSetting(ctx, "key", "a")).Must.Nil()
+	assert(db.SetSetting(ctx, "key", "b")).Must.Nil()
+
+	value, ok, err := db.Setting(ctx, "key")
+	assert(err).Must.Nil()
+	assert(ok).True()
+	assert(value).Equal("b")
+}
+
+func TestSubscriptions(t *testing.T) {
+	assert := assert.Wrap(t)
+	ctx := context.Background()
+
+	db := testDB(t)
+	user := testUser(t, db)
+
+	assert(db.Subscribe(ctx, user, "a")).Must.Nil()
+	assert(db.Subscribe(ctx, user, "b")).Must.Nil()
+	assert(db.Subscribe(ctx, user, "c")).Must.Nil()
 
 	result, err := db.Subscriptions(ctx)
 	assert(err).Nil()
@@ -212,3 +883,275 @@ func TestSubscriptions(t *testing.T) {
 	sort.Strings(result)
 	assert(result).Equal([]string{"a", "b", "c"})
 }
+
+func TestUsers(t *testing.T) {
+	assert := assert.Wrap(t)
+	ctx := context.Background()
+
+	db := testDB(t)
+
+	// subscriptions made before anyone signs in go to whoever signs in first
+	unclaimed, err := db.UnclaimedUser(ctx)
+	assert(err).Must.Nil()
+	assert(db.Subscribe(ctx, unclaimed.ID, "shared")).Must.Nil()
+
+	alice, err := db.AddUser(ctx, "alice", "https://alice.example/")
+	assert(err).Must.Nil()
+	assert(alice.ID).Equal(unclaimed.ID)
+
+	bob, err := db.AddUser(ctx, "bob", "https://bob.example/")
+	assert(err).Must.Nil()
+	assert(bob.ID != alice.ID).True()
+
+	_, err = db.AddUser(ctx, "alice", "https://other.example/")
+	assert(err).NotNil()
+
+	found, ok, err := db.UserByMe(ctx, "https://bob.example/")
+	assert(err).Must.Nil()
+	assert(ok).True()
+	assert(found).Equal(bob)
+
+	_, ok, err = db.UserByName(ctx, "carol")
+	assert(err).Must.Nil()
+	assert(ok).False()
+
+	list, err := db.Users(ctx)
+	assert(err).Must.Nil()
+	assert(list).Equal([]User{alice, bob})
+
+	assert(db.Subscribe(ctx, bob.ID, "shared")).Must.Nil()
+	assert(db.Subscribe(ctx, bob.ID, "own")).Must.Nil()
+	assert(db.UpdateFeed(ctx, Feed{
+		URL:       "shared",
+		Title:     "Shared",
+		UpdatedAt: time.Now(),
+		Items:     []FeedItem{{Key: "a", PubDate: time.Now()}},
+	})).Must.Nil()
+	assert(db.UpdateFeed(ctx, Feed{
+		URL:       "own",
+		Title:     "Own",
+		UpdatedAt: time.Now(),
+		Items:     []FeedItem{{Key: "a", PubDate: time.Now()}},
+	})).Must.Nil()
+
+	// each feed is kept once, but read separately
+	subs, err := db.Subscriptions(ctx)
+	assert(err).Must.Nil()
+	sort.Strings(subs)
+	assert(subs).Equal([]string{"own", "shared"})
+
+	assert(db.MarkAllRead(ctx, alice.ID)).Must.Nil()
+
+	feeds, err := db.ReadAll(ctx, alice.ID)
+	assert(err).Must.Nil()
+	assert(feeds).Len(1)
+	assert(feeds[0].Items[0].Read).True()
+
+	feeds, err = db.ReadAll(ctx, bob.ID)
+	assert(err).Must.Nil()
+	assert(feeds).Len(2)
+	for _, feed := range feeds {
+		assert(feed.Items[0].Read).False()
+	}
+
+	// the feed is only removed once no one subscribes to it
+	removed, err := db.Unsubscribe(ctx, alice.ID, "shared")
+	assert(err).Must.Nil()
+	assert(removed).False()
+
+	feeds, err = db.ReadAll(ctx, bob.ID)
+	assert(err).Must.Nil()
+	assert(feeds).Len(2)
+
+	removed, err = db.Unsubscribe(ctx, bob.ID, "shared")
+	assert(err).Must.Nil()
+	assert(removed).True()
+
+	subs, err = db.Subscriptions(ctx)
+	assert(err).Must.Nil()
+	assert(subs).Equal([]string{"own"})
+}
+
+func TestMigrate(t *testing.T) {
+	assert := assert.Wrap(t)
+	ctx := context.Background()
+
+	db, err := Open(filepath.Join(t.TempDir(), "db"))
+	assert(err).Must.Nil()
+	defer db.Close()
+
+	version, err := db.SchemaVersion(ctx)
+	assert(err).Must.Nil()
+	assert(version).Equal(sqliteMigrations[len(sqliteMigrations)-1].Version)
+
+	pending, err := db.PendingMigrations(ctx)
+	assert(err).Must.Nil()
+	assert(pending).Len(0)
+}
+
+func TestMigrateUnversionedDatabase(t *testing.T) {
+	assert := assert.Wrap(t)
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "db")
+
+	old, err := OpenWithoutMigrating(path)
+	assert(err).Must.Nil()
+	_, err = old.db.Exec(`
+		CREATE TABLE feeds (
+			URL         TEXT NOT NULL PRIMARY KEY,
+			WebsiteURL  TEXT,
+			Title       TEXT,
+			UpdatedAt   DATETIME
+		);
+		INSERT INTO feeds (URL) VALUES ('a-uri');
+`)
+	assert(err).Must.Nil()
+
+	pending, err := old.PendingMigrations(ctx)
+	assert(err).Must.Nil()
+	assert(pending).Len(len(sqliteMigrations))
+
+	// listing what is pending must not change the database
+	var tables int
+	assert(old.db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table'").Scan(&tables)).Must.Nil()
+	assert(tables).Equal(1)
+	assert(old.Close()).Must.Nil()
+
+	db, err := Open(path)
+	assert(err).Must.Nil()
+	defer db.Close()
+
+	subs, err := db.Subscriptions(ctx)
+	assert(err).Must.Nil()
+	assert(subs).Equal([]string{"a-uri"})
+
+	assert(db.SetValidators(ctx, "a-uri", "etag", "")).Must.Nil()
+}
+
+func TestMigrateRefusesNewerSchema(t *testing.T) {
+	assert := assert.Wrap(t)
+	path := filepath.Join(t.TempDir(), "db")
+
+	db, err := Open(path)
+	assert(err).Must.Nil()
+	_, err = db.db.Exec("INSERT INTO schema_version (Version, Name) VALUES (?, 'from the future')",
+		sqliteMigrations[len(sqliteMigrations)-1].Version+1)
+	assert(err).Must.Nil()
+	assert(db.Close()).Must.Nil()
+
+	_, err = Open(path)
+	assert(errors.Is(err, ErrSchemaTooNew)).True()
+}
+
+func TestMigrationsMatch(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	assert(postgresMigrations[len(postgresMigrations)-1].Version).
+		Equal(sqliteMigrations[len(sqliteMigrations)-1].Version)
+}
+
+func TestRebind(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	query := "SELECT ? FROM feeds WHERE Title = '?' AND URL = ?"
+	assert(sqlite.rebind(query)).Equal(query)
+	assert(postgres.rebind(query)).Equal("SELECT $1 FROM feeds WHERE Title = '?' AND URL = $2")
+}
+
+func TestFeedSettings(t *testing.T) {
+	assert := assert.Wrap(t)
+	ctx := context.Background()
+
+	db := testDB(t)
+	user := testUser(t, db)
+
+	url := "a url"
+	assert(db.Subscribe(ctx, user, url)).Must.Nil()
+
+	settings, err := db.FeedSettings(ctx, user, url)
+	assert(err).Must.Nil()
+	assert(settings).Equal(FeedSettings{})
+
+	settings = FeedSettings{Title: "my title", Refresh: 90 * time.Minute, Paused: true}
+	ok, err := db.SetFeedSettings(ctx, user, url, settings)
+	assert(err).Must.Nil()
+	assert(ok).True()
+
+	result, err := db.FeedSettings(ctx, user, url)
+	assert(err).Must.Nil()
+	assert(result).Equal(settings)
+
+	assert(db.UpdateFeed(ctx, Feed{
+		URL:       url,
+		Title:     "feed title",
+		UpdatedAt: time.Now(),
+		Items:     []FeedItem{{Key: "a", PubDate: time.Now()}},
+	})).Must.Nil()
+
+	feeds, err := db.ReadAll(ctx, user)
+	assert(err).Must.Nil()
+	assert(len(feeds)).Must.Equal(1)
+	assert(feeds[0].Title).Equal("feed title")
+	assert(feeds[0].Settings).Equal(settings)
+
+	// settings belong to the subscriber, not the feed. user is claimed first so
+	// that other is someone else
+	_, err = db.AddUser(ctx, "user", "https://user.example/")
+	assert(err).Must.Nil()
+	other, err := db.AddUser(ctx, "other", "https://other.example/")
+	assert(err).Must.Nil()
+
+	ok, err = db.SetFeedSettings(ctx, other.ID, url, FeedSettings{Title: "theirs"})
+	assert(err).Must.Nil()
+	assert(ok).False()
+
+	assert(db.Subscribe(ctx, other.ID, url)).Must.Nil()
+	feeds, err = db.ReadAll(ctx, other.ID)
+	assert(err).Must.Nil()
+	assert(len(feeds)).Must.Equal(1)
+	assert(feeds[0].Settings).Equal(FeedSettings{})
+
+	ok, err = db.SetFeedSettings(ctx, user, url, FeedSettings{})
+	assert(err).Must.Nil()
+	assert(ok).True()
+
+	result, err = db.FeedSettings(ctx, user, url)
+	assert(err).Must.Nil()
+	assert(result).Equal(FeedSettings{})
+}
+
+func TestPollSettings(t *testing.T) {
+	assert := assert.Wrap(t)
+	ctx := context.Background()
+
+	db := testDB(t)
+
+	url := "a url"
+	set := func(name string, settings FeedSettings) {
+		user, err := db.AddUser(ctx, name, "https://"+name+".example/")
+		assert(err).Must.Nil()
+		assert(db.Subscribe(ctx, user.ID, url)).Must.Nil()
+
+		ok, err := db.SetFeedSettings(ctx, user.ID, url, settings)
+		assert(err).Must.Nil()
+		assert(ok).True()
+	}
+	poll := func() FeedSettings {
+		settings, err := db.PollSettings(ctx, url)
+		assert(err).Must.Nil()
+		return settings
+	}
+
+	assert(poll()).Equal(FeedSettings{})
+
+	set("a", FeedSettings{Title: "a", Refresh: 2 * time.Hour, Paused: true})
+	assert(poll()).Equal(FeedSettings{Refresh: 2 * time.Hour, Paused: true})
+
+	// still polled while anyone has not paused it
+	set("b", FeedSettings{})
+	assert(poll()).Equal(FeedSettings{})
+
+	set("c", FeedSettings{Refresh: 3 * time.Hour})
+	set("d", FeedSettings{Refresh: 90 * time.Minute})
+	assert(poll()).Equal(FeedSettings{Refresh: 90 * time.Minute})
+}
@@ -0,0 +1,139 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"strings"
+
+	// register postgres for database/sql
+	_ "github.com/lib/pq"
+)
+
+// A dialect is what differs between the databases that arboretum can be
+// stored in. Queries are written once, with ? placeholders, in the SQL that
+// both understand.
+type dialect struct {
+	// driver is the name of the database/sql driver
+	driver string
+	// timestamp is the column type used for times
+	timestamp string
+	// tableExists is a query for whether the table named by its parameter
+	// exists
+	tableExists string
+	migrations  []Migration
+	// numbered is set when placeholders are written $1, $2, ... instead of ?
+	numbered bool
+}
+
+var (
+	sqlite = &dialect{
+		driver:      "sqlite3",
+		timestamp:   "DATETIME",
+		tableExists: "SELECT EXISTS (SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = ?)",
+		migrations:  sqliteMigrations,
+	}
+
+	postgres = &dialect{
+		driver:    "postgres",
+		timestamp: "TIMESTAMP WITH TIME ZONE",
+		tableExists: `SELECT EXISTS (SELECT 1 FROM information_schema.tables
+			WHERE table_schema = current_schema() AND table_name = ?)`,
+		migrations: postgresMigrations,
+		numbered:   true,
+	}
+)
+
+// dialectFor picks the dialect for a --db value. PostgreSQL is used for
+// postgres:// URLs, anything else is the path to a SQLite database.
+func dialectFor(dsn string) *dialect {
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		return postgres
+	}
+
+	return sqlite
+}
+
+// rebind rewrites the ? placeholders in query for the dialect.
+func (d *dialect) rebind(query string) string {
+	if !d.numbered || !strings.Contains(query, "?") {
+		return query
+	}
+
+	var (
+		b      strings.Builder
+		n      int
+		quoted bool
+	)
+	for _, r := range query {
+		switch {
+		case r == '\'':
+			quoted = !quoted
+		case r == '?' && !quoted:
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+
+	return b.String()
+}
+
+// sqlDB is a database that rewrites queries for its dialect.
+type sqlDB struct {
+	*sql.DB
+	dialect *dialect
+}
+
+func (db sqlDB) Exec(query string, args ...any) (sql.Result, error) {
+	return db.DB.Exec(db.dialect.rebind(query), args...)
+}
+
+func (db sqlDB) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	return db.DB.ExecContext(ctx, db.dialect.rebind(query), args...)
+}
+
+func (db sqlDB) Query(query string, args ...any) (*sql.Rows, error) {
+	return db.DB.Query(db.dialect.rebind(query), args...)
+}
+
+func (db sqlDB) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	return db.DB.QueryContext(ctx, db.dialect.rebind(query), args...)
+}
+
+func (db sqlDB) QueryRow(query string, args ...any) *sql.Row {
+	return db.DB.QueryRow(db.dialect.rebind(query), args...)
+}
+
+func (db sqlDB) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	return db.DB.QueryRowContext(ctx, db.dialect.rebind(query), args...)
+}
+
+func (db sqlDB) BeginTx(ctx context.Context, opts *sql.TxOptions) (sqlTx, error) {
+	tx, err := db.DB.BeginTx(ctx, opts)
+
+	return sqlTx{Tx: tx, dialect: db.dialect}, err
+}
+
+// sqlTx is a transaction that rewrites queries for its dialect.
+type sqlTx struct {
+	*sql.Tx
+	dialect *dialect
+}
+
+func (tx sqlTx) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	return tx.Tx.ExecContext(ctx, tx.dialect.rebind(query), args...)
+}
+
+func (tx sqlTx) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	return tx.Tx.QueryContext(ctx, tx.dialect.rebind(query), args...)
+}
+
+func (tx sqlTx) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	return tx.Tx.QueryRowContext(ctx, tx.dialect.rebind(query), args...)
+}
+
+func (tx sqlTx) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	return tx.Tx.PrepareContext(ctx, tx.dialect.rebind(query))
+}
@@ -0,0 +1,104 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// A HubSubscription is a WebSub subscription to have a hub push updates to a
+// feed, rather than waiting for it to be polled.
+type HubSubscription struct {
+	FeedURL string
+	// ID identifies the subscription in the callback URL given to the hub.
+	ID string
+	// Hub is the URL of the hub, and Topic the URL of the feed as the hub
+	// knows it.
+	Hub   string
+	Topic string
+	// Secret is used by the hub to sign the content it pushes.
+	Secret string
+	// ExpiresAt is when the lease granted by the hub ends, it is zero until
+	// the hub has verified the subscription.
+	ExpiresAt time.Time
+	// RenewAt is when the subscription should be renewed, some time before it
+	// expires.
+	RenewAt time.Time
+}
+
+// Active reports whether the hub is expected to be pushing updates at now.
+func (s HubSubscription) Active(now time.Time) bool {
+	return s.ExpiresAt.After(now)
+}
+
+// HubSubscription returns the subscription to a hub for the feed at uri. If
+// there is none the zero value is returned.
+func (d *DB) HubSubscription(ctx context.Context, uri string) (HubSubscription, error) {
+	return d.hubSubscription(ctx, "FeedURL", uri)
+}
+
+// HubSubscriptionByID returns the subscription to a hub with the given id. If
+// there is none the zero value is returned.
+func (d *DB) HubSubscriptionByID(ctx context.Context, id string) (HubSubscription, error) {
+	return d.hubSubscription(ctx, "ID", id)
+}
+
+func (d *DB) hubSubscription(ctx context.Context, column, value string) (HubSubscription, error) {
+	row := d.db.QueryRowContext(ctx,
+		"SELECT FeedURL, ID, Hub, Topic, Secret, ExpiresAt, RenewAt FROM hubSubscriptions WHERE "+column+" = ?",
+		value)
+
+	var (
+		sub                HubSubscription
+		expiresAt, renewAt *time.Time
+	)
+	if err := row.Scan(&sub.FeedURL, &sub.ID, &sub.Hub, &sub.Topic, &sub.Secret, &expiresAt, &renewAt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return HubSubscription{}, nil
+		}
+		return HubSubscription{}, fmt.Errorf("scanning hub subscription row: %w", err)
+	}
+
+	if expiresAt != nil {
+		sub.ExpiresAt = *expiresAt
+	}
+	if renewAt != nil {
+		sub.RenewAt = *renewAt
+	}
+
+	return sub, nil
+}
+
+// SetHubSubscription stores the subscription, replacing any existing one for
+// the same feed.
+func (d *DB) SetHubSubscription(ctx context.Context, sub HubSubscription) error {
+	_, err := d.db.ExecContext(ctx,
+		`INSERT INTO hubSubscriptions (FeedURL, ID, Hub, Topic, Secret, ExpiresAt, RenewAt)
+		VALUES (?,       ?,  ?,   ?,     ?,      ?,         ?)
+		ON CONFLICT (FeedURL) DO UPDATE SET
+			ID = excluded.ID,
+			Hub = excluded.Hub,
+			Topic = excluded.Topic,
+			Secret = excluded.Secret,
+			ExpiresAt = excluded.ExpiresAt,
+			RenewAt = excluded.RenewAt`,
+		sub.FeedURL,
+		sub.ID,
+		sub.Hub,
+		sub.Topic,
+		sub.Secret,
+		nullTime(sub.ExpiresAt),
+		nullTime(sub.RenewAt))
+
+	return err
+}
+
+// RemoveHubSubscription forgets the subscription to a hub for the feed at uri,
+// so that it goes back to being polled.
+func (d *DB) RemoveHubSubscription(ctx context.Context, uri string) error {
+	_, err := d.db.ExecContext(ctx, "DELETE FROM hubSubscriptions WHERE FeedURL = ?", uri)
+
+	return err
+}
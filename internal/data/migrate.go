@@ -0,0 +1,509 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// ErrSchemaTooNew is returned when the database has been migrated by a newer
+// version of arboretum than is running.
+var ErrSchemaTooNew = errors.New("database schema is newer than supported")
+
+// A Migration is a numbered step that changes the database schema. Migrations
+// are applied in order, each inside its own transaction.
+type Migration struct {
+	Version int
+	Name    string
+	up      func(context.Context, sqlTx) error
+}
+
+// sqliteMigrations lists every schema change in the order they must be
+// applied. Only ever append to this list, never edit a migration that has been
+// released. Each new migration must also be added to postgresMigrations, with
+// the same version.
+var sqliteMigrations = []Migration{
+	{
+		Version: 1,
+		Name:    "create feeds and feedItems",
+		// uses IF NOT EXISTS as databases created before versioning already
+		// have these tables
+		up: execSQL(`
+			CREATE TABLE IF NOT EXISTS feeds (
+				URL         TEXT NOT NULL PRIMARY KEY,
+				WebsiteURL  TEXT,
+				Title       TEXT,
+				UpdatedAt   DATETIME
+			);
+
+			CREATE TABLE IF NOT EXISTS feedItems (
+				Key       TEXT NOT NULL,
+				FeedURL   TEXT NOT NULL,
+				PermaLink TEXT,
+				PubDate   DATETIME,
+				Title     TEXT,
+				Link      TEXT,
+				PRIMARY KEY (Key, FeedURL)
+			);
+		`),
+	},
+	{
+		Version: 2,
+		Name:    "add ETag and LastModified to feeds",
+		up: addColumns("feeds",
+			"ETag TEXT",
+			"LastModified TEXT"),
+	},
+	{
+		Version: 3,
+		Name:    "add per feed retention",
+		up: addColumns("feeds",
+			"KeepItems INTEGER",
+			"KeepDays INTEGER"),
+	},
+	{
+		Version: 4,
+		Name:    "add NextPollAt to feeds",
+		up: addColumns("feeds",
+			"NextPollAt DATETIME"),
+	},
+	{
+		Version: 5,
+		Name:    "add fetch status to feeds",
+		up: addColumns("feeds",
+			"ErrorCount INTEGER NOT NULL DEFAULT 0",
+			"LastError TEXT",
+			"FailingSince DATETIME",
+			"LastSuccessAt DATETIME",
+			"Dead BOOLEAN NOT NULL DEFAULT 0"),
+	},
+	{
+		Version: 6,
+		Name:    "add Gone to feeds",
+		up: addColumns("feeds",
+			"Gone BOOLEAN NOT NULL DEFAULT 0"),
+	},
+	{
+		Version: 7,
+		Name:    "create itemReads",
+		up: execSQL(`
+			CREATE TABLE itemReads (
+				Key     TEXT NOT NULL,
+				FeedURL TEXT NOT NULL,
+				ReadAt  DATETIME NOT NULL,
+				PRIMARY KEY (Key, FeedURL)
+			);
+		`),
+	},
+	{
+		Version: 8,
+		Name:    "create categories",
+		up: func(ctx context.Context, tx sqlTx) error {
+			// top-level categories have a ParentID of 0 so that they are covered by
+			// the unique constraint
+			if err := execSQL(`
+				CREATE TABLE categories (
+					ID       INTEGER PRIMARY KEY,
+					Name     TEXT NOT NULL,
+					ParentID INTEGER NOT NULL DEFAULT 0,
+					UNIQUE (ParentID, Name)
+				);
+			`)(ctx, tx); err != nil {
+				return err
+			}
+
+			return addColumns("feeds", "CategoryID INTEGER")(ctx, tx)
+		},
+	},
+	{
+		Version: 9,
+		Name:    "add content to feedItems",
+		up: addColumns("feedItems",
+			"Summary TEXT NOT NULL DEFAULT ''",
+			"Content TEXT NOT NULL DEFAULT ''",
+			"Author TEXT NOT NULL DEFAULT ''",
+			"EnclosureURL TEXT NOT NULL DEFAULT ''",
+			"EnclosureType TEXT NOT NULL DEFAULT ''",
+			"EnclosureLength INTEGER NOT NULL DEFAULT 0"),
+	},
+	{
+		Version: 10,
+		Name:    "create hubSubscriptions",
+		up: execSQL(`
+			CREATE TABLE hubSubscriptions (
+				FeedURL   TEXT NOT NULL PRIMARY KEY,
+				ID        TEXT NOT NULL UNIQUE,
+				Hub       TEXT NOT NULL,
+				Topic     TEXT NOT NULL,
+				Secret    TEXT NOT NULL,
+				ExpiresAt DATETIME,
+				RenewAt   DATETIME
+			);
+		`),
+	},
+	{
+		Version: 11,
+		Name:    "create settings",
+		up: execSQL(`
+			CREATE TABLE settings (
+				Key   TEXT NOT NULL PRIMARY KEY,
+				Value TEXT NOT NULL
+			);
+		`),
+	},
+	{
+		Version: 12,
+		Name:    "add per feed settings",
+		up: addColumns("feeds",
+			"CustomTitle TEXT",
+			"RefreshSeconds INTEGER",
+			"Paused BOOLEAN NOT NULL DEFAULT 0"),
+	},
+	{
+		Version: 13,
+		Name:    "add users",
+		// existing subscriptions and read state are given to a user with no
+		// name, that the first person to sign in claims. feeds.CategoryID is
+		// left unused, as subscriptions now hold the category.
+		up: execSQL(`
+			CREATE TABLE users (
+				ID   INTEGER PRIMARY KEY,
+				Name TEXT NOT NULL UNIQUE,
+				Me   TEXT NOT NULL UNIQUE
+			);
+
+			INSERT INTO users (Name, Me)
+			SELECT '', '' WHERE EXISTS (SELECT 1 FROM feeds);
+
+			CREATE TABLE subscriptions (
+				UserID     INTEGER NOT NULL,
+				FeedURL    TEXT NOT NULL,
+				CategoryID INTEGER,
+				PRIMARY KEY (UserID, FeedURL)
+			);
+
+			INSERT INTO subscriptions (UserID, FeedURL, CategoryID)
+			SELECT u.ID, f.URL, f.CategoryID FROM feeds f CROSS JOIN users u;
+
+			CREATE TABLE userReads (
+				UserID  INTEGER NOT NULL,
+				Key     TEXT NOT NULL,
+				FeedURL TEXT NOT NULL,
+				ReadAt  DATETIME NOT NULL,
+				PRIMARY KEY (UserID, Key, FeedURL)
+			);
+
+			INSERT INTO userReads (UserID, Key, FeedURL, ReadAt)
+			SELECT u.ID, r.Key, r.FeedURL, r.ReadAt FROM itemReads r CROSS JOIN users u;
+
+			DROP TABLE itemReads;
+			ALTER TABLE userReads RENAME TO itemReads;
+		`),
+	},
+	{
+		Version: 14,
+		Name:    "move feed settings to subscriptions",
+		// each subscriber starts with the settings the feed had. The columns
+		// on feeds are left unused.
+		up: execSQL(`
+			ALTER TABLE subscriptions ADD COLUMN CustomTitle TEXT;
+			ALTER TABLE subscriptions ADD COLUMN RefreshSeconds INTEGER;
+			ALTER TABLE subscriptions ADD COLUMN Paused BOOLEAN NOT NULL DEFAULT 0;
+
+			UPDATE subscriptions SET
+				CustomTitle    = (SELECT f.CustomTitle FROM feeds f WHERE f.URL = subscriptions.FeedURL),
+				RefreshSeconds = (SELECT f.RefreshSeconds FROM feeds f WHERE f.URL = subscriptions.FeedURL),
+				Paused         = COALESCE((SELECT f.Paused FROM feeds f WHERE f.URL = subscriptions.FeedURL), 0);
+		`),
+	},
+	{
+		Version: 15,
+		Name:    "give feedItems an ID",
+		// the search index refers to items by rowid, which VACUUM may renumber
+		// unless it is an INTEGER PRIMARY KEY. Dropping feedItems drops the
+		// triggers of the search index, so it is rebuilt on next Open.
+		up: execSQL(`
+			CREATE TABLE newFeedItems (
+				ID              INTEGER PRIMARY KEY,
+				Key             TEXT NOT NULL,
+				FeedURL         TEXT NOT NULL,
+				PermaLink       TEXT,
+				PubDate         DATETIME,
+				Title           TEXT,
+				Link            TEXT,
+				Summary         TEXT NOT NULL DEFAULT '',
+				Content         TEXT NOT NULL DEFAULT '',
+				Author          TEXT NOT NULL DEFAULT '',
+				EnclosureURL    TEXT NOT NULL DEFAULT '',
+				EnclosureType   TEXT NOT NULL DEFAULT '',
+				EnclosureLength INTEGER NOT NULL DEFAULT 0,
+				UNIQUE (Key, FeedURL)
+			);
+
+			INSERT INTO newFeedItems (ID, Key, FeedURL, PermaLink, PubDate, Title, Link, Summary, Content, Author, EnclosureURL, EnclosureType, EnclosureLength)
+			SELECT rowid, Key, FeedURL, PermaLink, PubDate, Title, Link, Summary, Content, Author, EnclosureURL, EnclosureType, EnclosureLength FROM feedItems;
+
+			DROP TABLE feedItems;
+			ALTER TABLE newFeedItems RENAME TO feedItems;
+		`),
+	},
+}
+
+// postgresMigrations lists the schema changes for PostgreSQL, which was first
+// supported once sqliteMigrations had reached version 11, so starts by
+// creating the whole schema. Versions 12 to 15 are already included in it, so
+// do nothing and are kept only to keep the two lists in step.
+var postgresMigrations = []Migration{
+	{
+		Version: 11,
+		Name:    "create schema",
+		up: execSQL(`
+			CREATE TABLE feeds (
+				URL           TEXT NOT NULL PRIMARY KEY,
+				WebsiteURL    TEXT,
+				Title         TEXT,
+				UpdatedAt     TIMESTAMP WITH TIME ZONE,
+				ETag          TEXT,
+				LastModified  TEXT,
+				KeepItems     INTEGER,
+				KeepDays      INTEGER,
+				NextPollAt    TIMESTAMP WITH TIME ZONE,
+				ErrorCount    INTEGER NOT NULL DEFAULT 0,
+				LastError     TEXT,
+				FailingSince  TIMESTAMP WITH TIME ZONE,
+				LastSuccessAt TIMESTAMP WITH TIME ZONE,
+				Dead          BOOLEAN NOT NULL DEFAULT FALSE,
+				Gone          BOOLEAN NOT NULL DEFAULT FALSE
+			);
+
+			CREATE TABLE feedItems (
+				Key             TEXT NOT NULL,
+				FeedURL         TEXT NOT NULL,
+				PermaLink       TEXT,
+				PubDate         TIMESTAMP WITH TIME ZONE,
+				Title           TEXT,
+				Link            TEXT,
+				Summary         TEXT NOT NULL DEFAULT '',
+				Content         TEXT NOT NULL DEFAULT '',
+				Author          TEXT NOT NULL DEFAULT '',
+				EnclosureURL    TEXT NOT NULL DEFAULT '',
+				EnclosureType   TEXT NOT NULL DEFAULT '',
+				EnclosureLength BIGINT NOT NULL DEFAULT 0,
+				PRIMARY KEY (Key, FeedURL)
+			);
+
+			CREATE TABLE users (
+				ID   BIGSERIAL PRIMARY KEY,
+				Name TEXT NOT NULL UNIQUE,
+				Me   TEXT NOT NULL UNIQUE
+			);
+
+			CREATE TABLE subscriptions (
+				UserID         BIGINT NOT NULL,
+				FeedURL        TEXT NOT NULL,
+				CategoryID     BIGINT,
+				CustomTitle    TEXT,
+				RefreshSeconds INTEGER,
+				Paused         BOOLEAN NOT NULL DEFAULT FALSE,
+				PRIMARY KEY (UserID, FeedURL)
+			);
+
+			CREATE TABLE itemReads (
+				UserID  BIGINT NOT NULL,
+				Key     TEXT NOT NULL,
+				FeedURL TEXT NOT NULL,
+				ReadAt  TIMESTAMP WITH TIME ZONE NOT NULL,
+				PRIMARY KEY (UserID, Key, FeedURL)
+			);
+
+			CREATE TABLE categories (
+				ID       BIGSERIAL PRIMARY KEY,
+				Name     TEXT NOT NULL,
+				ParentID BIGINT NOT NULL DEFAULT 0,
+				UNIQUE (ParentID, Name)
+			);
+
+			CREATE TABLE hubSubscriptions (
+				FeedURL   TEXT NOT NULL PRIMARY KEY,
+				ID        TEXT NOT NULL UNIQUE,
+				Hub       TEXT NOT NULL,
+				Topic     TEXT NOT NULL,
+				Secret    TEXT NOT NULL,
+				ExpiresAt TIMESTAMP WITH TIME ZONE,
+				RenewAt   TIMESTAMP WITH TIME ZONE
+			);
+
+			CREATE TABLE settings (
+				Key   TEXT NOT NULL PRIMARY KEY,
+				Value TEXT NOT NULL
+			);
+		`),
+	},
+	{Version: 12, Name: "add per feed settings", up: noChange},
+	{Version: 13, Name: "add users", up: noChange},
+	{Version: 14, Name: "move feed settings to subscriptions", up: noChange},
+	// only the SQLite search index needs an item ID
+	{Version: 15, Name: "give feedItems an ID", up: noChange},
+}
+
+func execSQL(query string) func(context.Context, sqlTx) error {
+	return func(ctx context.Context, tx sqlTx) error {
+		_, err := tx.ExecContext(ctx, query)
+		return err
+	}
+}
+
+// noChange is the migration for a version that only changes the schema of the
+// other dialect.
+func noChange(context.Context, sqlTx) error {
+	return nil
+}
+
+// addColumns adds each column, given as "Name TYPE", to table. Columns that
+// already exist are skipped so that databases which gained them before
+// versioning can still be migrated.
+func addColumns(table string, columns ...string) func(context.Context, sqlTx) error {
+	return func(ctx context.Context, tx sqlTx) error {
+		existing := map[string]bool{}
+
+		rows, err := tx.QueryContext(ctx, "SELECT name FROM pragma_table_info(?)", table)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var name string
+			if err := rows.Scan(&name); err != nil {
+				return err
+			}
+			existing[name] = true
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for _, column := range columns {
+			name := strings.Fields(column)[0]
+			if existing[name] {
+				continue
+			}
+
+			if _, err := tx.ExecContext(ctx, "ALTER TABLE "+table+" ADD COLUMN "+column); err != nil {
+				return fmt.Errorf("adding %s.%s: %w", table, name, err)
+			}
+		}
+
+		return nil
+	}
+}
+
+// SchemaVersion returns the version of the latest migration applied to the
+// database. It is 0 for a database that has never been migrated, which is left
+// unchanged.
+func (d *DB) SchemaVersion(ctx context.Context) (int, error) {
+	var versioned bool
+	if err := d.db.QueryRowContext(ctx, d.db.dialect.tableExists, "schema_version").Scan(&versioned); err != nil {
+		return 0, fmt.Errorf("finding schema_version: %w", err)
+	}
+	if !versioned {
+		return 0, nil
+	}
+
+	var version sql.NullInt64
+	if err := d.db.QueryRowContext(ctx, "SELECT MAX(Version) FROM schema_version").Scan(&version); err != nil {
+		return 0, fmt.Errorf("scanning schema version: %w", err)
+	}
+
+	return int(version.Int64), nil
+}
+
+// PendingMigrations returns the migrations that have not yet been applied to
+// the database.
+func (d *DB) PendingMigrations(ctx context.Context) ([]Migration, error) {
+	version, err := d.SchemaVersion(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	migrations := d.db.dialect.migrations
+
+	latest := migrations[len(migrations)-1].Version
+	if version > latest {
+		return nil, fmt.Errorf("%w: database is at version %d, latest known is %d", ErrSchemaTooNew, version, latest)
+	}
+
+	var pending []Migration
+	for _, migration := range migrations {
+		if migration.Version > version {
+			pending = append(pending, migration)
+		}
+	}
+
+	return pending, nil
+}
+
+func (d *DB) migrate() error {
+	ctx := context.Background()
+
+	pending, err := d.PendingMigrations(ctx)
+	if err != nil {
+		return err
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+
+	if _, err := d.db.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_version (
+			Version   INTEGER NOT NULL PRIMARY KEY,
+			Name      TEXT,
+			AppliedAt `+d.db.dialect.timestamp+`
+		);
+`); err != nil {
+		return err
+	}
+
+	for _, migration := range pending {
+		slog.Info("migrating", slog.Int("version", migration.Version), slog.String("name", migration.Name))
+
+		if err := d.applyMigration(ctx, migration); err != nil {
+			return fmt.Errorf("migration %d (%s): %w", migration.Version, migration.Name, err)
+		}
+	}
+
+	return nil
+}
+
+func (d *DB) applyMigration(ctx context.Context, migration Migration) (err error) {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		action := tx.Rollback
+		if err == nil {
+			action = tx.Commit
+		}
+
+		if rerr := action(); rerr != nil {
+			slog.Error("completing transaction", slog.Any("err", rerr))
+		}
+	}()
+
+	if err = migration.up(ctx, tx); err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx,
+		"INSERT INTO schema_version (Version, Name, AppliedAt) VALUES (?, ?, ?)",
+		migration.Version,
+		migration.Name,
+		time.Now())
+
+	return err
+}
@@ -0,0 +1,241 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// ErrSearchUnavailable is returned by Search when SQLite was built without
+// FTS5. Build with -tags sqlite_fts5 to enable it.
+var ErrSearchUnavailable = errors.New("search is not available, build with -tags sqlite_fts5")
+
+// searchIndex lists the statements that create the full-text index over
+// feedItems. The index uses feedItems as its content, keyed by its ID, and
+// triggers keep it up to date as items are added, changed and removed.
+var searchIndex = []string{
+	`CREATE VIRTUAL TABLE itemSearch USING fts5(
+		Title,
+		Summary,
+		content='feedItems',
+		content_rowid='ID'
+	)`,
+	`CREATE TRIGGER itemSearchInsert AFTER INSERT ON feedItems BEGIN
+		INSERT INTO itemSearch (rowid, Title, Summary) VALUES (new.ID, new.Title, new.Summary);
+	END`,
+	`CREATE TRIGGER itemSearchDelete AFTER DELETE ON feedItems BEGIN
+		INSERT INTO itemSearch (itemSearch, rowid, Title, Summary) VALUES ('delete', old.ID, old.Title, old.Summary);
+	END`,
+	`CREATE TRIGGER itemSearchUpdate AFTER UPDATE ON feedItems BEGIN
+		INSERT INTO itemSearch (itemSearch, rowid, Title, Summary) VALUES ('delete', old.ID, old.Title, old.Summary);
+		INSERT INTO itemSearch (rowid, Title, Summary) VALUES (new.ID, new.Title, new.Summary);
+	END`,
+	`INSERT INTO itemSearch (itemSearch) VALUES ('rebuild')`,
+}
+
+var dropSearchTriggers = []string{
+	"DROP TRIGGER IF EXISTS itemSearchInsert",
+	"DROP TRIGGER IF EXISTS itemSearchDelete",
+	"DROP TRIGGER IF EXISTS itemSearchUpdate",
+}
+
+// buildSearchIndex creates the full-text index when it, or any of its triggers,
+// is missing. It is not a migration as FTS5 depends on how SQLite was built, so
+// when it is missing the triggers are removed to let items still be written
+// and search is left disabled. PostgreSQL needs no index, its text search is
+// always available.
+func (d *DB) buildSearchIndex(ctx context.Context) (err error) {
+	if d.db.dialect == postgres {
+		d.search = true
+		return nil
+	}
+
+	var available bool
+	if err := d.db.QueryRowContext(ctx,
+		"SELECT sqlite_compileoption_used('ENABLE_FTS5')").Scan(&available); err != nil {
+		return err
+	}
+
+	if !available {
+		slog.Warn("search disabled", slog.Any("err", ErrSearchUnavailable))
+
+		for _, query := range dropSearchTriggers {
+			if _, err := d.db.ExecContext(ctx, query); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// the index is only rebuilt when it may have missed changes to items
+	var existing int
+	if err := d.db.QueryRowContext(ctx,
+		`SELECT COUNT(*) FROM sqlite_master
+		 WHERE name IN ('itemSearch', 'itemSearchInsert', 'itemSearchDelete', 'itemSearchUpdate')`).Scan(&existing); err != nil {
+		return err
+	}
+	if existing == 1+len(dropSearchTriggers) {
+		d.search = true
+		return nil
+	}
+
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		action := tx.Rollback
+		if err == nil {
+			action = tx.Commit
+		}
+
+		if rerr := action(); rerr != nil {
+			slog.Error("completing transaction", slog.Any("err", rerr))
+		}
+	}()
+
+	for _, query := range dropSearchTriggers {
+		if _, err = tx.ExecContext(ctx, query); err != nil {
+			return err
+		}
+	}
+
+	if _, err = tx.ExecContext(ctx, "DROP TABLE IF EXISTS itemSearch"); err != nil {
+		return err
+	}
+
+	for _, query := range searchIndex {
+		if _, err = tx.ExecContext(ctx, query); err != nil {
+			return fmt.Errorf("building search index: %w", err)
+		}
+	}
+
+	d.search = true
+	return nil
+}
+
+// SearchQuery filters the items returned by Search.
+type SearchQuery struct {
+	// Text is the words to look for, every word must appear in an item.
+	Text string
+	// User limits results to the feeds a user subscribes to.
+	User int64
+	// AllUsers searches the feeds of every user instead, ignoring User.
+	AllUsers bool
+	// FeedURL limits results to a single feed when given.
+	FeedURL string
+	// Since and Until limit results to items published within the range, a
+	// zero value leaves that end open.
+	Since, Until time.Time
+	// Limit is the maximum number of results, 0 uses a default of 50.
+	Limit int
+}
+
+// A SearchResult is an item matching a search, along with its feed.
+type SearchResult struct {
+	FeedURL    string
+	FeedTitle  string
+	WebsiteURL string
+	Item       FeedItem
+}
+
+// Search finds the items matching query, best matches first.
+func (d *DB) Search(ctx context.Context, query SearchQuery) ([]SearchResult, error) {
+	if !d.search {
+		return nil, ErrSearchUnavailable
+	}
+
+	match := matchExpression(query.Text)
+	if match == "" {
+		return nil, nil
+	}
+
+	// SQLite looks the words up in the FTS5 index, PostgreSQL matches them
+	// against the item text as it goes
+	var (
+		from  = "itemSearch JOIN feedItems i ON i.ID = itemSearch.rowid"
+		cond  = "itemSearch MATCH ?"
+		order = "itemSearch.rank"
+	)
+	if d.db.dialect == postgres {
+		const document = "to_tsvector('simple', concat_ws(' ', i.Title, i.Summary))"
+
+		from = "feedItems i CROSS JOIN plainto_tsquery('simple', ?) AS query"
+		cond = document + " @@ query"
+		order = "ts_rank(" + document + ", query) DESC, i.PubDate DESC"
+		match = query.Text
+	}
+
+	limit := query.Limit
+	if limit <= 0 {
+		limit = 50
+	}
+
+	var (
+		where = []string{cond}
+		args  = []any{match}
+	)
+	if !query.AllUsers {
+		where = append(where, "i.FeedURL IN (SELECT FeedURL FROM subscriptions WHERE UserID = ?)")
+		args = append(args, query.User)
+	}
+	if query.FeedURL != "" {
+		where = append(where, "i.FeedURL = ?")
+		args = append(args, query.FeedURL)
+	}
+	if !query.Since.IsZero() {
+		where = append(where, "i.PubDate >= ?")
+		args = append(args, query.Since)
+	}
+	if !query.Until.IsZero() {
+		where = append(where, "i.PubDate < ?")
+		args = append(args, query.Until)
+	}
+	args = append(args, limit)
+
+	rows, err := d.db.QueryContext(ctx,
+		`SELECT i.Key, i.PermaLink, i.PubDate, i.Title, i.Link, i.Summary, f.URL, f.Title, f.WebsiteURL
+		 FROM `+from+`
+		 JOIN feeds f ON f.URL = i.FeedURL
+		 WHERE `+strings.Join(where, " AND ")+`
+		 ORDER BY `+order+`
+		 LIMIT ?`,
+		args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []SearchResult
+	for rows.Next() {
+		var (
+			result            SearchResult
+			title, websiteURL sql.NullString
+		)
+		if err := rows.Scan(&result.Item.Key, &result.Item.PermaLink, &result.Item.PubDate, &result.Item.Title, &result.Item.Link, &result.Item.Summary,
+			&result.FeedURL, &title, &websiteURL); err != nil {
+			return nil, fmt.Errorf("scanning row: %w", err)
+		}
+
+		result.FeedTitle = title.String
+		result.WebsiteURL = websiteURL.String
+		results = append(results, result)
+	}
+
+	return results, rows.Err()
+}
+
+// matchExpression quotes each word of text so that user input is never read
+// as FTS5 query syntax.
+func matchExpression(text string) string {
+	var terms []string
+	for _, word := range strings.Fields(text) {
+		terms = append(terms, `"`+strings.ReplaceAll(word, `"`, `""`)+`"`)
+	}
+
+	return strings.Join(terms, " ")
+}
@@ -0,0 +1,32 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+)
+
+// Setting returns the value stored for key, and whether there was one.
+func (d *DB) Setting(ctx context.Context, key string) (string, bool, error) {
+	var value string
+	err := d.db.QueryRowContext(ctx, "SELECT Value FROM settings WHERE Key = ?", key).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+
+	return value, true, nil
+}
+
+// SetSetting stores value for key, replacing any existing value.
+func (d *DB) SetSetting(ctx context.Context, key, value string) error {
+	_, err := d.db.ExecContext(ctx,
+		`INSERT INTO settings (Key, Value) VALUES (?, ?)
+		ON CONFLICT (Key) DO UPDATE SET Value = excluded.Value`,
+		key,
+		value)
+
+	return err
+}
@@ -0,0 +1,97 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Stats counts what is stored in the database.
+type Stats struct {
+	Feeds int
+	// Failing, Dead and Gone count the feeds with each status. Dead and gone
+	// feeds are also counted as failing.
+	Failing int
+	Dead    int
+	Gone    int
+	Items   int
+	// Unread counts the items that no one has read.
+	Unread int
+	Users  int
+	// NewestItem is when the most recent item was published, it is zero when
+	// there are no items.
+	NewestItem time.Time
+}
+
+// Stats returns counts of the feeds and items stored.
+func (d *DB) Stats(ctx context.Context) (Stats, error) {
+	var stats Stats
+	if err := d.db.QueryRowContext(ctx,
+		`SELECT
+			(SELECT COUNT(*) FROM feeds),
+			(SELECT COUNT(*) FROM feeds WHERE ErrorCount > 0),
+			(SELECT COUNT(*) FROM feeds WHERE Dead),
+			(SELECT COUNT(*) FROM feeds WHERE Gone),
+			(SELECT COUNT(*) FROM feedItems),
+			(SELECT COUNT(*) FROM feedItems i
+			 WHERE NOT EXISTS (SELECT 1 FROM itemReads r WHERE r.Key = i.Key AND r.FeedURL = i.FeedURL)),
+			(SELECT COUNT(*) FROM users WHERE Name <> '')`).Scan(
+		&stats.Feeds, &stats.Failing, &stats.Dead, &stats.Gone, &stats.Items, &stats.Unread, &stats.Users); err != nil {
+		return Stats{}, fmt.Errorf("scanning stats: %w", err)
+	}
+
+	// queried on its own, as the aggregate MAX would lose the column's type
+	var newest *time.Time
+	err := d.db.QueryRowContext(ctx,
+		"SELECT PubDate FROM feedItems ORDER BY PubDate DESC LIMIT 1").Scan(&newest)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return Stats{}, fmt.Errorf("scanning newest item: %w", err)
+	}
+	if newest != nil {
+		stats.NewestItem = *newest
+	}
+
+	return stats, nil
+}
+
+// FeedHealth counts the feeds in each state: "ok", "failing", "dead" or
+// "gone". Each feed is only counted in its worst state.
+func (d *DB) FeedHealth(ctx context.Context) (map[string]int, error) {
+	rows, err := d.db.QueryContext(ctx,
+		`SELECT CASE
+			WHEN Gone THEN 'gone'
+			WHEN Dead THEN 'dead'
+			WHEN ErrorCount > 0 THEN 'failing'
+			ELSE 'ok'
+		 END AS State, COUNT(*)
+		 FROM feeds
+		 GROUP BY State`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	health := map[string]int{}
+	for rows.Next() {
+		var (
+			state string
+			count int
+		)
+		if err := rows.Scan(&state, &count); err != nil {
+			return nil, fmt.Errorf("scanning row: %w", err)
+		}
+		health[state] = count
+	}
+
+	return health, rows.Err()
+}
+
+// ItemCount returns the number of items stored.
+func (d *DB) ItemCount(ctx context.Context) (int, error) {
+	var count int
+	err := d.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM feedItems").Scan(&count)
+
+	return count, err
+}
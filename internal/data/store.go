@@ -0,0 +1,68 @@
+package data
+
+import (
+	"context"
+	"time"
+)
+
+// A Store is where subscriptions, feeds and their items are kept. DB is the
+// implementation, backed by SQLite or PostgreSQL depending on what Open is
+// given.
+type Store interface {
+	Ping(context.Context) error
+	Close() error
+
+	// subscriptions
+	Subscribe(ctx context.Context, user int64, uri string) error
+	Unsubscribe(ctx context.Context, user int64, uri string) (bool, error)
+	Subscriptions(context.Context) ([]string, error)
+	AddSubscription(context.Context, int64, Subscription) error
+	SubscriptionDetails(context.Context, int64) ([]Subscription, error)
+	SetCategory(ctx context.Context, user int64, uri string, category []string) error
+	RenameFeed(ctx context.Context, from, to string) error
+
+	// users
+	Users(context.Context) ([]User, error)
+	UserByName(context.Context, string) (User, bool, error)
+	UserByMe(context.Context, string) (User, bool, error)
+	AddUser(ctx context.Context, name, me string) (User, error)
+	UnclaimedUser(context.Context) (User, error)
+
+	// feeds and items
+	ReadAll(context.Context, int64) ([]Feed, error)
+	ListFeeds(context.Context) ([]Feed, error)
+	UpdateFeed(context.Context, Feed) error
+	Search(context.Context, SearchQuery) ([]SearchResult, error)
+	MarkItemRead(ctx context.Context, user int64, uri, key string) error
+	MarkFeedRead(ctx context.Context, user int64, uri string) error
+	MarkAllRead(context.Context, int64) error
+	SetRetention(Retention)
+	SetFeedRetention(context.Context, string, *Retention) error
+	FeedSettings(ctx context.Context, user int64, uri string) (FeedSettings, error)
+	SetFeedSettings(ctx context.Context, user int64, uri string, settings FeedSettings) (bool, error)
+	PollSettings(context.Context, string) (FeedSettings, error)
+	Prune(context.Context) (int64, error)
+
+	// metadata kept for each feed
+	UpdatedAt(context.Context, string) (time.Time, error)
+	SetUpdatedAt(context.Context, string, time.Time) error
+	Validators(context.Context, string) (etag, lastModified string, err error)
+	SetValidators(ctx context.Context, uri, etag, lastModified string) error
+	NextPoll(context.Context, string) (time.Time, error)
+	SetNextPoll(context.Context, string, time.Time) error
+	FeedStatus(context.Context, string) (FeedStatus, error)
+	SetFeedStatus(context.Context, string, FeedStatus) error
+	HubSubscription(context.Context, string) (HubSubscription, error)
+	HubSubscriptionByID(context.Context, string) (HubSubscription, error)
+	SetHubSubscription(context.Context, HubSubscription) error
+	RemoveHubSubscription(context.Context, string) error
+
+	// settings and counts
+	Setting(context.Context, string) (string, bool, error)
+	SetSetting(ctx context.Context, key, value string) error
+	Stats(context.Context) (Stats, error)
+	FeedHealth(context.Context) (map[string]int, error)
+	ItemCount(context.Context) (int, error)
+}
+
+var _ Store = (*DB)(nil)
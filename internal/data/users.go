@@ -0,0 +1,126 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+)
+
+// A User has their own subscriptions, folders and read state. Feeds that more
+// than one user subscribes to are still only fetched once.
+type User struct {
+	ID int64
+	// Name is used in the address of the user's garden. The user that holds
+	// subscriptions from before there were users has an empty Name until
+	// someone signs in and claims them.
+	Name string
+	// Me is the profile URL the user signs in with.
+	Me string
+}
+
+// Users lists every user, ordered by name.
+func (d *DB) Users(ctx context.Context) ([]User, error) {
+	rows, err := d.db.QueryContext(ctx, "SELECT ID, Name, Me FROM users ORDER BY Name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []User
+	for rows.Next() {
+		var user User
+		if err := rows.Scan(&user.ID, &user.Name, &user.Me); err != nil {
+			return nil, fmt.Errorf("scanning row: %w", err)
+		}
+		users = append(users, user)
+	}
+
+	return users, rows.Err()
+}
+
+// UserByName returns the user with name, ok is false if there is none.
+func (d *DB) UserByName(ctx context.Context, name string) (user User, ok bool, err error) {
+	return d.user(ctx, "Name", name)
+}
+
+// UserByMe returns the user that signs in as me, ok is false if there is none.
+func (d *DB) UserByMe(ctx context.Context, me string) (user User, ok bool, err error) {
+	return d.user(ctx, "Me", me)
+}
+
+func (d *DB) user(ctx context.Context, column, value string) (User, bool, error) {
+	row := d.db.QueryRowContext(ctx,
+		"SELECT ID, Name, Me FROM users WHERE "+column+" = ?",
+		value)
+
+	var user User
+	if err := row.Scan(&user.ID, &user.Name, &user.Me); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return User{}, false, nil
+		}
+		return User{}, false, fmt.Errorf("scanning user row: %w", err)
+	}
+
+	return user, true, nil
+}
+
+// AddUser adds a user that signs in as me. If there is an unclaimed user,
+// holding subscriptions from before there were users, it becomes them instead.
+func (d *DB) AddUser(ctx context.Context, name, me string) (user User, err error) {
+	if name == "" || me == "" {
+		return User{}, errors.New("a user needs a name and a profile URL")
+	}
+
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return User{}, err
+	}
+	defer func() {
+		action := tx.Rollback
+		if err == nil {
+			action = tx.Commit
+		}
+
+		if rerr := action(); rerr != nil {
+			slog.Error("completing transaction", slog.Any("err", rerr))
+		}
+	}()
+
+	result, err := tx.ExecContext(ctx,
+		"UPDATE users SET Name = ?, Me = ? WHERE Me = ''",
+		name,
+		me)
+	if err != nil {
+		return User{}, err
+	}
+
+	if claimed, err := result.RowsAffected(); err != nil {
+		return User{}, err
+	} else if claimed == 0 {
+		if _, err = tx.ExecContext(ctx, "INSERT INTO users (Name, Me) VALUES (?, ?)", name, me); err != nil {
+			return User{}, fmt.Errorf("adding user %q: %w", name, err)
+		}
+	}
+
+	user = User{Name: name, Me: me}
+	if err = tx.QueryRowContext(ctx, "SELECT ID FROM users WHERE Me = ?", me).Scan(&user.ID); err != nil {
+		return User{}, err
+	}
+
+	return user, nil
+}
+
+// UnclaimedUser returns the user that holds subscriptions until someone signs
+// in, adding them if needed. It is used to manage subscriptions before anyone
+// has signed in.
+func (d *DB) UnclaimedUser(ctx context.Context) (User, error) {
+	if _, err := d.db.ExecContext(ctx,
+		"INSERT INTO users (Name, Me) VALUES ('', '') ON CONFLICT DO NOTHING"); err != nil {
+		return User{}, err
+	}
+
+	user, _, err := d.UserByMe(ctx, "")
+	return user, err
+}
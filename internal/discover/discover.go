@@ -0,0 +1,234 @@
+// Package discover finds the feeds offered by a website.
+package discover
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/charset"
+	"hawx.me/code/arboretum/internal/jsonfeed"
+	"hawx.me/code/arboretum/internal/redirect"
+	"hawx.me/code/riviera/feed"
+)
+
+const userAgent = "arboretum golang"
+
+// maxBody is the most that will be read from any page when discovering.
+const maxBody = 5 << 20
+
+// feedTypes are the content types of <link rel="alternate"> elements that
+// point to feeds.
+var feedTypes = map[string]bool{
+	"application/rss+xml":   true,
+	"application/atom+xml":  true,
+	"application/rdf+xml":   true,
+	"application/feed+json": true,
+	"application/json":      true,
+	"text/xml":              true,
+	"application/xml":       true,
+}
+
+// commonPaths are tried when a page does not link to any feeds.
+var commonPaths = []string{
+	"/feed",
+	"/feed/",
+	"/feed.xml",
+	"/rss",
+	"/rss.xml",
+	"/atom.xml",
+	"/index.xml",
+	"/feed.json",
+}
+
+// A Feed is a URL that has been checked to contain a feed.
+type Feed struct {
+	URL   string
+	Title string
+}
+
+// Find returns the feeds available at uri. If uri is a feed then only it is
+// returned, otherwise if it is a HTML page the feeds it links to, or failing
+// that feeds at common paths on the same site, are returned. Every feed
+// returned has been fetched and parsed, and is given by the URL it was found
+// at unless it has permanently moved.
+func Find(ctx context.Context, client *http.Client, uri string) ([]Feed, error) {
+	page, err := fetch(ctx, client, uri)
+	if err != nil {
+		return nil, err
+	}
+
+	if found, ok := page.parse(); ok {
+		return []Feed{found}, nil
+	}
+
+	if !page.isHTML() {
+		return nil, fmt.Errorf("%s is not a feed or a HTML page", uri)
+	}
+
+	candidates := page.alternates()
+	if len(candidates) == 0 {
+		for _, path := range commonPaths {
+			candidates = append(candidates, page.url.ResolveReference(&url.URL{Path: path}).String())
+		}
+	}
+
+	var feeds []Feed
+	seen := map[string]bool{}
+	for _, candidate := range candidates {
+		if seen[candidate] {
+			continue
+		}
+		seen[candidate] = true
+
+		if found, err := Validate(ctx, client, candidate); err == nil {
+			feeds = append(feeds, found)
+		}
+	}
+
+	return feeds, nil
+}
+
+// Validate checks that uri can be fetched and parsed as a feed.
+func Validate(ctx context.Context, client *http.Client, uri string) (Feed, error) {
+	page, err := fetch(ctx, client, uri)
+	if err != nil {
+		return Feed{}, err
+	}
+
+	found, ok := page.parse()
+	if !ok {
+		return Feed{}, fmt.Errorf("%s is not a feed", uri)
+	}
+
+	return found, nil
+}
+
+type page struct {
+	// url is where the page was fetched from, after any redirects, and
+	// feedURL where it should be subscribed to.
+	url         *url.URL
+	feedURL     *url.URL
+	contentType string
+	body        []byte
+}
+
+func fetch(ctx context.Context, client *http.Client, uri string) (*page, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", uri, nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating request for %v: %w", uri, err)
+	}
+	req.Header.Set("User-Agent", userAgent)
+
+	client, redirects := redirect.Track(client)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("making request for %v: %w", uri, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status fetching %v: %d", uri, resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxBody))
+	if err != nil {
+		return nil, fmt.Errorf("reading %v: %w", uri, err)
+	}
+
+	feedURL := req.URL
+	if movedTo := redirects.MovedTo(); movedTo != nil {
+		feedURL = movedTo
+	}
+
+	return &page{
+		// use the final URL so that links are resolved after any redirects
+		url:         resp.Request.URL,
+		feedURL:     feedURL,
+		contentType: resp.Header.Get("Content-Type"),
+		body:        body,
+	}, nil
+}
+
+func (p *page) parse() (Feed, bool) {
+	if jsonfeed.Is(p.contentType, p.body) {
+		doc, err := jsonfeed.Parse(p.body)
+		if err != nil {
+			return Feed{}, false
+		}
+
+		return Feed{URL: p.feedURL.String(), Title: doc.Title}, true
+	}
+
+	channels, err := feed.Parse(bytes.NewReader(p.body), p.url, charset.NewReaderLabel)
+	if err != nil || len(channels) == 0 {
+		return Feed{}, false
+	}
+
+	return Feed{URL: p.feedURL.String(), Title: channels[0].Title}, true
+}
+
+func (p *page) isHTML() bool {
+	mediaType, _, _ := mime.ParseMediaType(p.contentType)
+	if mediaType == "text/html" || mediaType == "application/xhtml+xml" {
+		return true
+	}
+
+	return strings.HasPrefix(http.DetectContentType(p.body), "text/html")
+}
+
+// alternates returns the URLs of any feeds linked to by the page.
+func (p *page) alternates() []string {
+	doc, err := html.Parse(bytes.NewReader(p.body))
+	if err != nil {
+		return nil
+	}
+
+	var links []string
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode && (n.Data == "link" || n.Data == "a") {
+			var rel, typ, href string
+			for _, attr := range n.Attr {
+				switch strings.ToLower(attr.Key) {
+				case "rel":
+					rel = strings.ToLower(attr.Val)
+				case "type":
+					typ = strings.ToLower(strings.TrimSpace(attr.Val))
+				case "href":
+					href = strings.TrimSpace(attr.Val)
+				}
+			}
+
+			if href != "" && hasToken(rel, "alternate") && feedTypes[typ] {
+				if resolved, err := p.url.Parse(href); err == nil {
+					links = append(links, resolved.String())
+				}
+			}
+		}
+
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+
+	return links
+}
+
+func hasToken(list, token string) bool {
+	for _, field := range strings.Fields(list) {
+		if field == token {
+			return true
+		}
+	}
+
+	return false
+}
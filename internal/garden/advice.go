@@ -0,0 +1,96 @@
+package garden
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"hawx.me/code/riviera/feed/common"
+)
+
+const syndicationNamespace = "http://purl.org/rss/1.0/modules/syndication/"
+
+var updatePeriods = map[string]time.Duration{
+	"hourly":  time.Hour,
+	"daily":   24 * time.Hour,
+	"weekly":  7 * 24 * time.Hour,
+	"monthly": 31 * 24 * time.Hour,
+	"yearly":  365 * 24 * time.Hour,
+}
+
+// headerAdvice returns how long the response says it is fresh for, using
+// Retry-After, Cache-Control: max-age or Expires. It returns 0 if no advice is
+// given.
+func headerAdvice(header http.Header, now time.Time) time.Duration {
+	var advice time.Duration
+
+	if retryAfter := header.Get("Retry-After"); retryAfter != "" {
+		if seconds, err := strconv.Atoi(retryAfter); err == nil {
+			advice = max(advice, time.Duration(seconds)*time.Second)
+		} else if at, err := http.ParseTime(retryAfter); err == nil {
+			advice = max(advice, at.Sub(now))
+		}
+	}
+
+	maxAge, hasMaxAge := cacheControlMaxAge(header.Get("Cache-Control"))
+	if hasMaxAge {
+		advice = max(advice, maxAge)
+	} else if expires := header.Get("Expires"); expires != "" {
+		// Expires is ignored when max-age is given
+		if at, err := http.ParseTime(expires); err == nil {
+			if date, err := http.ParseTime(header.Get("Date")); err == nil {
+				now = date
+			}
+			advice = max(advice, at.Sub(now))
+		}
+	}
+
+	return advice
+}
+
+func cacheControlMaxAge(cacheControl string) (time.Duration, bool) {
+	for _, directive := range strings.Split(cacheControl, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(directive), "=")
+		if strings.ToLower(name) != "max-age" {
+			continue
+		}
+
+		seconds, err := strconv.Atoi(strings.Trim(value, `"`))
+		if err != nil {
+			return 0, false
+		}
+
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	return 0, false
+}
+
+// channelAdvice returns how often the channel says it should be polled, using
+// the RSS <ttl> or the syndication module's updatePeriod and updateFrequency.
+// It returns 0 if no advice is given.
+func channelAdvice(ch *common.Channel) time.Duration {
+	var advice time.Duration
+
+	if ch.TTL > 0 {
+		advice = time.Duration(ch.TTL) * time.Minute
+	}
+
+	sy := ch.Extensions[syndicationNamespace]
+	if periods := sy["updatePeriod"]; len(periods) > 0 {
+		period, ok := updatePeriods[strings.TrimSpace(periods[0].Value)]
+		if ok {
+			frequency := 1
+			if frequencies := sy["updateFrequency"]; len(frequencies) > 0 {
+				if n, err := strconv.Atoi(strings.TrimSpace(frequencies[0].Value)); err == nil && n > 0 {
+					frequency = n
+				}
+			}
+
+			advice = max(advice, period/time.Duration(frequency))
+		}
+	}
+
+	return advice
+}
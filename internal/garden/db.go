@@ -8,8 +8,21 @@ import (
 )
 
 type DB interface {
-	ReadAll(context.Context) ([]data.Feed, error)
+	ReadAll(context.Context, int64) ([]data.Feed, error)
+	SubscriptionDetails(context.Context, int64) ([]data.Subscription, error)
 	UpdateFeed(context.Context, data.Feed) error
 	UpdatedAt(context.Context, string) (time.Time, error)
 	SetUpdatedAt(context.Context, string, time.Time) error
+	Validators(context.Context, string) (etag, lastModified string, err error)
+	SetValidators(ctx context.Context, uri, etag, lastModified string) error
+	NextPoll(context.Context, string) (time.Time, error)
+	SetNextPoll(context.Context, string, time.Time) error
+	FeedStatus(context.Context, string) (data.FeedStatus, error)
+	SetFeedStatus(context.Context, string, data.FeedStatus) error
+	PollSettings(context.Context, string) (data.FeedSettings, error)
+	RenameFeed(ctx context.Context, from, to string) error
+	HubSubscription(context.Context, string) (data.HubSubscription, error)
+	HubSubscriptionByID(context.Context, string) (data.HubSubscription, error)
+	SetHubSubscription(context.Context, data.HubSubscription) error
+	RemoveHubSubscription(context.Context, string) error
 }
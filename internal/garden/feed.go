@@ -1,15 +1,24 @@
 package garden
 
 import (
+	"bytes"
+	"cmp"
 	"context"
 	"fmt"
+	"html"
+	"io"
 	"log/slog"
 	"net/http"
 	"net/url"
+	"sync"
 	"time"
 
 	"golang.org/x/net/html/charset"
 	"hawx.me/code/arboretum/internal/data"
+	"hawx.me/code/arboretum/internal/jsonfeed"
+	"hawx.me/code/arboretum/internal/metrics"
+	"hawx.me/code/arboretum/internal/redirect"
+	"hawx.me/code/arboretum/internal/sanitize"
 	"hawx.me/code/riviera/feed"
 	"hawx.me/code/riviera/feed/common"
 	"hawx.me/code/riviera/river/mapping"
@@ -18,17 +27,51 @@ import (
 const userAgent = "arboretum golang"
 
 type Feed struct {
-	uri     *url.URL
-	client  *http.Client
-	db      DB
-	refresh time.Duration
+	// mu is held while the feed is fetched or receives pushed content, so
+	// that the two never update it at once
+	mu sync.Mutex
 
-	ctx        context.Context
-	lastUpdate time.Time
-	lastETag   string
+	uri    *url.URL
+	client *http.Client
+	db     DB
+	opts   options
+	moved  chan<- move
+
+	ctx          context.Context
+	cancel       context.CancelFunc
+	lastUpdate   time.Time
+	nextPoll     time.Time
+	etag         string
+	lastModified string
+	status       data.FeedStatus
+
+	// advice is the refresh interval suggested by the last response, or 0 if
+	// none was given
+	advice time.Duration
+	// found is the hub advertised by the last fetched document, or nil if the
+	// last fetch did not get a document
+	found *hubLink
+
+	// index is the position of the feed in the scheduler's queue, or -1 when
+	// it is not queued
+	index int
+	// host is the host the feed was last dispatched to
+	host string
+	// code is the status of the last response, see data.FeedReport
+	code int
+
+	// reportMu guards reported, which is copied from the fields above after
+	// each poll so that it can be read while the feed is being fetched
+	reportMu sync.Mutex
+	reported data.FeedReport
+
+	// settingsMu guards settings, which are changed by Garden.Run while the
+	// feed may be being fetched
+	settingsMu sync.Mutex
+	settings   data.FeedSettings
 }
 
-func NewFeed(ctx context.Context, db DB, refresh time.Duration, uri string) (*Feed, error) {
+func newFeed(ctx context.Context, cancel context.CancelFunc, db DB, opts options, moved chan<- move, uri string) (*Feed, error) {
 	parsedURI, err := url.Parse(uri)
 	if err != nil {
 		return nil, err
@@ -39,75 +82,296 @@ func NewFeed(ctx context.Context, db DB, refresh time.Duration, uri string) (*Fe
 		return nil, err
 	}
 
-	return &Feed{
-		uri:        parsedURI,
-		client:     http.DefaultClient,
-		db:         db,
-		refresh:    refresh,
-		ctx:        ctx,
-		lastUpdate: lastUpdate,
-	}, nil
+	settings, err := db.PollSettings(ctx, uri)
+	if err != nil {
+		return nil, err
+	}
+
+	nextPoll, err := db.NextPoll(ctx, uri)
+	if err != nil {
+		return nil, err
+	}
+	if nextPoll.IsZero() {
+		nextPoll = lastUpdate.Add(cmp.Or(settings.Refresh, opts.refresh))
+	}
+
+	etag, lastModified, err := db.Validators(ctx, uri)
+	if err != nil {
+		return nil, err
+	}
+
+	status, err := db.FeedStatus(ctx, uri)
+	if err != nil {
+		return nil, err
+	}
+
+	feed := &Feed{
+		uri:          parsedURI,
+		client:       http.DefaultClient,
+		db:           db,
+		opts:         opts,
+		moved:        moved,
+		ctx:          ctx,
+		cancel:       cancel,
+		lastUpdate:   lastUpdate,
+		nextPoll:     nextPoll,
+		etag:         etag,
+		lastModified: lastModified,
+		status:       status,
+		index:        -1,
+		settings:     settings,
+	}
+	feed.updateReport()
+
+	return feed, nil
 }
 
-func (f *Feed) Run() {
-	for {
-		dur := max(0, f.refresh-time.Now().Sub(f.lastUpdate))
-		slog.Info("waiting", slog.Any("uri", f.uri), slog.Any("dur", dur))
+// poll fetches the feed, then works out when it should next be polled.
+func (f *Feed) poll() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
 
-		select {
-		case <-time.After(dur):
-			f.fetch()
-			f.lastUpdate = time.Now()
+	f.fetch()
+	f.lastUpdate = time.Now()
+	f.nextPoll = f.lastUpdate.Add(f.opts.backoff(f.interval(), f.status.ErrorCount))
 
-		case <-f.ctx.Done():
-			return
+	if f.opts.callback != "" {
+		sub, err := f.updateHub(f.lastUpdate)
+		if err != nil {
+			slog.Error("update hub subscription", slog.Any("uri", f.uri), slog.Any("err", err))
 		}
+		f.nextPoll = f.opts.pushedNextPoll(sub, f.lastUpdate, f.nextPoll)
+	}
+
+	if err := f.db.SetNextPoll(f.ctx, f.uri.String(), f.nextPoll); err != nil {
+		slog.Error("set next poll", slog.Any("uri", f.uri), slog.Any("err", err))
 	}
+
+	f.updateReport()
+}
+
+// interval returns the time to wait before polling again. A refresh chosen by a
+// subscriber is used over any advice from the last fetch.
+func (f *Feed) interval() time.Duration {
+	if refresh := f.currentSettings().Refresh; refresh > 0 {
+		return refresh
+	}
+
+	return f.opts.interval(f.advice)
+}
+
+func (f *Feed) currentSettings() data.FeedSettings {
+	f.settingsMu.Lock()
+	defer f.settingsMu.Unlock()
+
+	return f.settings
+}
+
+func (f *Feed) setSettings(settings data.FeedSettings) {
+	f.settingsMu.Lock()
+	f.settings = settings
+	f.settingsMu.Unlock()
+}
+
+// updateReport copies how the feed is doing into reported. It must be called
+// while holding mu, or before the feed is shared.
+func (f *Feed) updateReport() {
+	report := data.FeedReport{
+		URL:        f.uri.String(),
+		LastFetch:  f.lastUpdate,
+		StatusCode: f.code,
+		NextPoll:   f.nextPoll,
+	}
+	if f.status.ErrorCount > 0 {
+		report.Error = f.status.LastError
+	}
+
+	f.reportMu.Lock()
+	f.reported = report
+	f.reportMu.Unlock()
+}
+
+// report returns how the feed was doing after it was last polled.
+func (f *Feed) report() data.FeedReport {
+	f.reportMu.Lock()
+	defer f.reportMu.Unlock()
+
+	return f.reported
 }
 
 func (f *Feed) fetch() {
 	status, err := f.doFetch()
 	slog.Info("fetched", slog.Any("uri", f.uri), slog.Int("status", status), slog.Any("err", err))
+	metrics.Fetches.WithLabelValues(metrics.FetchCode(status)).Inc()
+
+	f.code = status
+	f.recordStatus(status, err)
+}
+
+// recordStatus updates the failure tracking for the feed with the result of a
+// fetch.
+func (f *Feed) recordStatus(code int, err error) {
+	now := time.Now()
+
+	if err == nil && (code == http.StatusOK || code == http.StatusNotModified) {
+		f.status = data.FeedStatus{LastSuccessAt: now}
+	} else {
+		if err == nil {
+			err = fmt.Errorf("unexpected status %d %s", code, http.StatusText(code))
+		}
+
+		if f.status.ErrorCount == 0 {
+			f.status.FailingSince = now
+		}
+		f.status.ErrorCount++
+		f.status.LastError = err.Error()
+		f.status.Dead = f.opts.deadAfter > 0 && now.Sub(f.status.FailingSince) >= f.opts.deadAfter
+		f.status.Gone = code == http.StatusGone
+
+		if f.status.Dead {
+			slog.Warn("dead", slog.Any("uri", f.uri), slog.Time("failingSince", f.status.FailingSince))
+		}
+	}
+
+	if err := f.db.SetFeedStatus(f.ctx, f.uri.String(), f.status); err != nil {
+		slog.Error("set feed status", slog.Any("uri", f.uri), slog.Any("err", err))
+	}
 }
 
 func (f *Feed) doFetch() (int, error) {
-	req, err := http.NewRequest("GET", f.uri.String(), nil)
+	start := time.Now()
+	defer func() {
+		metrics.FetchDuration.Observe(time.Since(start).Seconds())
+	}()
+
+	req, err := http.NewRequestWithContext(f.ctx, "GET", f.uri.String(), nil)
 	if err != nil {
 		return -1, fmt.Errorf("creating request for %v: %w", f.uri, err)
 	}
 
 	req.Header.Set("User-Agent", userAgent)
-	if !f.lastUpdate.IsZero() {
-		req.Header.Set("If-Modified-Since", f.lastUpdate.Format(time.RFC1123))
+	if f.lastModified != "" {
+		req.Header.Set("If-Modified-Since", f.lastModified)
 	}
-	if f.lastETag != "" {
-		req.Header.Set("If-None-Match", f.lastETag)
+	if f.etag != "" {
+		req.Header.Set("If-None-Match", f.etag)
 	}
+	if f.etag != "" || f.lastModified != "" {
+		metrics.ConditionalFetches.Inc()
+	}
+
+	f.advice = 0
+	f.found = nil
 
-	resp, err := f.client.Do(req)
+	client, redirects := redirect.Track(f.client)
+
+	resp, err := client.Do(req)
 	if err != nil {
 		return -1, fmt.Errorf("making request for %v: %w", f.uri, err)
 	}
 	defer resp.Body.Close()
 
+	f.advice = headerAdvice(resp.Header, time.Now())
+
+	if movedTo := redirects.MovedTo(); movedTo != nil && (resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusNotModified) {
+		if err := f.move(movedTo); err != nil {
+			return resp.StatusCode, err
+		}
+	}
+
 	if resp.StatusCode != http.StatusOK {
 		return resp.StatusCode, f.db.SetUpdatedAt(f.ctx, f.uri.String(), time.Now())
 	}
 
-	f.lastETag = resp.Header.Get("ETag")
+	body, err := io.ReadAll(resp.Body)
+	metrics.FetchBytes.Add(float64(len(body)))
+	if err != nil {
+		return resp.StatusCode, fmt.Errorf("reading %v: %w", f.uri, err)
+	}
+
+	found, err := f.handleBody(resp.Header.Get("Content-Type"), body)
+	if err != nil {
+		return resp.StatusCode, err
+	}
+	found = headerHub(resp.Header).or(found)
+	if found.topic == "" {
+		found.topic = f.uri.String()
+	}
+	f.found = &found
+
+	// only remember the validators once the content they describe is stored,
+	// otherwise a failed update would never be retried
+	f.etag = resp.Header.Get("ETag")
+	f.lastModified = resp.Header.Get("Last-Modified")
+	if err := f.db.SetValidators(f.ctx, f.uri.String(), f.etag, f.lastModified); err != nil {
+		return resp.StatusCode, fmt.Errorf("storing validators for %v: %w", f.uri, err)
+	}
+
+	return resp.StatusCode, nil
+}
+
+// receive stores content that a hub has pushed for the feed.
+func (f *Feed) receive(contentType string, body []byte) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, err := f.handleBody(contentType, body); err != nil {
+		slog.Error("handle pushed content", slog.Any("uri", f.uri), slog.Any("err", err))
+		return
+	}
+
+	slog.Info("pushed", slog.Any("uri", f.uri))
+}
 
-	channels, err := feed.Parse(resp.Body, f.uri, charset.NewReaderLabel)
+// handleBody stores the items in a feed document, returning the hub that it
+// advertises, if any.
+func (f *Feed) handleBody(contentType string, body []byte) (hubLink, error) {
+	if jsonfeed.Is(contentType, body) {
+		doc, err := jsonfeed.Parse(body)
+		if err != nil {
+			return hubLink{}, err
+		}
 
-	if err == nil && len(channels) > 0 {
-		for _, channel := range channels {
-			f.handleItems(channel, channel.Items)
+		return f.jsonFeedHub(doc), f.handleJSONFeed(doc)
+	}
+
+	channels, err := feed.Parse(bytes.NewReader(body), f.uri, charset.NewReaderLabel)
+	if err != nil {
+		return hubLink{}, err
+	}
+
+	var found hubLink
+	for _, channel := range channels {
+		f.advice = max(f.advice, channelAdvice(channel))
+		found = found.or(f.channelHub(channel))
+
+		if err := f.handleItems(channel, channel.Items); err != nil {
+			return found, err
 		}
 	}
 
-	return resp.StatusCode, err
+	return found, nil
 }
 
-func (f *Feed) handleItems(ch *common.Channel, newitems []*common.Item) {
+// move changes the subscription to use the new URL for the feed.
+func (f *Feed) move(to *url.URL) error {
+	from := f.uri.String()
+
+	slog.Info("moved permanently", slog.String("from", from), slog.Any("to", to))
+	if err := f.db.RenameFeed(f.ctx, from, to.String()); err != nil {
+		return fmt.Errorf("moving %v to %v: %w", from, to, err)
+	}
+	f.uri = to
+
+	select {
+	case f.moved <- move{from: from, to: to.String()}:
+	case <-f.ctx.Done():
+	}
+
+	return nil
+}
+
+func (f *Feed) handleItems(ch *common.Channel, newitems []*common.Item) error {
 	items := make([]data.FeedItem, len(newitems))
 
 	for i, item := range newitems {
@@ -121,17 +385,46 @@ func (f *Feed) handleItems(ch *common.Channel, newitems []*common.Item) {
 				converted.Title = "a post"
 			}
 
+			description := item.Description
+			content := ""
+			if item.Content != nil {
+				content = item.Content.Text
+			}
+
+			author := item.Author.Name
+			if author == "" {
+				author = ch.Author.Name
+			}
+
+			base := converted.PermaLink
+			if base == "" {
+				base = f.uri.String()
+			}
+
 			items[i] = data.FeedItem{
 				Key:       item.Key(),
 				PermaLink: converted.PermaLink,
 				PubDate:   converted.PubDate.Add(0),
 				Title:     converted.Title,
 				Link:      converted.Link,
+				Summary:   summarise(description, content),
+				Content:   sanitize.HTML(firstNonEmpty(content, description), base),
+				Author:    author,
+			}
+
+			for _, enclosure := range item.Enclosures {
+				if enclosure.Url != "" {
+					items[i].Enclosure = data.Enclosure{
+						URL:    maybeResolvedLink(f.uri, enclosure.Url),
+						Type:   enclosure.Type,
+						Length: enclosure.Length,
+					}
+					break
+				}
 			}
 		}
 	}
 
-	feedURL := f.uri.String()
 	websiteURL := ""
 	for _, link := range ch.Links {
 		if link.Rel != "self" {
@@ -140,16 +433,108 @@ func (f *Feed) handleItems(ch *common.Channel, newitems []*common.Item) {
 		}
 	}
 
+	return f.update(ch.Title, websiteURL, items)
+}
+
+func (f *Feed) handleJSONFeed(doc *jsonfeed.Feed) error {
+	items := make([]data.FeedItem, len(doc.Items))
+
+	for i, item := range doc.Items {
+		permaLink := maybeResolvedLink(f.uri, item.URL)
+		link := permaLink
+		if item.ExternalURL != "" {
+			link = maybeResolvedLink(f.uri, item.ExternalURL)
+		}
+
+		key := item.ID
+		if key == "" {
+			key = permaLink
+		}
+
+		title := item.Title
+		if title == "" {
+			title = "a post"
+		}
+
+		content := item.ContentHTML
+		if content == "" && item.ContentText != "" {
+			content = "<p>" + html.EscapeString(item.ContentText) + "</p>"
+		}
+
+		author := item.AuthorName()
+		if author == "" {
+			author = doc.AuthorName()
+		}
+
+		base := permaLink
+		if base == "" {
+			base = f.uri.String()
+		}
+
+		items[i] = data.FeedItem{
+			Key:       key,
+			PermaLink: permaLink,
+			PubDate:   item.PubDate(),
+			Title:     title,
+			Link:      link,
+			Summary:   summarise(html.EscapeString(item.Summary), content),
+			Content:   sanitize.HTML(content, base),
+			Author:    author,
+		}
+
+		for _, attachment := range item.Attachments {
+			if attachment.URL != "" {
+				items[i].Enclosure = data.Enclosure{
+					URL:    maybeResolvedLink(f.uri, attachment.URL),
+					Type:   attachment.MimeType,
+					Length: attachment.SizeInBytes,
+				}
+				break
+			}
+		}
+	}
+
+	websiteURL := ""
+	if doc.HomePageURL != "" {
+		websiteURL = maybeResolvedLink(f.uri, doc.HomePageURL)
+	}
+
+	return f.update(doc.Title, websiteURL, items)
+}
+
+// summaryLength is the most characters kept for the summary of an item.
+const summaryLength = 280
+
+// summarise gives a short plain text version of the description of an item,
+// falling back to its content. Both are HTML.
+func summarise(description, content string) string {
+	return sanitize.Text(firstNonEmpty(description, content), summaryLength)
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+func (f *Feed) update(title, websiteURL string, items []data.FeedItem) error {
+	feedURL := f.uri.String()
+
 	slog.Info("updating", slog.String("uri", feedURL), slog.Int("items", len(items)))
 	if err := f.db.UpdateFeed(f.ctx, data.Feed{
 		URL:        feedURL,
 		WebsiteURL: websiteURL,
-		Title:      ch.Title,
+		Title:      title,
 		UpdatedAt:  time.Now(),
 		Items:      items,
 	}); err != nil {
-		slog.Error("update feed", slog.Any("err", err))
+		return fmt.Errorf("updating feed %v: %w", f.uri, err)
 	}
+
+	return nil
 }
 
 func maybeResolvedLink(root *url.URL, other string) string {
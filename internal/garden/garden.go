@@ -2,58 +2,117 @@
 package garden
 
 import (
+	"cmp"
 	"context"
 	"log/slog"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"hawx.me/code/arboretum/internal/data"
 	"hawx.me/code/arboretum/internal/gardenjs"
+	"hawx.me/code/arboretum/internal/metrics"
 )
 
 type Garden struct {
-	db      DB
-	refresh time.Duration
+	db   DB
+	opts options
 
-	added   chan string
-	removed chan string
-	feeds   map[string]context.CancelFunc
+	added     chan string
+	removed   chan string
+	moved     chan move
+	pushed    chan push
+	reports   chan chan []data.FeedReport
+	refreshes chan manualRefresh
+	settings  chan feedSettings
+	feeds     map[string]*Feed
+
+	// running is set while Run is polling, and loaded once every stored
+	// subscription has been added
+	running atomic.Bool
+	loaded  atomic.Bool
+}
+
+// move records that a feed has permanently moved to a new URL.
+type move struct {
+	from, to string
+}
+
+// feedSettings changes how a subscribed feed is polled.
+type feedSettings struct {
+	uri      string
+	settings data.FeedSettings
 }
 
-func New(store DB, refresh time.Duration) *Garden {
+func New(store DB, refresh time.Duration, opts ...Option) *Garden {
+	o := defaultOptions(refresh)
+	for _, opt := range opts {
+		opt(&o)
+	}
+
 	return &Garden{
-		db:      store,
-		refresh: refresh,
-		feeds:   map[string]context.CancelFunc{},
-		added:   make(chan string),
-		removed: make(chan string),
+		db:        store,
+		opts:      o,
+		feeds:     map[string]*Feed{},
+		added:     make(chan string),
+		removed:   make(chan string),
+		moved:     make(chan move),
+		pushed:    make(chan push),
+		reports:   make(chan chan []data.FeedReport),
+		refreshes: make(chan manualRefresh),
+		settings:  make(chan feedSettings),
 	}
 }
 
-func (g *Garden) Latest(ctx context.Context) (gardenjs.Garden, error) {
+// Latest returns the garden of user, with the items in each feed they
+// subscribe to.
+func (g *Garden) Latest(ctx context.Context, user int64) (gardenjs.Garden, error) {
 	garden := gardenjs.Garden{
 		Metadata: gardenjs.Metadata{
 			BuiltAt: time.Now(),
 		},
 	}
 
-	feeds, err := g.db.ReadAll(ctx)
+	feeds, err := g.db.ReadAll(ctx, user)
 	if err != nil {
 		return gardenjs.Garden{}, err
 	}
 
 	for _, feed := range feeds {
 		mapped := gardenjs.Feed{
-			URL:        feed.URL,
-			WebsiteURL: feed.WebsiteURL,
-			Title:      feed.Title,
+			URL:         feed.URL,
+			WebsiteURL:  feed.WebsiteURL,
+			Title:       cmp.Or(feed.Settings.Title, feed.Title, feed.URL),
+			Category:    feed.Category,
+			Error:       feed.Status.LastError,
+			Dead:        feed.Status.Dead,
+			Gone:        feed.Status.Gone,
+			Paused:      feed.Settings.Paused,
+			CustomTitle: feed.Settings.Title,
+			Refresh:     feed.Settings.Refresh,
 		}
 
 		for _, item := range feed.Items {
-			mapped.Items = append(mapped.Items, gardenjs.Item{
+			mappedItem := gardenjs.Item{
+				Key:       item.Key,
 				PermaLink: item.PermaLink,
 				PubDate:   item.PubDate,
 				Title:     item.Title,
 				Link:      item.Link,
-			})
+				Summary:   item.Summary,
+				Content:   item.Content,
+				Author:    item.Author,
+				Read:      item.Read,
+			}
+			if item.Enclosure.URL != "" {
+				mappedItem.Enclosure = &gardenjs.Enclosure{
+					URL:    item.Enclosure.URL,
+					Type:   item.Enclosure.Type,
+					Length: item.Enclosure.Length,
+				}
+			}
+
+			mapped.Items = append(mapped.Items, mappedItem)
 			if item.PubDate.After(mapped.UpdatedAt) {
 				mapped.UpdatedAt = item.PubDate
 			}
@@ -75,37 +134,199 @@ func (g *Garden) Unsubscribe(ctx context.Context, uri string) error {
 	return nil
 }
 
+// SettingsChanged rereads how the subscribed feed at uri should be polled, once
+// a subscriber's settings for it, or who subscribes to it, have changed in the
+// database. A change to the refresh takes effect from the next poll.
+func (g *Garden) SettingsChanged(ctx context.Context, uri string) error {
+	if !g.running.Load() {
+		return nil
+	}
+
+	settings, err := g.db.PollSettings(ctx, uri)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case g.settings <- feedSettings{uri: uri, settings: settings}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// A Fetch is the outcome of fetching a feed with FetchOnce.
+type Fetch struct {
+	// URL is where the feed now is, it differs from the URL fetched when the
+	// feed has permanently moved.
+	URL string
+	// StatusCode is the status of the response, or -1 if none was received.
+	StatusCode int
+	Status     data.FeedStatus
+	NextPoll   time.Time
+}
+
+// FetchOnce fetches the subscribed feed at uri straight away, without needing
+// a Garden to be running.
+func FetchOnce(ctx context.Context, store DB, refresh time.Duration, uri string, opts ...Option) (Fetch, error) {
+	o := defaultOptions(refresh)
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// buffered as nothing is waiting to hear that the feed moved
+	moved := make(chan move, 1)
+
+	feed, err := newFeed(ctx, cancel, store, o, moved, uri)
+	if err != nil {
+		return Fetch{}, err
+	}
+
+	feed.poll()
+
+	return feed.fetched(), nil
+}
+
+// Run polls the subscribed feeds until ctx is cancelled. It returns once any
+// fetches, and pushed content, in progress have finished.
 func (g *Garden) Run(ctx context.Context) {
+	g.running.Store(true)
+	defer g.running.Store(false)
+
+	s := newScheduler(g.opts)
+	defer s.wait()
+
+	var receiving sync.WaitGroup
+	defer receiving.Wait()
+
+	waiting := refreshWaiters{}
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
 	for {
+		s.dispatch(ctx, time.Now())
+		metrics.SchedulerLag.Set(s.lag(time.Now()).Seconds())
+
+		if wait, ok := s.next(time.Now()); ok {
+			timer.Reset(wait)
+		} else {
+			timer.Stop()
+		}
+
 		select {
+		case <-timer.C:
+
+		case feed := <-s.done:
+			s.finished(feed)
+			waiting.polled(feed)
+
+			if g.feeds[feed.uri.String()] != feed {
+				// removed while it was being fetched
+				continue
+			}
+
+			slog.Info("waiting", slog.Any("uri", feed.uri), slog.Any("dur", time.Until(feed.nextPoll)))
+			s.push(feed)
+
 		case uri := <-g.added:
-			if _, ok := g.feeds[uri]; ok {
+			if feed, ok := g.feeds[uri]; ok {
+				// a new subscriber may resume a feed everyone else paused
+				settings, err := g.db.PollSettings(ctx, uri)
+				if err != nil {
+					slog.Error("adding", slog.String("uri", uri), slog.Any("err", err))
+					continue
+				}
+
 				slog.Info("already added", slog.String("uri", uri))
+				s.changeSettings(feed, settings, time.Now())
 				continue
 			}
 
 			childCtx, cancel := context.WithCancel(ctx)
-			g.feeds[uri] = cancel
 
-			feed, err := NewFeed(childCtx, g.db, g.refresh, uri)
+			feed, err := newFeed(childCtx, cancel, g.db, g.opts, g.moved, uri)
 			if err != nil {
+				cancel()
 				slog.Error("adding", slog.String("uri", uri), slog.Any("err", err))
 				continue
 			}
 
-			go func() {
-				feed.Run()
-			}()
+			g.feeds[uri] = feed
+			s.add(feed, time.Now())
 
 		case uri := <-g.removed:
-			cancel, ok := g.feeds[uri]
+			feed, ok := g.feeds[uri]
 			if !ok {
 				slog.Error("no such feed", slog.Any("uri", uri))
 				continue
 			}
 
-			cancel()
+			feed.cancel()
+			s.remove(feed)
 			delete(g.feeds, uri)
+			waiting.removed(feed)
+
+		case m := <-g.moved:
+			feed, ok := g.feeds[m.from]
+			if !ok {
+				continue
+			}
+			delete(g.feeds, m.from)
+
+			if _, ok := g.feeds[m.to]; ok {
+				slog.Info("moved to existing subscription", slog.String("from", m.from), slog.String("to", m.to))
+				feed.cancel()
+				continue
+			}
+
+			slog.Info("moved", slog.String("from", m.from), slog.String("to", m.to))
+			g.feeds[m.to] = feed
+
+		case p := <-g.pushed:
+			feed, ok := g.feeds[p.feedURL]
+			if !ok {
+				slog.Warn("pushed to unknown feed", slog.String("uri", p.feedURL))
+				continue
+			}
+
+			receiving.Add(1)
+			go func() {
+				defer receiving.Done()
+				feed.receive(p.contentType, p.body)
+			}()
+
+		case reply := <-g.reports:
+			reports := make([]data.FeedReport, 0, len(g.feeds))
+			for _, feed := range g.feeds {
+				reports = append(reports, feed.report())
+			}
+			reply <- reports
+
+		case r := <-g.refreshes:
+			var feeds []*Feed
+			for _, uri := range r.uris {
+				if feed, ok := g.feeds[uri]; ok {
+					feeds = append(feeds, feed)
+				}
+			}
+
+			now := time.Now()
+			for _, feed := range feeds {
+				s.refresh(feed, now)
+			}
+			r.reply <- waiting.add(feeds)
+
+		case c := <-g.settings:
+			feed, ok := g.feeds[c.uri]
+			if !ok {
+				continue
+			}
+
+			s.changeSettings(feed, c.settings, time.Now())
 
 		case <-ctx.Done():
 			return
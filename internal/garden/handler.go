@@ -1,15 +1,19 @@
 package garden
 
 import (
+	"encoding/json"
 	"log/slog"
 	"net/http"
+	"regexp"
 
+	"hawx.me/code/arboretum/internal/gardenjs"
 	"hawx.me/code/arboretum/internal/page"
+	"hawx.me/code/arboretum/internal/users"
 )
 
 func (garden *Garden) Handler(signedIn bool) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		latest, err := garden.Latest(r.Context())
+		latest, err := garden.Latest(r.Context(), users.From(r.Context()).ID)
 		if err != nil {
 			slog.Error("get latest garden", slog.Any("err", err))
 			http.Error(w, "", http.StatusInternalServerError)
@@ -21,3 +25,67 @@ func (garden *Garden) Handler(signedIn bool) http.HandlerFunc {
 		}
 	}
 }
+
+var callbackRe = regexp.MustCompile(`^[a-zA-Z_$][a-zA-Z0-9_$.]*$`)
+
+// JSONHandler serves the garden in gardenjs format. If a callback parameter is
+// given the response is wrapped as JSONP. What has been read, the settings
+// chosen for each feed and whether fetching it is failing are only included when
+// signedIn.
+func (garden *Garden) JSONHandler(signedIn bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		callback := r.FormValue("callback")
+		if callback != "" && !callbackRe.MatchString(callback) {
+			http.Error(w, "invalid callback", http.StatusBadRequest)
+			return
+		}
+
+		latest, err := garden.Latest(r.Context(), users.From(r.Context()).ID)
+		if err != nil {
+			slog.Error("get latest garden", slog.Any("err", err))
+			http.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+
+		if !signedIn {
+			latest = public(latest)
+		}
+
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+
+		if callback == "" {
+			w.Header().Set("Content-Type", "application/json")
+			if err := json.NewEncoder(w).Encode(latest); err != nil {
+				slog.Error("encode garden", slog.Any("err", err))
+			}
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/javascript")
+		w.Write([]byte("/**/" + callback + "("))
+		if err := json.NewEncoder(w).Encode(latest); err != nil {
+			slog.Error("encode garden", slog.Any("err", err))
+			return
+		}
+		w.Write([]byte(");"))
+	}
+}
+
+// public removes from the garden what is only shown when signed in.
+func public(latest gardenjs.Garden) gardenjs.Garden {
+	for i := range latest.Feeds {
+		feed := &latest.Feeds[i]
+		feed.Error = ""
+		feed.Dead = false
+		feed.Gone = false
+		feed.Paused = false
+		feed.CustomTitle = ""
+		feed.Refresh = 0
+
+		for j := range feed.Items {
+			feed.Items[j].Read = false
+		}
+	}
+
+	return latest
+}
@@ -0,0 +1,121 @@
+package garden
+
+import (
+	"math/rand/v2"
+	"strings"
+	"time"
+)
+
+type options struct {
+	refresh    time.Duration
+	minRefresh time.Duration
+	maxRefresh time.Duration
+	maxBackoff time.Duration
+	deadAfter  time.Duration
+
+	concurrency     int
+	hostConcurrency int
+	jitter          time.Duration
+
+	// callback is the URL that hubs push updates to, without the subscription
+	// ID, or empty when WebSub is not used
+	callback      string
+	pushedRefresh time.Duration
+}
+
+func defaultOptions(refresh time.Duration) options {
+	return options{
+		refresh:         refresh,
+		maxBackoff:      48 * time.Hour,
+		concurrency:     8,
+		hostConcurrency: 2,
+	}
+}
+
+// An Option configures a Garden.
+type Option func(*options)
+
+// WithRefreshBounds limits the refresh interval that a feed can advise. A zero
+// value for max means there is no upper limit.
+func WithRefreshBounds(min, max time.Duration) Option {
+	return func(o *options) {
+		o.minRefresh = min
+		o.maxRefresh = max
+	}
+}
+
+// WithBackoff sets the longest time to wait between fetches of a failing feed,
+// and how long a feed can fail for before it is marked as dead. A zero value
+// for deadAfter means feeds are never marked as dead.
+func WithBackoff(maxBackoff, deadAfter time.Duration) Option {
+	return func(o *options) {
+		o.maxBackoff = maxBackoff
+		o.deadAfter = deadAfter
+	}
+}
+
+// WithConcurrency limits the number of feeds fetched at once, overall and from
+// any single host. A zero value means there is no limit.
+func WithConcurrency(total, perHost int) Option {
+	return func(o *options) {
+		o.concurrency = total
+		o.hostConcurrency = perHost
+	}
+}
+
+// WithJitter spreads the first poll of feeds that are already due over the
+// given duration.
+func WithJitter(jitter time.Duration) Option {
+	return func(o *options) {
+		o.jitter = jitter
+	}
+}
+
+// WithWebSub subscribes to the hubs that feeds advertise, so that updates are
+// pushed to arboretum hosted at url. While a hub is pushing updates the feed is
+// only polled every refresh, as a safety net.
+func WithWebSub(url string, refresh time.Duration) Option {
+	return func(o *options) {
+		o.callback = strings.TrimSuffix(url, "/") + "/websub/"
+		o.pushedRefresh = refresh
+	}
+}
+
+func (o options) jitterDuration() time.Duration {
+	if o.jitter <= 0 {
+		return 0
+	}
+
+	return rand.N(o.jitter)
+}
+
+// interval returns the time to wait before polling again, given the advice
+// from the last fetch. Advice is kept within the configured bounds, so a
+// publisher cannot ask to be polled every second; without any the configured
+// refresh is used.
+func (o options) interval(advice time.Duration) time.Duration {
+	if advice <= 0 {
+		return o.refresh
+	}
+
+	advice = max(advice, o.minRefresh)
+	if o.maxRefresh > 0 {
+		advice = min(advice, o.maxRefresh)
+	}
+
+	return advice
+}
+
+// backoff doubles the interval for each consecutive failure, up to the
+// configured maximum.
+func (o options) backoff(interval time.Duration, failures int) time.Duration {
+	if failures == 0 || interval >= o.maxBackoff {
+		return interval
+	}
+
+	for i := 0; i < failures && interval < o.maxBackoff; i++ {
+		interval *= 2
+	}
+
+	return min(interval, o.maxBackoff)
+}
@@ -0,0 +1,248 @@
+package garden
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+	"time"
+
+	"hawx.me/code/arboretum/internal/users"
+)
+
+// ErrNotSubscribed is returned when asked to refresh a feed that is not
+// subscribed to.
+var ErrNotSubscribed = errors.New("not subscribed")
+
+// manualRefresh asks Run to poll some feeds straight away.
+type manualRefresh struct {
+	// uris are the feeds to poll, any not being polled are skipped
+	uris []string
+	// reply is sent where the results will be
+	reply chan *refreshing
+}
+
+// refreshing collects the results of a refresh.
+type refreshing struct {
+	// fetched has room for every feed being refreshed, it is closed once they
+	// have all been polled or removed
+	fetched chan Fetch
+	left    int
+}
+
+// refreshWaiters holds the refreshes waiting on each feed to be polled. It is
+// owned by Garden.Run.
+type refreshWaiters map[*Feed][]*refreshing
+
+// add starts waiting for each of feeds to be polled.
+func (w refreshWaiters) add(feeds []*Feed) *refreshing {
+	r := &refreshing{fetched: make(chan Fetch, len(feeds)), left: len(feeds)}
+	if r.left == 0 {
+		close(r.fetched)
+	}
+
+	for _, feed := range feeds {
+		w[feed] = append(w[feed], r)
+	}
+
+	return r
+}
+
+// polled passes the result of polling feed to anything waiting on it.
+func (w refreshWaiters) polled(feed *Feed) {
+	for _, r := range w[feed] {
+		r.fetched <- feed.fetched()
+		r.done()
+	}
+	delete(w, feed)
+}
+
+// removed stops waiting on a feed that will not be polled.
+func (w refreshWaiters) removed(feed *Feed) {
+	for _, r := range w[feed] {
+		r.done()
+	}
+	delete(w, feed)
+}
+
+func (r *refreshing) done() {
+	r.left--
+	if r.left == 0 {
+		close(r.fetched)
+	}
+}
+
+// fetched returns the result of the last poll. It must only be called while
+// the feed is not being polled.
+func (f *Feed) fetched() Fetch {
+	return Fetch{
+		URL:        f.uri.String(),
+		StatusCode: f.code,
+		Status:     f.status,
+		NextPoll:   f.nextPoll,
+	}
+}
+
+// Refresh polls the feed at uri, which user subscribes to, straight away,
+// waiting for the result. If the feed is already being polled the result of
+// that poll is returned.
+func (g *Garden) Refresh(ctx context.Context, user int64, uri string) (Fetch, error) {
+	fetches, err := g.refresh(ctx, user, uri)
+	if err != nil {
+		return Fetch{}, err
+	}
+	if len(fetches) == 0 {
+		return Fetch{}, ErrNotSubscribed
+	}
+
+	return fetches[0], nil
+}
+
+// RefreshAll polls every feed user subscribes to straight away, waiting for
+// the results. The limits on concurrent fetches still apply, so this may take a
+// while.
+func (g *Garden) RefreshAll(ctx context.Context, user int64) ([]Fetch, error) {
+	return g.refresh(ctx, user, "")
+}
+
+// refresh polls the feed at uri, or every feed if it is empty, of those user
+// subscribes to.
+func (g *Garden) refresh(ctx context.Context, user int64, uri string) ([]Fetch, error) {
+	if !g.running.Load() {
+		return nil, ErrNotRunning
+	}
+
+	uris, err := g.subscribed(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	if uri != "" {
+		if !slices.Contains(uris, uri) {
+			return nil, ErrNotSubscribed
+		}
+		uris = []string{uri}
+	}
+
+	req := manualRefresh{uris: uris, reply: make(chan *refreshing, 1)}
+	select {
+	case g.refreshes <- req:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	r := <-req.reply
+
+	var fetches []Fetch
+	for {
+		select {
+		case fetch, ok := <-r.fetched:
+			if !ok {
+				return fetches, nil
+			}
+			fetches = append(fetches, fetch)
+		case <-ctx.Done():
+			return fetches, ctx.Err()
+		}
+	}
+}
+
+// subscribed returns the URLs of the feeds user subscribes to.
+func (g *Garden) subscribed(ctx context.Context, user int64) ([]string, error) {
+	subs, err := g.db.SubscriptionDetails(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+
+	uris := make([]string, len(subs))
+	for i, sub := range subs {
+		uris[i] = sub.URL
+	}
+
+	return uris, nil
+}
+
+// refreshRequest runs the refresh asked for by the url form value, or of every
+// feed the user of the request subscribes to if it is not given. If it fails an error response is written and ok is
+// false.
+func (g *Garden) refreshRequest(w http.ResponseWriter, r *http.Request) (fetches []Fetch, ok bool) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "", http.StatusMethodNotAllowed)
+		return nil, false
+	}
+
+	user := users.From(r.Context()).ID
+
+	var err error
+	if uri := r.FormValue("url"); uri != "" {
+		var fetch Fetch
+		if fetch, err = g.Refresh(r.Context(), user, uri); err == nil {
+			fetches = []Fetch{fetch}
+		}
+	} else {
+		fetches, err = g.RefreshAll(r.Context(), user)
+	}
+
+	switch {
+	case errors.Is(err, ErrNotSubscribed):
+		http.Error(w, "not subscribed to "+r.FormValue("url"), http.StatusNotFound)
+		return nil, false
+	case err != nil:
+		slog.Error("refresh", slog.String("uri", r.FormValue("url")), slog.Any("err", err))
+		http.Error(w, "", http.StatusServiceUnavailable)
+		return nil, false
+	}
+
+	return fetches, true
+}
+
+// RefreshHandler polls the feed given in the url form value, or every feed the
+// user subscribes to if it is not given, then redirects to the status page to show how it went.
+func (g *Garden) RefreshHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := g.refreshRequest(w, r); !ok {
+			return
+		}
+
+		http.Redirect(w, r, "/status", http.StatusSeeOther)
+	}
+}
+
+type jsonFetch struct {
+	URL        string    `json:"url"`
+	StatusCode int       `json:"statusCode"`
+	Error      string    `json:"error,omitempty"`
+	NextPoll   time.Time `json:"nextPoll"`
+}
+
+// RefreshJSONHandler polls feeds in the same way as RefreshHandler, responding
+// with the result of each fetch.
+func (g *Garden) RefreshJSONHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		fetches, ok := g.refreshRequest(w, r)
+		if !ok {
+			return
+		}
+
+		resp := []jsonFetch{}
+		for _, fetch := range fetches {
+			result := jsonFetch{
+				URL:        fetch.URL,
+				StatusCode: fetch.StatusCode,
+				NextPoll:   fetch.NextPoll,
+			}
+			if fetch.Status.ErrorCount > 0 {
+				result.Error = fetch.Status.LastError
+			}
+
+			resp = append(resp, result)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			slog.Error("encode refresh", slog.Any("err", err))
+		}
+	}
+}
@@ -0,0 +1,222 @@
+package garden
+
+import (
+	"container/heap"
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"hawx.me/code/arboretum/internal/data"
+)
+
+// feedQueue is a priority queue of feeds ordered by when they are next due to
+// be polled.
+type feedQueue []*Feed
+
+func (q feedQueue) Len() int { return len(q) }
+
+func (q feedQueue) Less(i, j int) bool { return q[i].nextPoll.Before(q[j].nextPoll) }
+
+func (q feedQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *feedQueue) Push(x any) {
+	feed := x.(*Feed)
+	feed.index = len(*q)
+	*q = append(*q, feed)
+}
+
+func (q *feedQueue) Pop() any {
+	old := *q
+	n := len(old)
+	feed := old[n-1]
+	old[n-1] = nil
+	feed.index = -1
+	*q = old[:n-1]
+	return feed
+}
+
+// scheduler decides when each feed is polled, making sure that no more than
+// the configured number of fetches are in flight overall, or to any one host.
+// It is not safe for concurrent use, it is owned by Garden.Run.
+type scheduler struct {
+	opts options
+
+	queue   feedQueue
+	blocked map[string][]*Feed
+	hosts   map[string]int
+	running int
+	// busy holds the feeds being polled
+	busy map[*Feed]bool
+	done chan *Feed
+	// polling tracks the polls in progress, so they can be waited on
+	polling sync.WaitGroup
+}
+
+// wait blocks until every poll in progress has finished.
+func (s *scheduler) wait() {
+	s.polling.Wait()
+}
+
+func newScheduler(opts options) *scheduler {
+	return &scheduler{
+		opts:    opts,
+		blocked: map[string][]*Feed{},
+		hosts:   map[string]int{},
+		busy:    map[*Feed]bool{},
+		done:    make(chan *Feed),
+	}
+}
+
+// add queues the feed. Feeds that are already due have their first poll
+// spread out over the jitter period, so that they are not all fetched at once.
+func (s *scheduler) add(feed *Feed, now time.Time) {
+	if feed.nextPoll.Before(now) {
+		feed.nextPoll = now.Add(s.opts.jitterDuration())
+	}
+
+	s.push(feed)
+}
+
+func (s *scheduler) push(feed *Feed) {
+	if feed.status.Gone {
+		slog.Warn("gone, no longer polling", slog.Any("uri", feed.uri))
+		return
+	}
+	if feed.currentSettings().Paused {
+		slog.Info("paused", slog.Any("uri", feed.uri))
+		return
+	}
+
+	heap.Push(&s.queue, feed)
+}
+
+// remove stops the feed from being polled again.
+func (s *scheduler) remove(feed *Feed) {
+	if feed.index >= 0 {
+		heap.Remove(&s.queue, feed.index)
+		return
+	}
+
+	blocked := s.blocked[feed.host]
+	for i, other := range blocked {
+		if other == feed {
+			s.blocked[feed.host] = append(blocked[:i], blocked[i+1:]...)
+			return
+		}
+	}
+}
+
+// changeSettings applies settings to feed, taking it out of the queue when it is
+// paused and polling it now when it is resumed.
+func (s *scheduler) changeSettings(feed *Feed, settings data.FeedSettings, now time.Time) {
+	wasPaused := feed.currentSettings().Paused
+	feed.setSettings(settings)
+
+	switch {
+	case settings.Paused:
+		s.remove(feed)
+	case wasPaused:
+		s.refresh(feed, now)
+	}
+}
+
+// refresh makes the feed due to be polled now. Feeds that are no longer
+// polled, as they are gone, are queued again; feeds being polled are left to
+// finish.
+func (s *scheduler) refresh(feed *Feed, now time.Time) {
+	if s.busy[feed] {
+		return
+	}
+
+	feed.nextPoll = now
+	if feed.index >= 0 {
+		heap.Fix(&s.queue, feed.index)
+		return
+	}
+
+	for _, other := range s.blocked[feed.host] {
+		if other == feed {
+			return
+		}
+	}
+
+	heap.Push(&s.queue, feed)
+}
+
+// dispatch starts polling every feed that is due, as long as there is capacity
+// to do so.
+func (s *scheduler) dispatch(ctx context.Context, now time.Time) {
+	for len(s.queue) > 0 && !s.queue[0].nextPoll.After(now) {
+		if s.opts.concurrency > 0 && s.running >= s.opts.concurrency {
+			return
+		}
+
+		feed := heap.Pop(&s.queue).(*Feed)
+		feed.host = feed.uri.Host
+
+		if s.opts.hostConcurrency > 0 && s.hosts[feed.host] >= s.opts.hostConcurrency {
+			s.blocked[feed.host] = append(s.blocked[feed.host], feed)
+			continue
+		}
+
+		s.running++
+		s.hosts[feed.host]++
+		s.busy[feed] = true
+
+		s.polling.Add(1)
+		go func() {
+			defer s.polling.Done()
+			feed.poll()
+
+			select {
+			case s.done <- feed:
+			case <-ctx.Done():
+			}
+		}()
+	}
+}
+
+// finished records that the feed has been polled, freeing its slot for any
+// feeds that were waiting on the same host.
+func (s *scheduler) finished(feed *Feed) {
+	s.running--
+	delete(s.busy, feed)
+	s.hosts[feed.host]--
+	if s.hosts[feed.host] <= 0 {
+		delete(s.hosts, feed.host)
+	}
+
+	for _, blocked := range s.blocked[feed.host] {
+		heap.Push(&s.queue, blocked)
+	}
+	delete(s.blocked, feed.host)
+}
+
+// lag returns how long the next feed to poll has been due for, or 0 if it is
+// not yet due.
+func (s *scheduler) lag(now time.Time) time.Duration {
+	if len(s.queue) == 0 {
+		return 0
+	}
+
+	return max(0, now.Sub(s.queue[0].nextPoll))
+}
+
+// next returns how long until the scheduler has something to do. If it
+// returns false there is nothing queued that can be started until a running
+// fetch finishes.
+func (s *scheduler) next(now time.Time) (time.Duration, bool) {
+	if len(s.queue) == 0 {
+		return 0, false
+	}
+	if s.opts.concurrency > 0 && s.running >= s.opts.concurrency {
+		return 0, false
+	}
+
+	return max(0, s.queue[0].nextPoll.Sub(now)), true
+}
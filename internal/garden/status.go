@@ -0,0 +1,135 @@
+package garden
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"hawx.me/code/arboretum/internal/data"
+	"hawx.me/code/arboretum/internal/page"
+	"hawx.me/code/arboretum/internal/users"
+)
+
+// ErrNotRunning is returned when asking for the status of a Garden that is not
+// running.
+var ErrNotRunning = errors.New("garden is not running")
+
+// Loaded records that every stored subscription has been passed to Subscribe,
+// so that the Garden can report it is ready.
+func (g *Garden) Loaded() {
+	g.loaded.Store(true)
+}
+
+// Ready reports whether Run is polling every stored subscription.
+func (g *Garden) Ready() bool {
+	return g.running.Load() && g.loaded.Load()
+}
+
+// Status returns how polling each feed user subscribes to is going, ordered by
+// URL.
+func (g *Garden) Status(ctx context.Context, user int64) ([]data.FeedReport, error) {
+	if !g.running.Load() {
+		return nil, ErrNotRunning
+	}
+
+	subscribed, err := g.subscribed(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+
+	reply := make(chan []data.FeedReport, 1)
+	select {
+	case g.reports <- reply:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	reports := slices.DeleteFunc(<-reply, func(report data.FeedReport) bool {
+		return !slices.Contains(subscribed, report.URL)
+	})
+	slices.SortFunc(reports, func(a, b data.FeedReport) int {
+		return strings.Compare(a.URL, b.URL)
+	})
+
+	return reports, nil
+}
+
+// ReadyHandler responds with 200 when the Garden is ready, and 503 until then.
+func (g *Garden) ReadyHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !g.Ready() {
+			http.Error(w, "not ready", http.StatusServiceUnavailable)
+			return
+		}
+
+		io.WriteString(w, "ok\n")
+	}
+}
+
+// StatusHandler lists the feeds the user of the request subscribes to with how
+// polling them is going.
+func (g *Garden) StatusHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		reports, err := g.Status(r.Context(), users.From(r.Context()).ID)
+		if err != nil {
+			slog.Error("get status", slog.Any("err", err))
+			http.Error(w, "", http.StatusServiceUnavailable)
+			return
+		}
+
+		if _, err := page.Status(g.Ready(), reports).WriteTo(w); err != nil {
+			slog.Error("render status", slog.Any("err", err))
+		}
+	}
+}
+
+type jsonStatus struct {
+	Ready bool             `json:"ready"`
+	Feeds []jsonFeedReport `json:"feeds"`
+}
+
+type jsonFeedReport struct {
+	URL        string     `json:"url"`
+	LastFetch  *time.Time `json:"lastFetch,omitempty"`
+	StatusCode int        `json:"statusCode,omitempty"`
+	Error      string     `json:"error,omitempty"`
+	NextPoll   time.Time  `json:"nextPoll"`
+}
+
+// StatusJSONHandler serves the same as StatusHandler as JSON.
+func (g *Garden) StatusJSONHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		reports, err := g.Status(r.Context(), users.From(r.Context()).ID)
+		if err != nil {
+			slog.Error("get status", slog.Any("err", err))
+			http.Error(w, "", http.StatusServiceUnavailable)
+			return
+		}
+
+		resp := jsonStatus{Ready: g.Ready(), Feeds: []jsonFeedReport{}}
+		for _, report := range reports {
+			feed := jsonFeedReport{
+				URL:        report.URL,
+				StatusCode: report.StatusCode,
+				Error:      report.Error,
+				NextPoll:   report.NextPoll,
+			}
+			if !report.LastFetch.IsZero() {
+				feed.LastFetch = &report.LastFetch
+			}
+
+			resp.Feeds = append(resp.Feeds, feed)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			slog.Error("encode status", slog.Any("err", err))
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package garden
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"hawx.me/code/arboretum/internal/syndication"
+	"hawx.me/code/arboretum/internal/users"
+)
+
+const syndicationTitle = "arboretum"
+
+// AtomHandler serves every item in the garden of the user of the request as a
+// single Atom feed. The url is the address arboretum is hosted at.
+func (g *Garden) AtomHandler(url string) http.HandlerFunc {
+	return g.syndicationHandler("application/atom+xml", func(r *http.Request, updated time.Time, entries []syndication.Entry) any {
+		self := strings.TrimSuffix(url, "/") + r.URL.Path
+		return syndication.Atom(self, syndicationTitle, updated, entries)
+	})
+}
+
+// RSSHandler serves every item in the garden of the user of the request as a
+// single RSS feed. The url is the address arboretum is hosted at.
+func (g *Garden) RSSHandler(url string) http.HandlerFunc {
+	return g.syndicationHandler("application/rss+xml", func(r *http.Request, updated time.Time, entries []syndication.Entry) any {
+		return syndication.RSS(url, syndicationTitle, updated, entries)
+	})
+}
+
+func (g *Garden) syndicationHandler(contentType string, build func(*http.Request, time.Time, []syndication.Entry) any) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		entries, err := g.entries(r.Context(), users.From(r.Context()).ID)
+		if err != nil {
+			slog.Error("get garden entries", slog.Any("err", err))
+			http.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+
+		var updated time.Time
+		if len(entries) > 0 {
+			updated = entries[0].PubDate
+		}
+
+		var buf bytes.Buffer
+		buf.WriteString(xml.Header)
+		if err := xml.NewEncoder(&buf).Encode(build(r, updated, entries)); err != nil {
+			slog.Error("encode syndication feed", slog.Any("err", err))
+			http.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", contentType)
+		if len(entries) > 0 {
+			w.Header().Set("ETag", entityTag(entries[0]))
+		}
+		http.ServeContent(w, r, "", updated, bytes.NewReader(buf.Bytes()))
+	}
+}
+
+// entries lists every item in the garden of user, newest first.
+func (g *Garden) entries(ctx context.Context, user int64) ([]syndication.Entry, error) {
+	feeds, err := g.db.ReadAll(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []syndication.Entry
+	for _, feed := range feeds {
+		for _, item := range feed.Items {
+			id := item.PermaLink
+			if id == "" {
+				id = feed.URL + "#" + item.Key
+			}
+
+			entries = append(entries, syndication.Entry{
+				ID:        id,
+				Title:     item.Title,
+				Link:      item.Link,
+				PubDate:   item.PubDate,
+				Summary:   item.Summary,
+				Content:   item.Content,
+				Source:    feed.Title,
+				SourceURL: feed.WebsiteURL,
+				FeedURL:   feed.URL,
+			})
+		}
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].PubDate.After(entries[j].PubDate)
+	})
+
+	return entries, nil
+}
+
+// entityTag identifies the state of the garden by its newest entry.
+func entityTag(newest syndication.Entry) string {
+	sum := sha256.Sum256([]byte(newest.FeedURL + "\n" + newest.ID + "\n" + newest.PubDate.UTC().Format(time.RFC3339Nano)))
+
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
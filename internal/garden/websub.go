@@ -0,0 +1,392 @@
+package garden
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"hawx.me/code/arboretum/internal/data"
+	"hawx.me/code/arboretum/internal/jsonfeed"
+	"hawx.me/code/riviera/feed/common"
+)
+
+// hubRenewal is the fraction of a lease that is left when the subscription is
+// renewed, so a tenth of the lease.
+const hubRenewal = 10
+
+// maxPushSize is the largest body accepted from a hub.
+const maxPushSize = 10 << 20
+
+// hubLink is the WebSub hub a feed advertises, along with the topic URL to
+// subscribe to.
+type hubLink struct {
+	hub, topic string
+}
+
+// or fills in anything missing from l with other.
+func (l hubLink) or(other hubLink) hubLink {
+	if l.hub == "" {
+		l.hub = other.hub
+	}
+	if l.topic == "" {
+		l.topic = other.topic
+	}
+	return l
+}
+
+// headerHub finds the hub and topic given in Link headers.
+func headerHub(header http.Header) hubLink {
+	var found hubLink
+
+	for _, value := range header.Values("Link") {
+		for _, link := range strings.Split(value, ",") {
+			target, params, ok := strings.Cut(strings.TrimSpace(link), ";")
+			if !ok || !strings.HasPrefix(target, "<") || !strings.HasSuffix(target, ">") {
+				continue
+			}
+			target = strings.TrimSuffix(strings.TrimPrefix(target, "<"), ">")
+
+			for _, param := range strings.Split(params, ";") {
+				name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+				if strings.ToLower(name) != "rel" {
+					continue
+				}
+
+				for _, rel := range strings.Fields(strings.Trim(value, `"`)) {
+					switch strings.ToLower(rel) {
+					case "hub":
+						found = found.or(hubLink{hub: target})
+					case "self":
+						found = found.or(hubLink{topic: target})
+					}
+				}
+			}
+		}
+	}
+
+	return found
+}
+
+// channelHub finds the hub and topic linked to from an RSS or Atom channel.
+func (f *Feed) channelHub(ch *common.Channel) hubLink {
+	var found hubLink
+
+	for _, link := range ch.Links {
+		switch link.Rel {
+		case "hub":
+			found = found.or(hubLink{hub: maybeResolvedLink(f.uri, link.Href)})
+		case "self":
+			found = found.or(hubLink{topic: maybeResolvedLink(f.uri, link.Href)})
+		}
+	}
+
+	return found
+}
+
+// jsonFeedHub finds the hub and topic given in a JSON Feed.
+func (f *Feed) jsonFeedHub(doc *jsonfeed.Feed) hubLink {
+	var found hubLink
+
+	for _, hub := range doc.Hubs {
+		if strings.EqualFold(hub.Type, "websub") && hub.URL != "" {
+			found.hub = maybeResolvedLink(f.uri, hub.URL)
+			break
+		}
+	}
+	if doc.FeedURL != "" {
+		found.topic = maybeResolvedLink(f.uri, doc.FeedURL)
+	}
+
+	return found
+}
+
+// updateHub subscribes to, renews, or forgets the hub for the feed depending
+// on what the last fetch found. It returns the subscription as it now stands.
+func (f *Feed) updateHub(now time.Time) (data.HubSubscription, error) {
+	uri := f.uri.String()
+
+	sub, err := f.db.HubSubscription(f.ctx, uri)
+	if err != nil {
+		return data.HubSubscription{}, err
+	}
+
+	switch {
+	case f.found == nil:
+		// nothing was fetched, but the subscription may still need renewing
+		if sub.ID == "" || now.Before(sub.RenewAt) {
+			return sub, nil
+		}
+
+	case f.found.hub == "":
+		if sub.ID == "" {
+			return sub, nil
+		}
+
+		slog.Info("no longer advertises hub", slog.String("uri", uri), slog.String("hub", sub.Hub))
+		return data.HubSubscription{}, f.db.RemoveHubSubscription(f.ctx, uri)
+
+	case f.found.hub != sub.Hub || f.found.topic != sub.Topic:
+		id, err := randomToken()
+		if err != nil {
+			return data.HubSubscription{}, err
+		}
+		secret, err := randomToken()
+		if err != nil {
+			return data.HubSubscription{}, err
+		}
+
+		sub = data.HubSubscription{
+			FeedURL: uri,
+			ID:      id,
+			Hub:     f.found.hub,
+			Topic:   f.found.topic,
+			Secret:  secret,
+		}
+		// stored before asking, as the hub may verify before it responds
+		if err := f.db.SetHubSubscription(f.ctx, sub); err != nil {
+			return data.HubSubscription{}, err
+		}
+
+	case now.Before(sub.RenewAt):
+		return sub, nil
+	}
+
+	if err := f.requestSubscription(sub); err != nil {
+		return sub, err
+	}
+
+	// the hub may have verified the subscription before responding
+	return f.db.HubSubscription(f.ctx, uri)
+}
+
+// requestSubscription asks the hub to start, or renew, pushing updates for the
+// feed.
+func (f *Feed) requestSubscription(sub data.HubSubscription) error {
+	form := url.Values{
+		"hub.mode":     {"subscribe"},
+		"hub.topic":    {sub.Topic},
+		"hub.callback": {f.opts.callback + sub.ID},
+		"hub.secret":   {sub.Secret},
+	}
+
+	req, err := http.NewRequestWithContext(f.ctx, "POST", sub.Hub, strings.NewReader(form.Encode()))
+	if err != nil {
+		return fmt.Errorf("creating request for hub %v: %w", sub.Hub, err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("User-Agent", userAgent)
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("making request for hub %v: %w", sub.Hub, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("hub %v responded with %d %s", sub.Hub, resp.StatusCode, http.StatusText(resp.StatusCode))
+	}
+
+	slog.Info("requested hub subscription", slog.String("uri", sub.FeedURL), slog.String("hub", sub.Hub))
+	return nil
+}
+
+// pushedNextPoll returns when to next poll a feed given its subscription to a
+// hub. While the hub is pushing updates the feed is only polled as a safety
+// net, or to renew the subscription. Otherwise nextPoll is kept.
+func (o options) pushedNextPoll(sub data.HubSubscription, now, nextPoll time.Time) time.Time {
+	// a renewal that is due has been asked for, so keep polling as normal
+	// until the hub verifies it
+	if !sub.Active(now) || !sub.RenewAt.After(now) {
+		return nextPoll
+	}
+
+	next := now.Add(o.pushedRefresh)
+	if next.Before(nextPoll) {
+		next = nextPoll
+	}
+	if sub.RenewAt.Before(next) {
+		next = sub.RenewAt
+	}
+
+	return next
+}
+
+// push is content sent by a hub for a feed.
+type push struct {
+	feedURL     string
+	contentType string
+	body        []byte
+}
+
+// WebSubHandler is the callback given to hubs. It verifies the subscriptions
+// arboretum has asked for and passes on content they push.
+func (g *Garden) WebSubHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sub, err := g.db.HubSubscriptionByID(r.Context(), path.Base(r.URL.Path))
+		if err != nil {
+			slog.Error("get hub subscription", slog.Any("err", err))
+			http.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			g.verifyIntent(w, r, sub)
+		case http.MethodPost:
+			g.receivePush(w, r, sub)
+		default:
+			w.Header().Set("Allow", "GET, POST")
+			http.Error(w, "", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// verifyIntent answers the hub checking that the subscription, or
+// unsubscription, was wanted.
+func (g *Garden) verifyIntent(w http.ResponseWriter, r *http.Request, sub data.HubSubscription) {
+	var (
+		query     = r.URL.Query()
+		topic     = query.Get("hub.topic")
+		challenge = query.Get("hub.challenge")
+		wanted    = sub.ID != "" && topic == sub.Topic
+	)
+
+	switch query.Get("hub.mode") {
+	case "subscribe":
+		if !wanted || challenge == "" {
+			http.NotFound(w, r)
+			return
+		}
+
+		seconds, err := strconv.Atoi(query.Get("hub.lease_seconds"))
+		if err != nil || seconds <= 0 {
+			http.Error(w, "hub.lease_seconds must be given", http.StatusBadRequest)
+			return
+		}
+
+		lease := time.Duration(seconds) * time.Second
+		sub.ExpiresAt = time.Now().Add(lease)
+		sub.RenewAt = sub.ExpiresAt.Add(-lease / hubRenewal)
+
+		if err := g.db.SetHubSubscription(r.Context(), sub); err != nil {
+			slog.Error("set hub subscription", slog.String("uri", sub.FeedURL), slog.Any("err", err))
+			http.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+
+		slog.Info("hub subscription verified", slog.String("uri", sub.FeedURL), slog.String("hub", sub.Hub), slog.Duration("lease", lease))
+		io.WriteString(w, challenge)
+
+	case "unsubscribe":
+		// arboretum never asks to unsubscribe, so only agree to subscriptions
+		// it no longer knows about
+		if wanted || challenge == "" {
+			http.NotFound(w, r)
+			return
+		}
+
+		io.WriteString(w, challenge)
+
+	case "denied":
+		if wanted {
+			slog.Warn("hub subscription denied", slog.String("uri", sub.FeedURL), slog.String("hub", sub.Hub), slog.String("reason", query.Get("hub.reason")))
+
+			if err := g.db.RemoveHubSubscription(r.Context(), sub.FeedURL); err != nil {
+				slog.Error("remove hub subscription", slog.String("uri", sub.FeedURL), slog.Any("err", err))
+				http.Error(w, "", http.StatusInternalServerError)
+				return
+			}
+		}
+
+	default:
+		http.Error(w, "unknown hub.mode", http.StatusBadRequest)
+	}
+}
+
+// receivePush passes content sent by the hub on to the feed, as long as it
+// has been signed with the subscription's secret.
+func (g *Garden) receivePush(w http.ResponseWriter, r *http.Request, sub data.HubSubscription) {
+	if sub.ID == "" {
+		// tell the hub to stop sending
+		http.Error(w, "", http.StatusGone)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxPushSize))
+	if err != nil {
+		slog.Error("read pushed content", slog.String("uri", sub.FeedURL), slog.Any("err", err))
+		http.Error(w, "", http.StatusBadRequest)
+		return
+	}
+
+	if !validSignature(sub.Secret, r.Header.Get("X-Hub-Signature"), body) {
+		// still accepted, so that the hub can't tell whether the signature was
+		// right
+		slog.Warn("ignoring pushed content with bad signature", slog.String("uri", sub.FeedURL))
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	select {
+	case g.pushed <- push{feedURL: sub.FeedURL, contentType: r.Header.Get("Content-Type"), body: body}:
+	case <-r.Context().Done():
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// validSignature checks that signature, given as "method=hex", is the HMAC of
+// body using secret.
+func validSignature(secret, signature string, body []byte) bool {
+	method, sum, ok := strings.Cut(signature, "=")
+	if !ok {
+		return false
+	}
+
+	var newHash func() hash.Hash
+	switch method {
+	case "sha1":
+		newHash = sha1.New
+	case "sha256":
+		newHash = sha256.New
+	case "sha384":
+		newHash = sha512.New384
+	case "sha512":
+		newHash = sha512.New
+	default:
+		return false
+	}
+
+	expected, err := hex.DecodeString(sum)
+	if err != nil {
+		return false
+	}
+
+	mac := hmac.New(newHash, []byte(secret))
+	mac.Write(body)
+
+	return hmac.Equal(mac.Sum(nil), expected)
+}
+
+func randomToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
@@ -14,13 +14,46 @@ type Feed struct {
 	Title      string    `json:"title"`
 	UpdatedAt  time.Time `json:"updatedAt"`
 	Items      []Item    `json:"items"`
+
+	// Category is the path of folder names the feed is filed under.
+	Category []string `json:"category,omitempty"`
+	// Error is the reason the last fetch failed, if it did.
+	Error string `json:"error,omitempty"`
+	// Dead is set if the feed has been failing for a long time.
+	Dead bool `json:"dead,omitempty"`
+	// Gone is set if the feed has been removed by its publisher.
+	Gone bool `json:"gone,omitempty"`
+	// Paused is set if the feed is no longer being polled.
+	Paused bool `json:"paused,omitempty"`
+
+	// CustomTitle and Refresh are the settings chosen for the feed, they are
+	// only needed to edit them so are left out of the JSON.
+	CustomTitle string        `json:"-"`
+	Refresh     time.Duration `json:"-"`
 }
 
 type Item struct {
+	Key       string    `json:"key"`
 	PermaLink string    `json:"permaLink"`
 	PubDate   time.Time `json:"pubDate"`
 	Title     string    `json:"title"`
 	Link      string    `json:"link"`
+
+	// Summary is a short plain text description of the item.
+	Summary string `json:"summary,omitempty"`
+	// Content is the body of the item as sanitized HTML.
+	Content   string     `json:"content,omitempty"`
+	Author    string     `json:"author,omitempty"`
+	Enclosure *Enclosure `json:"enclosure,omitempty"`
+
+	// Read is set if the item has been marked as read.
+	Read bool `json:"read,omitempty"`
+}
+
+type Enclosure struct {
+	URL    string `json:"url"`
+	Type   string `json:"type"`
+	Length int64  `json:"length"`
 }
 
 type Metadata struct {
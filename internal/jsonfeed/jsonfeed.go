@@ -0,0 +1,154 @@
+// Package jsonfeed parses feeds in the JSON Feed format, see
+// https://www.jsonfeed.org/version/1.1/.
+package jsonfeed
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"mime"
+	"strings"
+	"time"
+)
+
+const versionPrefix = "https://jsonfeed.org/version/"
+
+type Feed struct {
+	Version     string   `json:"version"`
+	Title       string   `json:"title"`
+	HomePageURL string   `json:"home_page_url"`
+	FeedURL     string   `json:"feed_url"`
+	Description string   `json:"description"`
+	Authors     []Author `json:"authors"`
+	// Author is from version 1 and replaced by Authors in 1.1.
+	Author *Author `json:"author"`
+	Hubs   []Hub   `json:"hubs"`
+	Items  []Item  `json:"items"`
+}
+
+type Author struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+}
+
+type Hub struct {
+	Type string `json:"type"`
+	URL  string `json:"url"`
+}
+
+type Item struct {
+	ID            string       `json:"id"`
+	URL           string       `json:"url"`
+	ExternalURL   string       `json:"external_url"`
+	Title         string       `json:"title"`
+	ContentHTML   string       `json:"content_html"`
+	ContentText   string       `json:"content_text"`
+	Summary       string       `json:"summary"`
+	DatePublished string       `json:"date_published"`
+	DateModified  string       `json:"date_modified"`
+	Authors       []Author     `json:"authors"`
+	Author        *Author      `json:"author"`
+	Attachments   []Attachment `json:"attachments"`
+}
+
+type Attachment struct {
+	URL         string `json:"url"`
+	MimeType    string `json:"mime_type"`
+	SizeInBytes int64  `json:"size_in_bytes"`
+}
+
+// AuthorName gives the name of the first author of the item, if any.
+func (i Item) AuthorName() string {
+	return authorName(i.Authors, i.Author)
+}
+
+// AuthorName gives the name of the first author of the feed, if any.
+func (f Feed) AuthorName() string {
+	return authorName(f.Authors, f.Author)
+}
+
+func authorName(authors []Author, author *Author) string {
+	for _, a := range authors {
+		if a.Name != "" {
+			return a.Name
+		}
+	}
+	if author != nil {
+		return author.Name
+	}
+	return ""
+}
+
+// UnmarshalJSON allows ids to be given as numbers, which some publishers do
+// even though the spec requires a string.
+func (i *Item) UnmarshalJSON(b []byte) error {
+	type item Item
+	var raw struct {
+		item
+		ID json.RawMessage `json:"id"`
+	}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+
+	*i = Item(raw.item)
+	if len(raw.ID) > 0 && raw.ID[0] == '"' {
+		return json.Unmarshal(raw.ID, &i.ID)
+	}
+	i.ID = string(raw.ID)
+
+	return nil
+}
+
+// PubDate returns when the item was published, falling back to when it was
+// modified. It returns the zero time if neither are given or valid.
+func (i Item) PubDate() time.Time {
+	for _, value := range []string{i.DatePublished, i.DateModified} {
+		if t, err := time.Parse(time.RFC3339, value); err == nil {
+			return t
+		}
+	}
+
+	return time.Time{}
+}
+
+// Is reports whether a response looks like a JSON Feed, given its
+// Content-Type and body.
+func Is(contentType string, body []byte) bool {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if mediaType == "application/feed+json" {
+		return true
+	}
+
+	trimmed := bytes.TrimSpace(body)
+	if len(trimmed) == 0 || trimmed[0] != '{' {
+		return false
+	}
+
+	if mediaType != "application/json" && !bytes.Contains(body, []byte("jsonfeed.org/version/")) {
+		return false
+	}
+
+	var probe struct {
+		Version string `json:"version"`
+	}
+	if err := json.Unmarshal(trimmed, &probe); err != nil {
+		return false
+	}
+
+	return strings.HasPrefix(probe.Version, versionPrefix)
+}
+
+// Parse reads a JSON Feed document.
+func Parse(body []byte) (*Feed, error) {
+	var feed Feed
+	if err := json.Unmarshal(body, &feed); err != nil {
+		return nil, err
+	}
+
+	if !strings.HasPrefix(feed.Version, versionPrefix) {
+		return nil, errors.New("jsonfeed: missing or unknown version")
+	}
+
+	return &feed, nil
+}
@@ -0,0 +1,170 @@
+// Package metrics exports what arboretum is doing in the Prometheus format.
+package metrics
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "arboretum"
+
+var (
+	// Fetches counts the fetches of feeds by the status code of the response,
+	// or "error" when no response was received.
+	Fetches = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "fetches_total",
+		Help:      "Fetches of feeds by response status code.",
+	}, []string{"code"})
+
+	// ConditionalFetches counts the fetches made with an ETag or
+	// Last-Modified, so that the share that were not modified can be found.
+	ConditionalFetches = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "conditional_fetches_total",
+		Help:      "Fetches of feeds made with If-None-Match or If-Modified-Since, compare to fetches_total{code=\"304\"} for the hit ratio.",
+	})
+
+	FetchDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "fetch_duration_seconds",
+		Help:      "Time taken to fetch and store a feed.",
+		Buckets:   prometheus.ExponentialBuckets(0.05, 2, 10),
+	})
+
+	FetchBytes = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "fetch_bytes_total",
+		Help:      "Bytes of feed bodies downloaded.",
+	})
+
+	// SchedulerLag is how far past due the next feed to poll is, it stays
+	// above zero while fetches are held back by the concurrency limits.
+	SchedulerLag = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "scheduler_next_due_lag_seconds",
+		Help:      "How long the next feed to poll has been due for.",
+	})
+
+	httpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Time taken to handle HTTP requests by route and status code.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"handler", "code"})
+)
+
+// FetchCode gives the label for a fetch that finished with the status code,
+// which is -1 if no response was received.
+func FetchCode(status int) string {
+	if status < 0 {
+		return "error"
+	}
+
+	return strconv.Itoa(status)
+}
+
+// Handler serves the metrics.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// Instrument records how long each request to mux takes, labelled by the
+// pattern of the route that handled it.
+func Instrument(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, pattern := mux.Handler(r)
+		if pattern == "" {
+			pattern = "unmatched"
+		}
+
+		rec := &statusRecorder{ResponseWriter: w, code: http.StatusOK}
+		start := time.Now()
+		mux.ServeHTTP(rec, r)
+
+		httpDuration.WithLabelValues(pattern, strconv.Itoa(rec.code)).Observe(time.Since(start).Seconds())
+	})
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	code        int
+	wroteHeader bool
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	if !r.wroteHeader {
+		r.code = code
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(code)
+}
+
+// Unwrap lets http.ResponseController reach the original writer.
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// A Store is where the feeds and items are kept.
+type Store interface {
+	FeedHealth(context.Context) (map[string]int, error)
+	ItemCount(context.Context) (int, error)
+}
+
+// storeCollector reads what is stored each time the metrics are gathered.
+type storeCollector struct {
+	store Store
+	feeds *prometheus.Desc
+	items *prometheus.Desc
+}
+
+// RegisterStore exports the number of feeds in each state, and the number of
+// items, from store.
+func RegisterStore(store Store) error {
+	return prometheus.Register(&storeCollector{
+		store: store,
+		feeds: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "feeds"),
+			"Subscribed feeds by health, one of ok, failing, dead or gone.",
+			[]string{"state"}, nil),
+		items: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "items"),
+			"Items stored.",
+			nil, nil),
+	})
+}
+
+func (c *storeCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.feeds
+	ch <- c.items
+}
+
+func (c *storeCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	health, err := c.store.FeedHealth(ctx)
+	if err != nil {
+		slog.Error("collect feed health", slog.Any("err", err))
+		ch <- prometheus.NewInvalidMetric(c.feeds, err)
+	} else {
+		for _, state := range []string{"ok", "failing", "dead", "gone"} {
+			ch <- prometheus.MustNewConstMetric(c.feeds, prometheus.GaugeValue, float64(health[state]), state)
+		}
+	}
+
+	items, err := c.store.ItemCount(ctx)
+	if err != nil {
+		slog.Error("collect item count", slog.Any("err", err))
+		ch <- prometheus.NewInvalidMetric(c.items, err)
+	} else {
+		ch <- prometheus.MustNewConstMetric(c.items, prometheus.GaugeValue, float64(items))
+	}
+}
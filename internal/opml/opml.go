@@ -0,0 +1,78 @@
+// Package opml reads and writes OPML subscription lists, including outlines
+// nested in folders.
+package opml
+
+import (
+	"encoding/xml"
+	"io"
+	"os"
+)
+
+type Opml struct {
+	XMLName xml.Name `xml:"opml"`
+	Version string   `xml:"version,attr"`
+	Head    Head     `xml:"head"`
+	Body    Body     `xml:"body"`
+}
+
+type Head struct {
+	Title string `xml:"title"`
+}
+
+type Body struct {
+	Outline []Outline `xml:"outline"`
+}
+
+// An Outline is either a subscription, when XMLURL is set, or a folder
+// containing further outlines.
+type Outline struct {
+	Type    string    `xml:"type,attr,omitempty"`
+	Text    string    `xml:"text,attr"`
+	Title   string    `xml:"title,attr,omitempty"`
+	XMLURL  string    `xml:"xmlUrl,attr,omitempty"`
+	HTMLURL string    `xml:"htmlUrl,attr,omitempty"`
+	Outline []Outline `xml:"outline"`
+}
+
+// Name returns the title of the outline, falling back to its text.
+func (o Outline) Name() string {
+	if o.Title != "" {
+		return o.Title
+	}
+	return o.Text
+}
+
+// Load reads the OPML document at path.
+func Load(path string) (Opml, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return Opml{}, err
+	}
+	defer file.Close()
+
+	return Read(file)
+}
+
+// Read reads an OPML document from r.
+func Read(r io.Reader) (doc Opml, err error) {
+	err = xml.NewDecoder(r).Decode(&doc)
+	return
+}
+
+// Walk calls fn for every subscription in the outlines, passing the names of
+// the folders that contain it.
+func Walk(outlines []Outline, fn func(folder []string, outline Outline)) {
+	walk(nil, outlines, fn)
+}
+
+func walk(folder []string, outlines []Outline, fn func([]string, Outline)) {
+	for _, outline := range outlines {
+		if outline.XMLURL != "" {
+			fn(folder, outline)
+		}
+
+		if len(outline.Outline) > 0 {
+			walk(append(folder[:len(folder):len(folder)], outline.Name()), outline.Outline, fn)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package page
+
+import (
+	"hawx.me/code/arboretum/internal/discover"
+	"hawx.me/code/lmth"
+	. "hawx.me/code/lmth/elements"
+)
+
+func ChooseFeed(where, uri string, feeds []discover.Feed) lmth.Node {
+	return Html(lmth.Attr{"lang": "en"},
+		pageHead,
+		Body(lmth.Attr{"class": "no-hero"},
+			Header(lmth.Attr{"class": "full-width h-app"},
+				H1(lmth.Attr{"class": "p-name"},
+					A(lmth.Attr{"class": "u-url", "href": "/"}, lmth.Text("arboretum")),
+				),
+			),
+
+			Main(lmth.Attr{"class": "full-width"},
+				P(lmth.Attr{}, lmth.Text("Found more than one feed at "+uri+", choose which to add:")),
+				Ul(lmth.Attr{"class": "choices"},
+					lmth.Map(func(feed discover.Feed) lmth.Node {
+						title := feed.Title
+						if title == "" {
+							title = feed.URL
+						}
+
+						return Li(lmth.Attr{},
+							Form(lmth.Attr{"action": "/add", "method": "post"},
+								Input(lmth.Attr{"name": "where", "type": "hidden", "value": where}),
+								Input(lmth.Attr{"name": "url", "type": "hidden", "value": feed.URL}),
+								Button(lmth.Attr{"type": "submit"}, lmth.Text(title)),
+								lmth.Text(" "),
+								Code(lmth.Attr{}, lmth.Text("<"+feed.URL+">")),
+							),
+						)
+					}, feeds),
+				),
+			),
+		),
+	)
+}
@@ -2,7 +2,9 @@ package page
 
 import (
 	"fmt"
+	"io"
 	"math"
+	"strings"
 	"time"
 
 	"hawx.me/code/arboretum/internal/gardenjs"
@@ -30,53 +32,292 @@ func Garden(signedIn bool, where string, feeds []gardenjs.Feed) lmth.Node {
 			),
 
 			Main(lmth.Attr{"class": "full-width"},
-				Ul(lmth.Attr{},
-					lmth.Map(func(feed gardenjs.Feed) lmth.Node {
-						return Li(lmth.Attr{"data-toggled": feed.URL},
-							A(lmth.Attr{"data-toggled": "edit", "href": "/remove?where=garden&url=" + feed.URL, "class": "remove"},
-								lmth.Text("x"),
-							),
-							H2(lmth.Attr{},
-								A(lmth.Attr{"href": feed.WebsiteURL}, lmth.Text(feed.Title)),
-							),
-							lmth.Text(" "),
-							Time(lmth.Attr{"datetime": feed.UpdatedAt.Format(time.RFC3339)}, lmth.Text(ago(feed.UpdatedAt))),
-							Code(lmth.Attr{"data-toggled": "edit"}, lmth.Text("<"+feed.URL+">")),
-							Span(lmth.Attr{"class": "toggle", "data-toggle": feed.URL}, lmth.Text("∴")),
-							Ol(lmth.Attr{},
-								lmth.Map(func(item gardenjs.Item) lmth.Node {
-									return Li(lmth.Attr{},
-										H3(lmth.Attr{},
-											A(lmth.Attr{"href": item.PermaLink}, lmth.Text(item.Title)),
-										),
-										Time(lmth.Attr{"datetime": item.PubDate.Format(time.RFC3339)}, lmth.Text(ago(item.PubDate))),
-									)
-								}, feed.Items),
-							),
-						)
-					}, feeds),
-				),
+				lmth.Map(func(f folder) lmth.Node {
+					return Section(lmth.Attr{"class": "folder"},
+						folderName(f),
+						Ul(lmth.Attr{},
+							lmth.Map(func(feed gardenjs.Feed) lmth.Node {
+								return feedNode(signedIn, feed)
+							}, f.feeds),
+						),
+					)
+				}, folders(feeds)),
 			),
 			Script(lmth.Attr{"src": "/public/toggle.js"}),
 		),
 	)
 }
 
+func feedNode(signedIn bool, feed gardenjs.Feed) lmth.Node {
+	return Li(feedAttr(signedIn, feed),
+		A(lmth.Attr{"data-toggled": "edit", "href": "/remove?where=garden&url=" + feed.URL, "class": "remove"},
+			lmth.Text("x"),
+		),
+		H2(lmth.Attr{},
+			A(lmth.Attr{"href": feed.WebsiteURL}, lmth.Text(feed.Title)),
+		),
+		lmth.Text(" "),
+		Time(lmth.Attr{"datetime": feed.UpdatedAt.Format(time.RFC3339)}, lmth.Text(ago(feed.UpdatedAt))),
+		feedStatus(signedIn, feed),
+		unreadCount(signedIn, feed),
+		Code(lmth.Attr{"data-toggled": "edit"}, lmth.Text("<"+feed.URL+">")),
+		refreshFeed(signedIn, feed),
+		feedSettings(signedIn, feed),
+		Span(lmth.Attr{"class": "toggle", "data-toggle": feed.URL}, lmth.Text("∴")),
+		Ol(lmth.Attr{},
+			lmth.Map(func(item gardenjs.Item) lmth.Node {
+				return Li(itemAttr(signedIn, item),
+					H3(lmth.Attr{},
+						A(lmth.Attr{"href": item.PermaLink}, lmth.Text(item.Title)),
+					),
+					Time(lmth.Attr{"datetime": item.PubDate.Format(time.RFC3339)}, lmth.Text(ago(item.PubDate))),
+					markItemRead(signedIn, feed, item),
+					preview(item),
+				)
+			}, feed.Items),
+		),
+	)
+}
+
+// folder is a group of feeds filed under the same category.
+type folder struct {
+	name  string
+	feeds []gardenjs.Feed
+}
+
+// folders groups feeds by their category, keeping the order in which each
+// folder first appears.
+func folders(feeds []gardenjs.Feed) []folder {
+	var list []folder
+	index := map[string]int{}
+
+	for _, feed := range feeds {
+		name := strings.Join(feed.Category, " / ")
+
+		i, ok := index[name]
+		if !ok {
+			i = len(list)
+			index[name] = i
+			list = append(list, folder{name: name})
+		}
+		list[i].feeds = append(list[i].feeds, feed)
+	}
+
+	return list
+}
+
+func folderName(f folder) lmth.Node {
+	if f.name == "" {
+		return lmth.Text("")
+	}
+
+	return H2(lmth.Attr{"class": "folder-name"}, lmth.Text(f.name))
+}
+
+func feedAttr(signedIn bool, feed gardenjs.Feed) lmth.Attr {
+	attr := lmth.Attr{"data-toggled": feed.URL}
+	if signedIn && (feed.Dead || feed.Gone) {
+		attr["class"] = "dead"
+	}
+
+	return attr
+}
+
+func itemAttr(signedIn bool, item gardenjs.Item) lmth.Attr {
+	if signedIn && !item.Read {
+		return lmth.Attr{"class": "unread"}
+	}
+
+	return lmth.Attr{}
+}
+
+func unread(feed gardenjs.Feed) int {
+	n := 0
+	for _, item := range feed.Items {
+		if !item.Read {
+			n++
+		}
+	}
+
+	return n
+}
+
+func unreadCount(signedIn bool, feed gardenjs.Feed) lmth.Node {
+	n := unread(feed)
+	if !signedIn || n == 0 {
+		return lmth.Text("")
+	}
+
+	return Form(lmth.Attr{"class": "mark-read", "action": "/read", "method": "post"},
+		Input(lmth.Attr{"name": "url", "type": "hidden", "value": feed.URL}),
+		Span(lmth.Attr{"class": "unread-count"}, lmth.Text(fmt.Sprintf("%d unread", n))),
+		Button(lmth.Attr{"type": "submit", "title": "mark feed as read"}, lmth.Text("✓")),
+	)
+}
+
+func refreshFeed(signedIn bool, feed gardenjs.Feed) lmth.Node {
+	if !signedIn {
+		return lmth.Text("")
+	}
+
+	return Form(lmth.Attr{"class": "refresh", "action": "/refresh", "method": "post", "data-toggled": "edit"},
+		Input(lmth.Attr{"name": "url", "type": "hidden", "value": feed.URL}),
+		Button(lmth.Attr{"type": "submit", "title": "refresh now"}, lmth.Text("↻")),
+	)
+}
+
+// feedSettings is the form to change the title, refresh and pause of a feed.
+// Empty fields use the default.
+func feedSettings(signedIn bool, feed gardenjs.Feed) lmth.Node {
+	if !signedIn {
+		return lmth.Text("")
+	}
+
+	id := func(name string) string {
+		return name + "-" + feed.URL
+	}
+
+	paused := lmth.Attr{"name": "paused", "id": id("paused"), "type": "checkbox", "value": "on"}
+	if feed.Paused {
+		paused["checked"] = "checked"
+	}
+
+	return Form(lmth.Attr{"class": "settings", "action": "/settings", "method": "post", "data-toggled": "edit"},
+		Input(lmth.Attr{"name": "url", "type": "hidden", "value": feed.URL}),
+		Label(lmth.Attr{"for": id("title")}, lmth.Text("title")),
+		Input(lmth.Attr{"name": "title", "id": id("title"), "type": "text", "value": feed.CustomTitle}),
+		Label(lmth.Attr{"for": id("refresh")}, lmth.Text("refresh")),
+		Input(lmth.Attr{"name": "refresh", "id": id("refresh"), "type": "text", "value": formatRefresh(feed.Refresh), "placeholder": "default", "size": "6"}),
+		Label(lmth.Attr{"for": id("paused")}, Input(paused), lmth.Text(" paused")),
+		Button(lmth.Attr{"type": "submit"}, lmth.Text("save")),
+	)
+}
+
+// formatRefresh writes d without the zero units that time.Duration.String
+// adds, so 2h rather than 2h0m0s.
+func formatRefresh(d time.Duration) string {
+	if d <= 0 {
+		return ""
+	}
+
+	s := d.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = strings.TrimSuffix(s, "0s")
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = strings.TrimSuffix(s, "0m")
+	}
+
+	return s
+}
+
+func markItemRead(signedIn bool, feed gardenjs.Feed, item gardenjs.Item) lmth.Node {
+	if !signedIn || item.Read {
+		return lmth.Text("")
+	}
+
+	return Form(lmth.Attr{"class": "mark-read", "action": "/read", "method": "post"},
+		Input(lmth.Attr{"name": "url", "type": "hidden", "value": feed.URL}),
+		Input(lmth.Attr{"name": "key", "type": "hidden", "value": item.Key}),
+		Button(lmth.Attr{"type": "submit", "title": "mark as read"}, lmth.Text("✓")),
+	)
+}
+
+func preview(item gardenjs.Item) lmth.Node {
+	if item.Summary == "" && item.Content == "" && item.Enclosure == nil {
+		return lmth.Text("")
+	}
+
+	summary := item.Summary
+	if summary == "" {
+		summary = "preview"
+	}
+
+	byline := lmth.Text("")
+	if item.Author != "" {
+		byline = P(lmth.Attr{"class": "author"}, lmth.Text("by "+item.Author))
+	}
+
+	enclosure := lmth.Text("")
+	if item.Enclosure != nil {
+		label := item.Enclosure.Type
+		if label == "" {
+			label = "attachment"
+		}
+		enclosure = P(lmth.Attr{"class": "enclosure"},
+			A(lmth.Attr{"href": item.Enclosure.URL}, lmth.Text(label)),
+		)
+	}
+
+	return Details(lmth.Attr{"class": "preview"},
+		Summary(lmth.Attr{}, lmth.Text(summary)),
+		byline,
+		Div(lmth.Attr{"class": "content"}, trustedHTML(item.Content)),
+		enclosure,
+	)
+}
+
+// trustedHTML is written to the page without being escaped, so must only hold
+// HTML that has been cleaned by sanitize.HTML.
+type trustedHTML string
+
+func (h trustedHTML) WriteTo(w io.Writer) (int64, error) {
+	n, err := io.WriteString(w, string(h))
+	return int64(n), err
+}
+
+func feedStatus(signedIn bool, feed gardenjs.Feed) lmth.Node {
+	if signedIn && feed.Paused {
+		return Span(lmth.Attr{"class": "status", "title": "not being polled"}, lmth.Text("paused"))
+	}
+	if !signedIn || feed.Error == "" {
+		return lmth.Text("")
+	}
+
+	label := "failing"
+	if feed.Gone {
+		label = "gone"
+	} else if feed.Dead {
+		label = "dead"
+	}
+
+	return Span(lmth.Attr{"class": "status", "title": feed.Error}, lmth.Text(label))
+}
+
 func menu(signedIn bool) lmth.Node {
 	if signedIn {
 		return Ul(lmth.Attr{"class": "actions"},
+			Li(lmth.Attr{},
+				A(lmth.Attr{"href": "search"}, lmth.Text("search")),
+			),
 			Li(lmth.Attr{},
 				A(lmth.Attr{"data-toggle": "add", "href": "#"}, lmth.Text("add")),
 			),
 			Li(lmth.Attr{},
 				A(lmth.Attr{"data-toggle": "edit", "href": "#"}, lmth.Text("edit")),
 			),
+			Li(lmth.Attr{},
+				Form(lmth.Attr{"class": "mark-read", "action": "/read", "method": "post"},
+					Button(lmth.Attr{"type": "submit"}, lmth.Text("mark all read")),
+				),
+			),
+			Li(lmth.Attr{},
+				Form(lmth.Attr{"class": "refresh", "action": "/refresh", "method": "post"},
+					Button(lmth.Attr{"type": "submit"}, lmth.Text("refresh all")),
+				),
+			),
+			Li(lmth.Attr{},
+				A(lmth.Attr{"href": "/status"}, lmth.Text("status")),
+			),
 			Li(lmth.Attr{},
 				A(lmth.Attr{"href": "/sign-out"}, lmth.Text("sign-out")),
 			),
 		)
 	} else {
 		return Ul(lmth.Attr{"class": "actions"},
+			Li(lmth.Attr{},
+				A(lmth.Attr{"href": "search"}, lmth.Text("search")),
+			),
 			Li(lmth.Attr{},
 				A(lmth.Attr{"href": "/sign-in"}, lmth.Text("sign-in")),
 			),
@@ -5,9 +5,13 @@ import (
 	. "hawx.me/code/lmth/elements"
 )
 
+// pageHead links to the feeds relative to the page, so that each user's garden
+// points at their own feeds.
 var pageHead = Head(lmth.Attr{},
 	Meta(lmth.Attr{"charset": "utf-8"}),
 	Meta(lmth.Attr{"viewport": "width=device-width, initial-scale=1.0"}),
 	Title(lmth.Attr{}, lmth.Text("Arboretum")),
 	Link(lmth.Attr{"rel": "stylesheet", "href": "/public/styles.css", "type": "text/css"}),
+	Link(lmth.Attr{"rel": "alternate", "href": "feed.atom", "type": "application/atom+xml", "title": "Arboretum"}),
+	Link(lmth.Attr{"rel": "alternate", "href": "feed.rss", "type": "application/rss+xml", "title": "Arboretum"}),
 )
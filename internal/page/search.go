@@ -0,0 +1,83 @@
+package page
+
+import (
+	"net/url"
+	"time"
+
+	"hawx.me/code/arboretum/internal/data"
+	"hawx.me/code/lmth"
+	. "hawx.me/code/lmth/elements"
+)
+
+// SearchForm holds the values the search page was requested with.
+type SearchForm struct {
+	Query string
+	Feed  string
+	Since string
+	Until string
+}
+
+func Search(signedIn bool, form SearchForm, results []data.SearchResult) lmth.Node {
+	return Html(lmth.Attr{"lang": "en"},
+		pageHead,
+		Body(lmth.Attr{"class": "no-hero"},
+			Header(lmth.Attr{"class": "full-width h-app"},
+				H1(lmth.Attr{"class": "p-name"},
+					A(lmth.Attr{"class": "u-url", "href": "/"}, lmth.Text("arboretum")),
+				),
+				menu(signedIn),
+			),
+
+			Main(lmth.Attr{"class": "full-width"},
+				Form(lmth.Attr{"class": "search", "action": "search", "method": "get"},
+					Input(lmth.Attr{"name": "q", "type": "search", "value": form.Query, "aria-label": "search"}),
+					Input(lmth.Attr{"name": "feed", "type": "hidden", "value": form.Feed}),
+					Label(lmth.Attr{}, lmth.Text("from "),
+						Input(lmth.Attr{"name": "since", "type": "date", "value": form.Since}),
+					),
+					Label(lmth.Attr{}, lmth.Text(" to "),
+						Input(lmth.Attr{"name": "until", "type": "date", "value": form.Until}),
+					),
+					Button(lmth.Attr{"type": "submit"}, lmth.Text("Search")),
+				),
+				searchResults(form, results),
+			),
+		),
+	)
+}
+
+func searchResults(form SearchForm, results []data.SearchResult) lmth.Node {
+	if form.Query == "" {
+		return lmth.Text("")
+	}
+	if len(results) == 0 {
+		return P(lmth.Attr{}, lmth.Text("Nothing found."))
+	}
+
+	return Ol(lmth.Attr{"class": "results"},
+		lmth.Map(func(result data.SearchResult) lmth.Node {
+			title := result.FeedTitle
+			if title == "" {
+				title = result.FeedURL
+			}
+
+			onlyFeed := url.Values{
+				"q":     {form.Query},
+				"feed":  {result.FeedURL},
+				"since": {form.Since},
+				"until": {form.Until},
+			}
+
+			return Li(lmth.Attr{},
+				H3(lmth.Attr{},
+					A(lmth.Attr{"href": result.Item.PermaLink}, lmth.Text(result.Item.Title)),
+				),
+				Time(lmth.Attr{"datetime": result.Item.PubDate.Format(time.RFC3339)}, lmth.Text(ago(result.Item.PubDate))),
+				lmth.Text(" in "),
+				A(lmth.Attr{"href": result.WebsiteURL}, lmth.Text(title)),
+				lmth.Text(" "),
+				A(lmth.Attr{"class": "filter", "href": "search?" + onlyFeed.Encode()}, lmth.Text("only this feed")),
+			)
+		}, results),
+	)
+}
@@ -5,7 +5,18 @@ import (
 	. "hawx.me/code/lmth/elements"
 )
 
-func SignIn() lmth.Node {
+// SignIn is shown to anyone not signed in when the garden is private. When ask
+// is true more than one profile URL may sign in, so it asks which to use.
+func SignIn(ask bool) lmth.Node {
+	signIn := A(lmth.Attr{"href": "/sign-in"}, lmth.Text("Sign-in"))
+	if ask {
+		signIn = Form(lmth.Attr{"action": "/sign-in", "method": "get"},
+			Label(lmth.Attr{"for": "me"}, lmth.Text("Your website")),
+			Input(lmth.Attr{"name": "me", "id": "me", "type": "text", "placeholder": "https://example.com/"}),
+			Button(lmth.Attr{"type": "submit"}, lmth.Text("Sign-in")),
+		)
+	}
+
 	return Html(lmth.Attr{"lang": "en"},
 		pageHead,
 		Body(lmth.Attr{},
@@ -15,7 +26,7 @@ func SignIn() lmth.Node {
 				),
 			),
 			Div(lmth.Attr{"id": "cover"},
-				A(lmth.Attr{"href": "/sign-in"}, lmth.Text("Sign-in")),
+				signIn,
 			),
 		),
 	)
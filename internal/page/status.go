@@ -0,0 +1,74 @@
+package page
+
+import (
+	"strconv"
+	"time"
+
+	"hawx.me/code/arboretum/internal/data"
+	"hawx.me/code/lmth"
+	. "hawx.me/code/lmth/elements"
+)
+
+func Status(ready bool, reports []data.FeedReport) lmth.Node {
+	state := "Polling all subscriptions."
+	if !ready {
+		state = "Still loading subscriptions."
+	}
+
+	return Html(lmth.Attr{"lang": "en"},
+		pageHead,
+		Body(lmth.Attr{"class": "no-hero"},
+			Header(lmth.Attr{"class": "full-width h-app"},
+				H1(lmth.Attr{"class": "p-name"},
+					A(lmth.Attr{"class": "u-url", "href": "/"}, lmth.Text("arboretum")),
+				),
+				menu(true),
+			),
+
+			Main(lmth.Attr{"class": "full-width"},
+				P(lmth.Attr{}, lmth.Text(state)),
+				Table(lmth.Attr{"class": "feed-status"},
+					Thead(lmth.Attr{},
+						Tr(lmth.Attr{},
+							Th(lmth.Attr{}, lmth.Text("Feed")),
+							Th(lmth.Attr{}, lmth.Text("Last fetch")),
+							Th(lmth.Attr{}, lmth.Text("Status")),
+							Th(lmth.Attr{}, lmth.Text("Error")),
+							Th(lmth.Attr{}, lmth.Text("Next poll")),
+						),
+					),
+					Tbody(lmth.Attr{},
+						lmth.Map(func(report data.FeedReport) lmth.Node {
+							return Tr(lmth.Attr{},
+								Td(lmth.Attr{}, A(lmth.Attr{"href": report.URL}, lmth.Text(report.URL))),
+								Td(lmth.Attr{}, statusTime(report.LastFetch)),
+								Td(lmth.Attr{}, lmth.Text(statusCode(report.StatusCode))),
+								Td(lmth.Attr{}, lmth.Text(report.Error)),
+								Td(lmth.Attr{}, statusTime(report.NextPoll)),
+							)
+						}, reports),
+					),
+				),
+			),
+		),
+	)
+}
+
+func statusTime(t time.Time) lmth.Node {
+	if t.IsZero() {
+		return lmth.Text("-")
+	}
+
+	return Time(lmth.Attr{"datetime": t.Format(time.RFC3339)}, lmth.Text(t.Local().Format(time.DateTime)))
+}
+
+func statusCode(code int) string {
+	switch {
+	case code == 0:
+		return "-"
+	case code < 0:
+		return "no response"
+	default:
+		return strconv.Itoa(code)
+	}
+}
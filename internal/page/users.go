@@ -0,0 +1,37 @@
+package page
+
+import (
+	"hawx.me/code/arboretum/internal/data"
+	"hawx.me/code/lmth"
+	. "hawx.me/code/lmth/elements"
+)
+
+// Users lists the gardens kept on this instance, it is shown to anyone not
+// signed in when there is more than one.
+func Users(list []data.User) lmth.Node {
+	return Html(lmth.Attr{"lang": "en"},
+		pageHead,
+		Body(lmth.Attr{"class": "no-hero"},
+			Header(lmth.Attr{"class": "full-width h-app"},
+				H1(lmth.Attr{"class": "p-name"},
+					A(lmth.Attr{"class": "u-url", "href": "/"}, lmth.Text("arboretum")),
+				),
+				Ul(lmth.Attr{"class": "actions"},
+					Li(lmth.Attr{},
+						A(lmth.Attr{"href": "/sign-in"}, lmth.Text("sign-in")),
+					),
+				),
+			),
+
+			Main(lmth.Attr{"class": "full-width"},
+				Ul(lmth.Attr{"class": "users"},
+					lmth.Map(func(user data.User) lmth.Node {
+						return Li(lmth.Attr{},
+							A(lmth.Attr{"href": "/u/" + user.Name + "/"}, lmth.Text(user.Name)),
+						)
+					}, list),
+				),
+			),
+		),
+	)
+}
@@ -0,0 +1,54 @@
+// Package readstate handles marking items in the garden as read.
+package readstate
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+
+	"hawx.me/code/arboretum/internal/users"
+)
+
+type DB interface {
+	MarkItemRead(ctx context.Context, user int64, uri, key string) error
+	MarkFeedRead(ctx context.Context, user int64, uri string) error
+	MarkAllRead(ctx context.Context, user int64) error
+}
+
+// Mark marks items as read by the user of the request. Given both a url and
+// key form value it marks that single item, given only a url it marks every
+// item in that feed, and given neither it marks everything.
+func Mark(db DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "", http.StatusMethodNotAllowed)
+			return
+		}
+
+		user := users.From(r.Context()).ID
+		uri := r.FormValue("url")
+		key := r.FormValue("key")
+
+		var err error
+		switch {
+		case uri != "" && key != "":
+			err = db.MarkItemRead(r.Context(), user, uri, key)
+		case uri != "":
+			err = db.MarkFeedRead(r.Context(), user, uri)
+		case key != "":
+			http.Error(w, "key given without url", http.StatusBadRequest)
+			return
+		default:
+			err = db.MarkAllRead(r.Context(), user)
+		}
+
+		if err != nil {
+			slog.Error("mark read", slog.String("uri", uri), slog.String("key", key), slog.Any("err", err))
+			http.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+
+		http.Redirect(w, r, "/", http.StatusFound)
+	}
+}
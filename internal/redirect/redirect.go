@@ -0,0 +1,54 @@
+// Package redirect follows HTTP redirects while keeping track of where a
+// resource has permanently moved to.
+package redirect
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+)
+
+// maxRedirects matches the limit of the default http.Client.
+const maxRedirects = 10
+
+// A Tracker records the redirects followed by a client from Track.
+type Tracker struct {
+	temporary bool
+	movedTo   *url.URL
+}
+
+// Track returns a copy of client that follows redirects as normal, recording
+// them in the returned Tracker. Use a new client for each request.
+func Track(client *http.Client) (*http.Client, *Tracker) {
+	tracker := &Tracker{}
+	tracked := *client
+	tracked.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if len(via) >= maxRedirects {
+			return errors.New("stopped after 10 redirects")
+		}
+
+		switch req.Response.StatusCode {
+		case http.StatusMovedPermanently, http.StatusPermanentRedirect:
+			if !tracker.temporary {
+				tracker.movedTo = req.URL
+			}
+		default:
+			tracker.temporary = true
+		}
+
+		return nil
+	}
+
+	return &tracked, tracker
+}
+
+// MovedTo returns the URL the resource has moved to, if it was redirected and
+// every redirect was permanent. Otherwise it returns nil, as the original URL
+// is still the one to use.
+func (t *Tracker) MovedTo() *url.URL {
+	if t.temporary {
+		return nil
+	}
+
+	return t.movedTo
+}
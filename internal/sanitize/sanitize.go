@@ -0,0 +1,288 @@
+// Package sanitize cleans HTML taken from feeds so that it is safe to show in
+// our pages.
+package sanitize
+
+import (
+	"net/url"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// allowed lists the elements that are kept, along with the attributes they may
+// keep. Any other element is replaced by its children.
+var allowed = map[atom.Atom][]string{
+	atom.A:          {"href", "title"},
+	atom.Abbr:       {"title"},
+	atom.B:          nil,
+	atom.Blockquote: {"cite"},
+	atom.Br:         nil,
+	atom.Code:       nil,
+	atom.Dd:         nil,
+	atom.Del:        nil,
+	atom.Dl:         nil,
+	atom.Dt:         nil,
+	atom.Em:         nil,
+	atom.Figcaption: nil,
+	atom.Figure:     nil,
+	atom.H1:         nil,
+	atom.H2:         nil,
+	atom.H3:         nil,
+	atom.H4:         nil,
+	atom.H5:         nil,
+	atom.H6:         nil,
+	atom.Hr:         nil,
+	atom.I:          nil,
+	atom.Img:        {"src", "alt", "title", "width", "height"},
+	atom.Ins:        nil,
+	atom.Li:         nil,
+	atom.Ol:         nil,
+	atom.P:          nil,
+	atom.Pre:        nil,
+	atom.Q:          {"cite"},
+	atom.S:          nil,
+	atom.Small:      nil,
+	atom.Strong:     nil,
+	atom.Sub:        nil,
+	atom.Sup:        nil,
+	atom.Table:      nil,
+	atom.Tbody:      nil,
+	atom.Td:         {"colspan", "rowspan"},
+	atom.Tfoot:      nil,
+	atom.Th:         {"colspan", "rowspan"},
+	atom.Thead:      nil,
+	atom.Tr:         nil,
+	atom.U:          nil,
+	atom.Ul:         nil,
+}
+
+// dropped lists the elements that are removed along with everything in them.
+var dropped = map[atom.Atom]bool{
+	atom.Button:   true,
+	atom.Embed:    true,
+	atom.Form:     true,
+	atom.Frame:    true,
+	atom.Frameset: true,
+	atom.Head:     true,
+	atom.Iframe:   true,
+	atom.Input:    true,
+	atom.Link:     true,
+	atom.Math:     true,
+	atom.Meta:     true,
+	atom.Noscript: true,
+	atom.Object:   true,
+	atom.Script:   true,
+	atom.Select:   true,
+	atom.Style:    true,
+	atom.Svg:      true,
+	atom.Template: true,
+	atom.Textarea: true,
+	atom.Title:    true,
+}
+
+// inline lists the elements that do not separate the words around them.
+var inline = map[atom.Atom]bool{
+	atom.A:      true,
+	atom.Abbr:   true,
+	atom.B:      true,
+	atom.Code:   true,
+	atom.Del:    true,
+	atom.Em:     true,
+	atom.I:      true,
+	atom.Ins:    true,
+	atom.Mark:   true,
+	atom.Q:      true,
+	atom.S:      true,
+	atom.Small:  true,
+	atom.Span:   true,
+	atom.Strong: true,
+	atom.Sub:    true,
+	atom.Sup:    true,
+	atom.U:      true,
+}
+
+// urlAttrs are the attributes that hold links, relative links are resolved and
+// anything other than http, https or mailto is removed.
+var urlAttrs = map[string]bool{
+	"href": true,
+	"src":  true,
+	"cite": true,
+}
+
+// HTML returns s with every element and attribute that is not in the allowlist
+// removed. Links are resolved against base, and open without giving the
+// linked page access to ours.
+func HTML(s, base string) string {
+	baseURL, _ := url.Parse(base)
+
+	nodes, err := html.ParseFragment(strings.NewReader(s), &html.Node{
+		Type:     html.ElementNode,
+		Data:     "div",
+		DataAtom: atom.Div,
+	})
+	if err != nil {
+		return html.EscapeString(s)
+	}
+
+	var b strings.Builder
+	for _, node := range nodes {
+		for _, clean := range clean(node, baseURL) {
+			if err := html.Render(&b, clean); err != nil {
+				return html.EscapeString(s)
+			}
+		}
+	}
+
+	return strings.TrimSpace(b.String())
+}
+
+// clean returns the nodes that should replace node.
+func clean(node *html.Node, base *url.URL) []*html.Node {
+	switch node.Type {
+	case html.TextNode:
+		return []*html.Node{{Type: html.TextNode, Data: node.Data}}
+
+	case html.ElementNode:
+		if dropped[node.DataAtom] {
+			return nil
+		}
+
+		var children []*html.Node
+		for child := node.FirstChild; child != nil; child = child.NextSibling {
+			children = append(children, clean(child, base)...)
+		}
+
+		attrs, ok := allowed[node.DataAtom]
+		if !ok {
+			return children
+		}
+
+		el := &html.Node{
+			Type:     html.ElementNode,
+			Data:     node.Data,
+			DataAtom: node.DataAtom,
+			Attr:     cleanAttrs(node.Attr, attrs, base),
+		}
+		if node.DataAtom == atom.A {
+			el.Attr = append(el.Attr, html.Attribute{Key: "rel", Val: "noopener noreferrer nofollow"})
+		}
+		if node.DataAtom == atom.Img && !hasAttr(el, "src") {
+			return nil
+		}
+
+		for _, child := range children {
+			el.AppendChild(child)
+		}
+		return []*html.Node{el}
+
+	default:
+		// comments, doctypes and anything else are not shown
+		return nil
+	}
+}
+
+func cleanAttrs(attrs []html.Attribute, keep []string, base *url.URL) []html.Attribute {
+	var cleaned []html.Attribute
+
+	for _, attr := range attrs {
+		if attr.Namespace != "" || !contains(keep, attr.Key) {
+			continue
+		}
+
+		if urlAttrs[attr.Key] {
+			resolved, ok := safeURL(attr.Val, base)
+			if !ok {
+				continue
+			}
+			attr.Val = resolved
+		}
+
+		cleaned = append(cleaned, html.Attribute{Key: attr.Key, Val: attr.Val})
+	}
+
+	return cleaned
+}
+
+func safeURL(s string, base *url.URL) (string, bool) {
+	u, err := url.Parse(strings.TrimSpace(s))
+	if err != nil {
+		return "", false
+	}
+	if base != nil {
+		u = base.ResolveReference(u)
+	}
+
+	switch u.Scheme {
+	case "http", "https", "mailto":
+		return u.String(), true
+	default:
+		return "", false
+	}
+}
+
+func hasAttr(node *html.Node, key string) bool {
+	for _, attr := range node.Attr {
+		if attr.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(list []string, s string) bool {
+	for _, x := range list {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Text returns the text of the HTML in s with whitespace collapsed, cut to at
+// most max characters.
+func Text(s string, max int) string {
+	nodes, err := html.ParseFragment(strings.NewReader(s), &html.Node{
+		Type:     html.ElementNode,
+		Data:     "div",
+		DataAtom: atom.Div,
+	})
+	if err != nil {
+		return ""
+	}
+
+	var b strings.Builder
+	var walk func(*html.Node)
+	walk = func(node *html.Node) {
+		if node.Type == html.ElementNode && dropped[node.DataAtom] {
+			return
+		}
+		if node.Type == html.TextNode {
+			b.WriteString(node.Data)
+		}
+
+		// keep words in separate blocks apart
+		block := node.Type == html.ElementNode && !inline[node.DataAtom]
+		if block {
+			b.WriteString(" ")
+		}
+		for child := node.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+		if block {
+			b.WriteString(" ")
+		}
+	}
+	for _, node := range nodes {
+		walk(node)
+	}
+
+	text := strings.Join(strings.Fields(b.String()), " ")
+	if max > 0 && utf8.RuneCountInString(text) > max {
+		runes := []rune(text)
+		text = strings.TrimSpace(string(runes[:max-1])) + "…"
+	}
+
+	return text
+}
@@ -0,0 +1,125 @@
+// Package search handles searching the items stored in the garden.
+package search
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"hawx.me/code/arboretum/internal/data"
+	"hawx.me/code/arboretum/internal/page"
+	"hawx.me/code/arboretum/internal/users"
+)
+
+type DB interface {
+	Search(context.Context, data.SearchQuery) ([]data.SearchResult, error)
+}
+
+// Handler serves a page to search items, with results for the q form value.
+// Results can be narrowed with a feed URL and since/until dates.
+func Handler(db DB, signedIn bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		form, results, ok := search(w, r, db)
+		if !ok {
+			return
+		}
+
+		if _, err := page.Search(signedIn, form, results).WriteTo(w); err != nil {
+			slog.Error("render search", slog.Any("err", err))
+		}
+	}
+}
+
+type jsonResults struct {
+	Query   string       `json:"query"`
+	Results []jsonResult `json:"results"`
+}
+
+type jsonResult struct {
+	FeedURL    string    `json:"feedUrl"`
+	FeedTitle  string    `json:"feedTitle"`
+	WebsiteURL string    `json:"websiteUrl"`
+	PermaLink  string    `json:"permaLink"`
+	PubDate    time.Time `json:"pubDate"`
+	Title      string    `json:"title"`
+	Link       string    `json:"link"`
+}
+
+// JSONHandler serves the results of a search as JSON, taking the same form
+// values as Handler.
+func JSONHandler(db DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		form, results, ok := search(w, r, db)
+		if !ok {
+			return
+		}
+
+		resp := jsonResults{Query: form.Query, Results: []jsonResult{}}
+		for _, result := range results {
+			resp.Results = append(resp.Results, jsonResult{
+				FeedURL:    result.FeedURL,
+				FeedTitle:  result.FeedTitle,
+				WebsiteURL: result.WebsiteURL,
+				PermaLink:  result.Item.PermaLink,
+				PubDate:    result.Item.PubDate,
+				Title:      result.Item.Title,
+				Link:       result.Item.Link,
+			})
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			slog.Error("encode search results", slog.Any("err", err))
+		}
+	}
+}
+
+// search runs the query given in the request, over the feeds of the user of the
+// request. If it fails an error response is written and ok is false.
+func search(w http.ResponseWriter, r *http.Request, db DB) (form page.SearchForm, results []data.SearchResult, ok bool) {
+	form = page.SearchForm{
+		Query: r.FormValue("q"),
+		Feed:  r.FormValue("feed"),
+		Since: r.FormValue("since"),
+		Until: r.FormValue("until"),
+	}
+
+	query := data.SearchQuery{
+		Text:    form.Query,
+		User:    users.From(r.Context()).ID,
+		FeedURL: form.Feed,
+	}
+
+	var err error
+	if form.Since != "" {
+		if query.Since, err = time.Parse(time.DateOnly, form.Since); err != nil {
+			http.Error(w, "since must be a date like 2006-01-02", http.StatusBadRequest)
+			return
+		}
+	}
+	if form.Until != "" {
+		until, err := time.Parse(time.DateOnly, form.Until)
+		if err != nil {
+			http.Error(w, "until must be a date like 2006-01-02", http.StatusBadRequest)
+			return
+		}
+		// include the whole of the last day
+		query.Until = until.AddDate(0, 0, 1)
+	}
+
+	results, err = db.Search(r.Context(), query)
+	if errors.Is(err, data.ErrSearchUnavailable) {
+		http.Error(w, err.Error(), http.StatusNotImplemented)
+		return
+	}
+	if err != nil {
+		slog.Error("search", slog.String("q", form.Query), slog.Any("err", err))
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	return form, results, true
+}
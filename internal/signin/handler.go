@@ -7,8 +7,10 @@ import (
 	"hawx.me/code/arboretum/internal/page"
 )
 
-func Handler() http.HandlerFunc {
-	signInPage := page.SignIn()
+// Handler shows the sign-in page, when ask is true it asks for the profile URL
+// to sign in with.
+func Handler(ask bool) http.HandlerFunc {
+	signInPage := page.SignIn(ask)
 
 	return func(w http.ResponseWriter, r *http.Request) {
 		if _, err := signInPage.WriteTo(w); err != nil {
@@ -3,77 +3,251 @@ package subscriptions
 import (
 	"context"
 	"encoding/xml"
+	"io"
 	"log/slog"
 	"net/http"
+	"strings"
+	"time"
 
-	"hawx.me/code/riviera/subscriptions/opml"
+	"hawx.me/code/arboretum/internal/data"
+	"hawx.me/code/arboretum/internal/discover"
+	"hawx.me/code/arboretum/internal/opml"
+	"hawx.me/code/arboretum/internal/page"
+	"hawx.me/code/arboretum/internal/users"
 )
 
-func Add(subs ...interface {
+// Add subscribes the user of the request to the feed given in the url form
+// value, and has any gardens start fetching it. If a website is given instead of a
+// feed, the feeds it offers are discovered; when there is more than one a page
+// to choose between them is shown.
+func Add(client *http.Client, db interface {
+	Subscribe(ctx context.Context, user int64, uri string) error
+}, gardens ...interface {
 	Subscribe(context.Context, string) error
 }) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		uri := r.FormValue("url")
 
-		for _, sub := range subs {
-			if err := sub.Subscribe(r.Context(), uri); err != nil {
+		feeds, err := discover.Find(r.Context(), client, uri)
+		if err != nil {
+			slog.Error("discover feeds", slog.String("uri", uri), slog.Any("err", err))
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		switch len(feeds) {
+		case 0:
+			http.Error(w, "no feeds found at "+uri, http.StatusBadRequest)
+			return
+
+		case 1:
+			uri = feeds[0].URL
+
+		default:
+			if _, err := page.ChooseFeed(r.FormValue("where"), uri, feeds).WriteTo(w); err != nil {
+				slog.Error("render choose feed", slog.Any("err", err))
+			}
+			return
+		}
+
+		user := users.From(r.Context())
+		if err := db.Subscribe(r.Context(), user.ID, uri); err != nil {
+			slog.Error("add subscription", slog.String("uri", uri), slog.Any("err", err))
+			http.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+		for _, garden := range gardens {
+			if err := garden.Subscribe(r.Context(), uri); err != nil {
 				slog.Error("add subscription", slog.String("uri", uri), slog.Any("err", err))
 			}
 		}
-		slog.Info("subscribed", slog.String("uri", uri))
+		slog.Info("subscribed", slog.String("user", user.Name), slog.String("uri", uri))
 
 		http.Redirect(w, r, "/", http.StatusFound)
 	}
 }
 
-func Remove(subs ...interface {
+// Remove unsubscribes the user of the request from the feed given in the url
+// form value. The gardens only stop fetching it once no one subscribes to it,
+// until then they poll it as the remaining subscribers have chosen.
+func Remove(db interface {
+	Unsubscribe(ctx context.Context, user int64, uri string) (bool, error)
+}, gardens ...interface {
 	Unsubscribe(context.Context, string) error
+	SettingsChanged(context.Context, string) error
 }) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		uri := r.FormValue("url")
 
-		for _, sub := range subs {
-			if err := sub.Unsubscribe(r.Context(), uri); err != nil {
+		user := users.From(r.Context())
+		removed, err := db.Unsubscribe(r.Context(), user.ID, uri)
+		if err != nil {
+			slog.Error("remove subscription", slog.String("uri", uri), slog.Any("err", err))
+			http.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+		for _, garden := range gardens {
+			update := garden.SettingsChanged
+			if removed {
+				update = garden.Unsubscribe
+			}
+
+			if err := update(r.Context(), uri); err != nil {
 				slog.Error("remove subscription", slog.String("uri", uri), slog.Any("err", err))
 			}
 		}
-		slog.Info("unsubscribed", slog.String("uri", uri))
+		slog.Info("unsubscribed", slog.String("user", user.Name), slog.String("uri", uri))
+
+		http.Redirect(w, r, "/", http.StatusFound)
+	}
+}
+
+// Settings changes the settings the user of the request has chosen for the feed
+// given in the url form value, from the title, refresh and paused form values.
+// An empty title or refresh goes back to using the default. The gardens then
+// poll the feed as its subscribers have chosen between them.
+func Settings(db interface {
+	SetFeedSettings(ctx context.Context, user int64, uri string, settings data.FeedSettings) (bool, error)
+}, gardens ...interface {
+	SettingsChanged(context.Context, string) error
+}) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "", http.StatusMethodNotAllowed)
+			return
+		}
+
+		uri := r.FormValue("url")
+		if uri == "" {
+			http.Error(w, "url is required", http.StatusBadRequest)
+			return
+		}
+
+		settings := data.FeedSettings{
+			Title:  strings.TrimSpace(r.FormValue("title")),
+			Paused: r.FormValue("paused") != "",
+		}
+		if refresh := strings.TrimSpace(r.FormValue("refresh")); refresh != "" {
+			d, err := time.ParseDuration(refresh)
+			if err != nil || d < time.Minute {
+				http.Error(w, "refresh must be a duration of at least 1m, like 30m or 2h", http.StatusBadRequest)
+				return
+			}
+			settings.Refresh = d
+		}
+
+		user := users.From(r.Context())
+		ok, err := db.SetFeedSettings(r.Context(), user.ID, uri, settings)
+		if err != nil {
+			slog.Error("set feed settings", slog.String("uri", uri), slog.Any("err", err))
+			http.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+
+		for _, garden := range gardens {
+			if err := garden.SettingsChanged(r.Context(), uri); err != nil {
+				slog.Error("set feed settings", slog.String("uri", uri), slog.Any("err", err))
+			}
+		}
+		slog.Info("changed settings", slog.String("user", user.Name), slog.String("uri", uri))
 
 		http.Redirect(w, r, "/", http.StatusFound)
 	}
 }
 
+// List exports the subscriptions of the user of the request as OPML, with
+// feeds nested in outlines for the folders they are filed under.
 func List(subs interface {
-	Subscriptions(context.Context) ([]string, error)
+	SubscriptionDetails(context.Context, int64) ([]data.Subscription, error)
 }) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		list, err := subs.Subscriptions(r.Context())
+		list, err := subs.SubscriptionDetails(r.Context(), users.From(r.Context()).ID)
 		if err != nil {
 			slog.Error("list subscriptions", slog.Any("err", err))
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 
-		var outlines []opml.Outline
-		for _, uri := range list {
-			outlines = append(outlines, opml.Outline{
-				XMLURL: uri,
-			})
+		w.Header().Set("Content-Type", "text/x-opml+xml")
+		if err := writeOpml(w, list); err != nil {
+			slog.Error("encode subscriptions to xml", slog.Any("err", err))
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}
+}
+
+// Export writes the subscriptions of user to w as OPML, in the same form as
+// List.
+func Export(ctx context.Context, subs interface {
+	SubscriptionDetails(context.Context, int64) ([]data.Subscription, error)
+}, user int64, w io.Writer) error {
+	list, err := subs.SubscriptionDetails(ctx, user)
+	if err != nil {
+		return err
+	}
+
+	return writeOpml(w, list)
+}
+
+func writeOpml(w io.Writer, list []data.Subscription) error {
+	doc := opml.Opml{
+		Version: "1.0",
+		Head: opml.Head{
+			Title: "arboretum subscriptions",
+		},
+		Body: opml.Body{
+			Outline: outlines(list),
+		},
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+
+	return xml.NewEncoder(w).Encode(doc)
+}
+
+// outlines nests the subscriptions in outlines for their folders.
+func outlines(list []data.Subscription) []opml.Outline {
+	var root []opml.Outline
+
+	for _, sub := range list {
+		title := sub.Title
+		if title == "" {
+			title = sub.URL
 		}
 
-		data := opml.Opml{
-			Version: "1.0",
-			Head: opml.Head{
-				Title: "arboretum subscriptions",
-			},
-			Body: opml.Body{
-				Outline: outlines,
-			},
+		level := &root
+		for _, name := range sub.Category {
+			level = folderOutline(level, name)
 		}
 
-		if err := xml.NewEncoder(w).Encode(data); err != nil {
-			slog.Error("encode subscriptions to xml", slog.Any("err", err))
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+		*level = append(*level, opml.Outline{
+			Type:    "rss",
+			Text:    title,
+			Title:   title,
+			XMLURL:  sub.URL,
+			HTMLURL: sub.WebsiteURL,
+		})
+	}
+
+	return root
+}
+
+// folderOutline finds the folder called name in level, adding it if missing,
+// and returns its children.
+func folderOutline(level *[]opml.Outline, name string) *[]opml.Outline {
+	for i, outline := range *level {
+		if outline.XMLURL == "" && outline.Text == name {
+			return &(*level)[i].Outline
 		}
 	}
+
+	*level = append(*level, opml.Outline{Text: name, Title: name})
+	return &(*level)[len(*level)-1].Outline
 }
@@ -0,0 +1,170 @@
+// Package syndication defines types that build Atom and RSS format feeds.
+package syndication
+
+import (
+	"encoding/xml"
+	"time"
+)
+
+// Entry is a single item to publish, along with the feed it came from.
+type Entry struct {
+	ID        string
+	Title     string
+	Link      string
+	PubDate   time.Time
+	Summary   string
+	Content   string
+	Source    string
+	SourceURL string
+	FeedURL   string
+}
+
+type AtomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Links   []AtomLink  `xml:"link"`
+	Entries []AtomEntry `xml:"entry"`
+}
+
+type AtomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+type AtomEntry struct {
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Links   []AtomLink  `xml:"link"`
+	Summary string      `xml:"summary,omitempty"`
+	Content *AtomText   `xml:"content,omitempty"`
+	Author  AtomPerson  `xml:"author"`
+	Source  *AtomSource `xml:"source,omitempty"`
+}
+
+type AtomText struct {
+	Type  string `xml:"type,attr"`
+	Value string `xml:",chardata"`
+}
+
+type AtomPerson struct {
+	Name string `xml:"name"`
+	URI  string `xml:"uri,omitempty"`
+}
+
+type AtomSource struct {
+	ID    string     `xml:"id,omitempty"`
+	Title string     `xml:"title"`
+	Links []AtomLink `xml:"link"`
+}
+
+// Atom builds an Atom feed hosted at self from the given entries, which should
+// already be in the order they are to be published.
+func Atom(self, title string, updated time.Time, entries []Entry) AtomFeed {
+	feed := AtomFeed{
+		ID:      self,
+		Title:   title,
+		Updated: updated.UTC().Format(time.RFC3339),
+		Links: []AtomLink{
+			{Href: self, Rel: "self", Type: "application/atom+xml"},
+		},
+	}
+
+	for _, entry := range entries {
+		mapped := AtomEntry{
+			ID:      entry.ID,
+			Title:   entry.Title,
+			Updated: entry.PubDate.UTC().Format(time.RFC3339),
+			Links:   []AtomLink{{Href: entry.Link, Rel: "alternate"}},
+			Summary: entry.Summary,
+			Author:  AtomPerson{Name: entry.Source, URI: entry.SourceURL},
+			Source: &AtomSource{
+				ID:    entry.FeedURL,
+				Title: entry.Source,
+				Links: []AtomLink{
+					{Href: entry.SourceURL, Rel: "alternate"},
+					{Href: entry.FeedURL, Rel: "self"},
+				},
+			},
+		}
+		if entry.Content != "" {
+			mapped.Content = &AtomText{Type: "html", Value: entry.Content}
+		}
+
+		feed.Entries = append(feed.Entries, mapped)
+	}
+
+	return feed
+}
+
+type RSSFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel RSSChannel `xml:"channel"`
+}
+
+type RSSChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	LastBuildDate string    `xml:"lastBuildDate,omitempty"`
+	Items         []RSSItem `xml:"item"`
+}
+
+type RSSItem struct {
+	Title       string    `xml:"title"`
+	Link        string    `xml:"link"`
+	Description string    `xml:"description,omitempty"`
+	GUID        RSSGUID   `xml:"guid"`
+	PubDate     string    `xml:"pubDate,omitempty"`
+	Source      RSSSource `xml:"source"`
+}
+
+type RSSGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+type RSSSource struct {
+	URL   string `xml:"url,attr"`
+	Value string `xml:",chardata"`
+}
+
+// RSS builds an RSS 2.0 feed linking to site from the given entries, which
+// should already be in the order they are to be published.
+func RSS(site, title string, updated time.Time, entries []Entry) RSSFeed {
+	feed := RSSFeed{
+		Version: "2.0",
+		Channel: RSSChannel{
+			Title:       title,
+			Link:        site,
+			Description: title,
+		},
+	}
+	if !updated.IsZero() {
+		feed.Channel.LastBuildDate = updated.UTC().Format(time.RFC1123Z)
+	}
+
+	for _, entry := range entries {
+		mapped := RSSItem{
+			Title:       entry.Title,
+			Link:        entry.Link,
+			Description: entry.Content,
+			GUID:        RSSGUID{Value: entry.ID},
+			Source: RSSSource{
+				URL:   entry.FeedURL,
+				Value: entry.Source,
+			},
+		}
+		if !entry.PubDate.IsZero() {
+			mapped.PubDate = entry.PubDate.UTC().Format(time.RFC1123Z)
+		}
+
+		feed.Channel.Items = append(feed.Channel.Items, mapped)
+	}
+
+	return feed
+}
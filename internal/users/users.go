@@ -0,0 +1,22 @@
+// Package users carries the user a request is for, so that handlers serve and
+// change that user's garden.
+package users
+
+import (
+	"context"
+
+	"hawx.me/code/arboretum/internal/data"
+)
+
+type contextKey struct{}
+
+// With returns a copy of ctx carrying user.
+func With(ctx context.Context, user data.User) context.Context {
+	return context.WithValue(ctx, contextKey{}, user)
+}
+
+// From returns the user carried by ctx, or the zero User if there is none.
+func From(ctx context.Context) data.User {
+	user, _ := ctx.Value(contextKey{}).(data.User)
+	return user
+}
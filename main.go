@@ -5,18 +5,26 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"io"
 	"log/slog"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"sync"
+	"syscall"
 	"time"
 
 	"hawx.me/code/arboretum/internal/data"
 	"hawx.me/code/arboretum/internal/garden"
+	"hawx.me/code/arboretum/internal/metrics"
+	"hawx.me/code/arboretum/internal/opml"
+	"hawx.me/code/arboretum/internal/readstate"
+	"hawx.me/code/arboretum/internal/search"
 	"hawx.me/code/arboretum/internal/signin"
 	"hawx.me/code/arboretum/internal/subscriptions"
+	"hawx.me/code/arboretum/internal/users"
 	"hawx.me/code/indieauth/v2"
-	"hawx.me/code/riviera/subscriptions/opml"
-	"hawx.me/code/serve"
 )
 
 func printHelp() {
@@ -24,24 +32,80 @@ func printHelp() {
 
 Arboretum is a feed aggregator.
 
+Options can also be given as ARBORETUM_* environment variables, so --keep-items
+is ARBORETUM_KEEP_ITEMS, or in the JSON config file given by --config, as an
+object such as {"keep-items": 10, "private": true}. Options on the command line
+take precedence over the environment, which takes precedence over the config
+file.
+
+	--config PATH
+		Read options from the JSON file at the given path.
+
 	--refresh DUR='6h'
 		Time to refresh feeds after. This is the default used, but if
 		advice is given in the feed itself it may be ignored.
 
+	--min-refresh DUR='30m'
+	--max-refresh DUR='24h'
+		Bounds on the refresh advised by a feed, through <ttl>,
+		sy:updatePeriod, Cache-Control, Expires or Retry-After. Advice
+		within them is followed over --refresh, whether shorter or
+		longer.
+
+	--websub-refresh DUR='24h'
+		Time to refresh feeds after while a WebSub hub is pushing their
+		updates. Hubs are subscribed to with a callback under --url.
+
+	--max-backoff DUR='48h'
+		Longest time to wait between fetches of a failing feed. Each
+		consecutive failure doubles the wait until this is reached.
+
+	--dead-after DUR='168h'
+		Mark feeds that have been failing for this long as dead.
+
+	--concurrency N=8
+		Maximum number of feeds to fetch at once, 0 for no limit.
+
+	--host-concurrency N=2
+		Maximum number of feeds to fetch at once from a single host, 0
+		for no limit.
+
+	--jitter DUR='5m'
+		Spread the first fetch of feeds that are due at startup over
+		this period.
+
+	--keep-items N=7
+		Number of items to keep for each feed, 0 keeps all of them.
+
+	--keep-days N=0
+		Number of days to keep items for, 0 keeps them regardless of
+		age. The newest item of a feed is always kept.
+
 	--private
 		Prevent showing any feeds when not signed in.
 
 	--db PATH=':memory:'
-		Use the sqlitedb file at the given path.
+		Use the sqlitedb file at the given path, or a PostgreSQL database when
+		given a postgres:// URL.
 
 	--url URL='http://localhost:8080/'
 		URL arboretum is hosted at.
 
 	--secret BASE64
-		Base64 string to use for the cookie secret.
+		Base64 string to use for the cookie secret. If not given a secret
+		is generated on first run and kept in the database.
+
+	--dev
+		Allow the secret used for development, which must not be used
+		for a real deployment.
+
+	--allow URLS
+		Comma separated profile URLs that may sign in with IndieAuth.
+		Each gets their own garden at /u/NAME/ the first time they
+		sign in, where NAME is taken from their URL.
 
 	--me URL
-		Your profile URL used for authenticating with IndieAuth.
+		The same as --allow, kept for when there was a single user.
 
 	--web PATH='web'
 		Path to the 'web' directory.
@@ -50,7 +114,64 @@ Arboretum is a feed aggregator.
 		Serve on given port.
 
 	--socket SOCK
-		Serve at given socket, instead.`)
+		Serve at given socket, instead.
+
+	--shutdown-timeout DUR='30s'
+		On SIGINT or SIGTERM, time to wait for requests and fetches in
+		progress to finish before exiting.
+
+	--metrics-addr ADDR
+		Serve Prometheus metrics at /metrics on the given address, such
+		as 'localhost:9100', instead of alongside everything else.
+
+Commands:
+
+	These work on the --db file without starting the server, and exit with a
+	non-zero status if they fail. Those that change subscriptions act for the
+	user given by --user NAME, which can be left out when there is only one.
+	Subscriptions added before anyone has signed in go to whoever signs in
+	first.
+
+	list
+		List the feeds the user subscribes to, with when each was last
+		updated and whether fetching it is failing.
+
+	add URL
+		Subscribe to the feed at URL, or the feed offered by the website
+		at URL.
+
+	remove URL
+		Unsubscribe from the feed at URL.
+
+	import FILE
+		Subscribe to the feeds listed in the OPML file, keeping any folders
+		they are nested in.
+
+	export
+		Write the subscriptions as OPML to stdout.
+
+	users
+		List the users with the profile URL each signs in with.
+
+	refresh URL
+		Fetch the feed at URL now and print the items it contains.
+
+	prune
+		Remove the items that fall outside of --keep-items and
+		--keep-days.
+
+	retention URL N|Nd|default
+		Keep only the newest N items of the feed at URL, or the items
+		from the last N days, instead of following --keep-items and
+		--keep-days. The newest item is always kept. Give 'default' to
+		go back to using the options.
+
+	stats
+		Print counts of the feeds and items stored.
+
+	migrate [--dry-run]
+		Apply pending database migrations. With --dry-run the pending
+		migrations are listed but not applied.`)
 }
 
 func addSubs(
@@ -76,7 +197,9 @@ func addSubs(
 	return nil
 }
 
-func importOpml(ctx context.Context, path, dbPath string) (int, error) {
+// importOpml subscribes the user called userName, or the only user if empty,
+// to the feeds in the OPML file at path.
+func importOpml(ctx context.Context, path, dbPath, userName string) (int, error) {
 	doc, err := opml.Load(path)
 	if err != nil {
 		return 0, err
@@ -89,35 +212,118 @@ func importOpml(ctx context.Context, path, dbPath string) (int, error) {
 	}
 	defer db.Close()
 
-	oks := 0
-	for _, item := range doc.Body.Outline {
-		if err := db.Subscribe(ctx, item.XMLURL); err != nil {
+	user, err := cli{db: db, user: userName}.owner(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	oks, failed := 0, 0
+	opml.Walk(doc.Body.Outline, func(folder []string, item opml.Outline) {
+		if err := db.AddSubscription(ctx, user.ID, data.Subscription{
+			URL:        item.XMLURL,
+			WebsiteURL: item.HTMLURL,
+			Title:      item.Name(),
+			Category:   folder,
+		}); err != nil {
 			slog.Error("add subscription", slog.String("sub", item.XMLURL), slog.Any("err", err))
+			failed++
 		} else {
 			oks++
 		}
+	})
+
+	if failed > 0 {
+		return oks, fmt.Errorf("%d feeds could not be added", failed)
 	}
 
 	return oks, nil
 }
 
+// runCommand runs a command against the database at dbPath.
+func runCommand(ctx context.Context, dbPath string, retention data.Retention, c cli, args []string) error {
+	db, err := data.Open(dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	db.SetRetention(retention)
+	c.db = db
+
+	return c.run(ctx, args)
+}
+
+func migrate(ctx context.Context, dbPath string, dryRun bool) error {
+	db, err := data.OpenWithoutMigrating(dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	version, err := db.SchemaVersion(ctx)
+	if err != nil {
+		return err
+	}
+
+	pending, err := db.PendingMigrations(ctx)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("schema version %d, %d pending\n", version, len(pending))
+	for _, migration := range pending {
+		fmt.Printf("  %d\t%s\n", migration.Version, migration.Name)
+	}
+
+	if dryRun || len(pending) == 0 {
+		return nil
+	}
+
+	migrated, err := data.Open(dbPath)
+	if err != nil {
+		return err
+	}
+
+	return migrated.Close()
+}
+
 func main() {
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
 	var (
-		refresh = flag.String("refresh", "6h", "")
-		private = flag.Bool("private", false, "")
+		refresh    = flag.String("refresh", "6h", "")
+		minRefresh = flag.String("min-refresh", "30m", "")
+		maxRefresh = flag.String("max-refresh", "24h", "")
+		maxBackoff = flag.String("max-backoff", "48h", "")
+		webSub     = flag.String("websub-refresh", "24h", "")
+		deadAfter  = flag.String("dead-after", "168h", "")
+		jitter     = flag.String("jitter", "5m", "")
+
+		concurrency     = flag.Int("concurrency", 8, "")
+		hostConcurrency = flag.Int("host-concurrency", 2, "")
+
+		keepItems = flag.Int("keep-items", data.DefaultRetention.Items, "")
+		keepDays  = flag.Int("keep-days", data.DefaultRetention.Days, "")
+		private   = flag.Bool("private", false, "")
 
 		dbPath = flag.String("db", ":memory:", "")
 
 		url    = flag.String("url", "http://localhost:8080", "")
-		secret = flag.String("secret", "GpgGqpnfFkpjgXj7u3RCdKkoOf/tQqbHkOuuys90Ds4=", "")
+		secret = flag.String("secret", "", "")
+		dev    = flag.Bool("dev", false, "")
+		allow  = flag.String("allow", "", "")
 		me     = flag.String("me", "", "")
+		user   = flag.String("user", "", "")
+
+		config = flag.String("config", "", "")
 
 		webPath = flag.String("web", "web", "")
 		port    = flag.String("port", "8080", "")
 		socket  = flag.String("socket", "", "")
+
+		shutdownTimeout = flag.String("shutdown-timeout", "30s", "")
+		metricsAddr     = flag.String("metrics-addr", "", "")
 	)
 
 	flag.Usage = func() { printHelp() }
@@ -126,61 +332,186 @@ func main() {
 	logger := slog.New(slog.NewTextHandler(os.Stderr, nil))
 	slog.SetDefault(logger)
 
-	if flag.Arg(0) == "import" {
+	configPath := *config
+	if configPath == "" {
+		configPath = os.Getenv(envName("config"))
+	}
+	if err := configure(flag.CommandLine, configPath, os.LookupEnv); err != nil {
+		slog.Error("configure", slog.Any("err", err))
+		os.Exit(1)
+	}
+
+	cacheTimeout, err := time.ParseDuration(*refresh)
+	if err != nil {
+		slog.Error("parse --refresh", slog.Any("err", err))
+		return
+	}
+
+	minRefreshDur, err := time.ParseDuration(*minRefresh)
+	if err != nil {
+		slog.Error("parse --min-refresh", slog.Any("err", err))
+		return
+	}
+
+	maxRefreshDur, err := time.ParseDuration(*maxRefresh)
+	if err != nil {
+		slog.Error("parse --max-refresh", slog.Any("err", err))
+		return
+	}
+
+	maxBackoffDur, err := time.ParseDuration(*maxBackoff)
+	if err != nil {
+		slog.Error("parse --max-backoff", slog.Any("err", err))
+		return
+	}
+
+	webSubDur, err := time.ParseDuration(*webSub)
+	if err != nil {
+		slog.Error("parse --websub-refresh", slog.Any("err", err))
+		return
+	}
+
+	deadAfterDur, err := time.ParseDuration(*deadAfter)
+	if err != nil {
+		slog.Error("parse --dead-after", slog.Any("err", err))
+		return
+	}
+
+	jitterDur, err := time.ParseDuration(*jitter)
+	if err != nil {
+		slog.Error("parse --jitter", slog.Any("err", err))
+		return
+	}
+
+	shutdownTimeoutDur, err := time.ParseDuration(*shutdownTimeout)
+	if err != nil {
+		slog.Error("parse --shutdown-timeout", slog.Any("err", err))
+		return
+	}
+
+	options := []garden.Option{
+		garden.WithRefreshBounds(minRefreshDur, maxRefreshDur),
+		garden.WithBackoff(maxBackoffDur, deadAfterDur),
+		garden.WithConcurrency(*concurrency, *hostConcurrency),
+		garden.WithJitter(jitterDur),
+	}
+	retention := data.Retention{Items: *keepItems, Days: *keepDays}
+
+	switch flag.Arg(0) {
+	case "":
+
+	case "import":
 		file := flag.Arg(1)
 		fmt.Println("importing ", file)
 
-		n, err := importOpml(ctx, file, *dbPath)
+		n, err := importOpml(ctx, file, *dbPath, *user)
 		if err != nil {
-			slog.Error("import opml", slog.Any("err", err))
-		} else {
-			slog.Info("import opml", slog.Int("added", n))
+			slog.Error("import opml", slog.Int("added", n), slog.Any("err", err))
+			os.Exit(1)
+		}
+		slog.Info("import opml", slog.Int("added", n))
+		return
+
+	case "migrate":
+		flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+		dryRun := flags.Bool("dry-run", false, "")
+		flags.Parse(flag.Args()[1:])
+
+		if err := migrate(ctx, *dbPath, *dryRun); err != nil {
+			slog.Error("migrate", slog.Any("err", err))
+			os.Exit(1)
+		}
+		return
+
+	default:
+		if err := runCommand(ctx, *dbPath, retention, cli{
+			out:     os.Stdout,
+			client:  http.DefaultClient,
+			user:    *user,
+			refresh: cacheTimeout,
+			options: options,
+		}, flag.Args()); err != nil {
+			slog.Error(flag.Arg(0), slog.Any("err", err))
+			os.Exit(1)
 		}
 		return
 	}
 
-	if *me == "" {
-		slog.Error("--me must be specified")
+	allowed := allowList(*allow, *me)
+	if len(allowed) == 0 {
+		slog.Error("--allow must be specified")
 		os.Exit(1)
 	}
 
-	session, err := indieauth.NewSessions(*secret, &indieauth.Config{
-		ClientID:    *url,
-		RedirectURL: *url + "/callback",
-	})
+	db, err := data.Open(*dbPath)
 	if err != nil {
-		slog.Error("new indieauth session", slog.Any("err", err))
+		slog.Error("open database", slog.Any("err", err))
 		return
 	}
+	// left open if feeds are still being written to it on exit
+	feedsWriting := false
+	defer func() {
+		if !feedsWriting {
+			db.Close()
+		}
+	}()
 
-	cacheTimeout, err := time.ParseDuration(*refresh)
+	sessionSecret, err := cookieSecret(ctx, db, *secret, *dev)
 	if err != nil {
-		slog.Error("parse --refresh", slog.Any("err", err))
-		return
+		slog.Error("cookie secret", slog.Any("err", err))
+		os.Exit(1)
 	}
 
-	db, err := data.Open(*dbPath)
+	session, err := indieauth.NewSessions(sessionSecret, &indieauth.Config{
+		ClientID:    *url,
+		RedirectURL: *url + "/callback",
+	})
 	if err != nil {
-		slog.Error("open database", slog.Any("err", err))
+		slog.Error("new indieauth session", slog.Any("err", err))
 		return
 	}
-	defer db.Close()
 
-	garden := garden.New(db, cacheTimeout)
+	db.SetRetention(retention)
+
+	garden := garden.New(db, cacheTimeout,
+		append(options, garden.WithWebSub(*url, webSubDur))...)
+
+	// the garden is stopped only once the server has, as handlers may still
+	// be waiting on it
+	gardenCtx, stopGarden := context.WithCancel(context.Background())
+	defer stopGarden()
 
+	gardenDone := make(chan struct{})
 	go func() {
-		garden.Run(ctx)
+		garden.Run(gardenCtx)
+		close(gardenDone)
 	}()
 
 	if err := addSubs(ctx, db, garden); err != nil {
 		slog.Error("add subscriptions", slog.Any("err", err))
 		return
 	}
+	garden.Loaded()
+
+	accounts := accounts{db: db, allowed: allowed}
+
+	signedInUser := func(r *http.Request) (data.User, bool) {
+		response, ok := session.SignedIn(r)
+		if !ok {
+			return data.User{}, false
+		}
+
+		user, ok, err := accounts.user(r.Context(), response.Me)
+		if err != nil {
+			slog.Error("find signed in user", slog.String("me", response.Me), slog.Any("err", err))
+		}
+		return user, ok
+	}
 
 	choose := func(a, b http.Handler) http.HandlerFunc {
 		return func(w http.ResponseWriter, r *http.Request) {
-			if response, ok := session.SignedIn(r); ok && response.Me == *me {
-				a.ServeHTTP(w, r)
+			if user, ok := signedInUser(r); ok {
+				a.ServeHTTP(w, r.WithContext(users.With(r.Context(), user)))
 			} else {
 				b.ServeHTTP(w, r)
 			}
@@ -191,16 +522,129 @@ func main() {
 		return choose(a, http.NotFoundHandler())
 	}
 
-	if *private {
-		http.HandleFunc("/", choose(
+	// visiting serves the garden of user to a visitor that is not user. When
+	// private only those signed in may see it, others are given denied.
+	visiting := func(w http.ResponseWriter, r *http.Request, user data.User, visitor, denied http.Handler) {
+		if *private {
+			if _, ok := signedInUser(r); !ok {
+				denied.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		visitor.ServeHTTP(w, r.WithContext(users.With(r.Context(), user)))
+	}
+
+	// top serves a garden at the top level: those signed in see their own,
+	// and visitors see the only user's, or are given denied if there are more.
+	top := func(owner, visitor, denied http.Handler) http.HandlerFunc {
+		return choose(owner, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			list, err := db.Users(r.Context())
+			if err != nil {
+				slog.Error("list users", slog.Any("err", err))
+				http.Error(w, "", http.StatusInternalServerError)
+				return
+			}
+
+			var user data.User
+			switch len(list) {
+			case 0:
+			case 1:
+				user = list[0]
+			default:
+				denied.ServeHTTP(w, r)
+				return
+			}
+
+			visiting(w, r, user, visitor, denied)
+		}))
+	}
+
+	// named serves the garden of the user in the path, which is only editable
+	// when signed in as them.
+	named := func(owner, visitor, denied http.Handler) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			user, ok, err := db.UserByName(r.Context(), r.PathValue("name"))
+			if err != nil {
+				slog.Error("find user", slog.String("name", r.PathValue("name")), slog.Any("err", err))
+				http.Error(w, "", http.StatusInternalServerError)
+				return
+			}
+			if !ok || user.Name == "" {
+				http.NotFound(w, r)
+				return
+			}
+
+			if signed, ok := signedInUser(r); ok && signed.ID == user.ID {
+				owner.ServeHTTP(w, r.WithContext(users.With(r.Context(), user)))
+				return
+			}
+
+			visiting(w, r, user, visitor, denied)
+		}
+	}
+
+	// everything below /u/{name}/ mirrors the top level
+	gardenRoutes := func(prefix string, serve func(owner, visitor, denied http.Handler) http.HandlerFunc, index http.Handler) {
+		notFound := http.NotFoundHandler()
+
+		http.HandleFunc(prefix+"/{$}", serve(
 			garden.Handler(true),
-			signin.Handler()))
+			garden.Handler(false),
+			index))
+		http.HandleFunc(prefix+"/garden.json", serve(
+			garden.JSONHandler(true),
+			garden.JSONHandler(false),
+			notFound))
+		http.HandleFunc(prefix+"/feed.atom", serve(
+			garden.AtomHandler(*url),
+			garden.AtomHandler(*url),
+			notFound))
+		http.HandleFunc(prefix+"/feed.rss", serve(
+			garden.RSSHandler(*url),
+			garden.RSSHandler(*url),
+			notFound))
+		http.HandleFunc(prefix+"/search", serve(
+			search.Handler(db, true),
+			search.Handler(db, false),
+			index))
+		http.HandleFunc(prefix+"/search.json", serve(
+			search.JSONHandler(db),
+			search.JSONHandler(db),
+			notFound))
+	}
+
+	signInPage := signin.Handler(len(allowed) > 1)
+	if *private {
+		gardenRoutes("", top, signInPage)
+		gardenRoutes("/u/{name}", named, signInPage)
 	} else {
-		http.HandleFunc("/", choose(
-			garden.Handler(true),
-			garden.Handler(false)))
+		gardenRoutes("", top, usersIndex(db))
+		gardenRoutes("/u/{name}", named, http.NotFoundHandler())
 	}
 
+	http.HandleFunc("/websub/", garden.WebSubHandler())
+
+	http.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		if err := db.Ping(r.Context()); err != nil {
+			slog.Error("healthz", slog.Any("err", err))
+			http.Error(w, "database unreachable", http.StatusServiceUnavailable)
+			return
+		}
+		io.WriteString(w, "ok\n")
+	})
+	http.HandleFunc("/readyz", garden.ReadyHandler())
+
+	http.HandleFunc("/status", signedIn(
+		garden.StatusHandler()))
+	http.HandleFunc("/status.json", signedIn(
+		garden.StatusJSONHandler()))
+
+	http.HandleFunc("/refresh", signedIn(
+		garden.RefreshHandler()))
+	http.HandleFunc("/refresh.json", signedIn(
+		garden.RefreshJSONHandler()))
+
 	http.Handle("/public/", http.StripPrefix("/public",
 		http.FileServer(http.Dir(*webPath+"/static"))))
 
@@ -211,10 +655,29 @@ func main() {
 		subscriptions.Remove(db, garden)))
 
 	http.HandleFunc("/add", signedIn(
-		subscriptions.Add(db, garden)))
+		subscriptions.Add(http.DefaultClient, db, garden)))
+
+	http.HandleFunc("/settings", signedIn(
+		subscriptions.Settings(db, garden)))
+
+	http.HandleFunc("/read", signedIn(
+		readstate.Mark(db)))
 
 	http.HandleFunc("/sign-in", func(w http.ResponseWriter, r *http.Request) {
-		if err := session.RedirectToSignIn(w, r, *me); err != nil {
+		me := r.FormValue("me")
+		if me == "" && len(allowed) == 1 {
+			me = allowed[0]
+		}
+		if me == "" {
+			signin.Handler(true).ServeHTTP(w, r)
+			return
+		}
+		if !accounts.allows(me) {
+			http.Error(w, "not allowed to sign in", http.StatusForbidden)
+			return
+		}
+
+		if err := session.RedirectToSignIn(w, r, canonicalMe(me)); err != nil {
 			slog.Error("sign-in", slog.Any("err", err))
 		}
 	})
@@ -231,5 +694,52 @@ func main() {
 		http.Redirect(w, r, "/", http.StatusFound)
 	})
 
-	serve.Serve(*port, *socket, http.DefaultServeMux)
+	if err := metrics.RegisterStore(db); err != nil {
+		slog.Error("register metrics", slog.Any("err", err))
+		return
+	}
+
+	if *metricsAddr == "" {
+		http.Handle("/metrics", metrics.Handler())
+	} else {
+		metricsListener, err := net.Listen("tcp", *metricsAddr)
+		if err != nil {
+			slog.Error("listen for metrics", slog.Any("err", err))
+			return
+		}
+
+		// scrapes read from the database, so must finish before it is closed
+		metricsCtx, stopMetrics := context.WithCancel(ctx)
+		var metricsServing sync.WaitGroup
+		defer func() {
+			stopMetrics()
+			metricsServing.Wait()
+		}()
+
+		metricsServing.Add(1)
+		go func() {
+			defer metricsServing.Done()
+			if err := serve(metricsCtx, metricsListener, metrics.Handler(), shutdownTimeoutDur); err != nil {
+				slog.Error("serve metrics", slog.Any("err", err))
+			}
+		}()
+	}
+
+	listener, err := listen(*port, *socket)
+	if err != nil {
+		slog.Error("listen", slog.Any("err", err))
+		return
+	}
+
+	if err := serve(ctx, listener, metrics.Instrument(http.DefaultServeMux), shutdownTimeoutDur); err != nil {
+		slog.Error("serve", slog.Any("err", err))
+	}
+
+	stopGarden()
+	select {
+	case <-gardenDone:
+	case <-time.After(shutdownTimeoutDur):
+		slog.Warn("timed out waiting for feeds to finish, leaving database open")
+		feedsWriting = true
+	}
 }
@@ -2,15 +2,36 @@ package main
 
 import (
 	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"flag"
+	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"hawx.me/code/arboretum/internal/data"
 	"hawx.me/code/arboretum/internal/garden"
 	"hawx.me/code/arboretum/internal/gardenjs"
+	"hawx.me/code/arboretum/internal/metrics"
+	"hawx.me/code/arboretum/internal/opml"
+	"hawx.me/code/arboretum/internal/page"
+	"hawx.me/code/arboretum/internal/readstate"
+	"hawx.me/code/arboretum/internal/search"
+	"hawx.me/code/arboretum/internal/subscriptions"
+	"hawx.me/code/arboretum/internal/users"
 	"hawx.me/code/assert"
 )
 
@@ -40,6 +61,74 @@ const (
 		<updated>2003-11-10T17:23:02Z</updated>
 	</entry>
 </feed>`
+	jsonFeedTwoItem = `{
+	"version": "https://jsonfeed.org/version/1.1",
+	"title": "Some title",
+	"home_page_url": "https://example.com/",
+	"items": [
+		{
+			"id": "1",
+			"url": "/posts/1",
+			"title": "First title",
+			"date_published": "2003-11-09T17:23:02Z"
+		},
+		{
+			"id": 2,
+			"url": "/posts/2",
+			"external_url": "https://example.org/elsewhere",
+			"content_text": "No title here",
+			"date_published": "2003-11-10T17:23:02Z"
+		}
+	]
+}`
+	rssWithContent = `<rss version="2.0">
+	<channel>
+		<title>Some title</title>
+		<link>http://example.com/</link>
+		<item>
+			<title>First title</title>
+			<guid>1</guid>
+			<link>http://example.com/posts/1</link>
+			<pubDate>Sun, 09 Nov 2003 17:23:02 +0000</pubDate>
+			<author>jane@example.com (Jane)</author>
+			<description>&lt;p onclick="steal()"&gt;Hello &lt;a href="javascript:steal()"&gt;there&lt;/a&gt; &lt;a href="/about"&gt;about&lt;/a&gt;&lt;/p&gt;&lt;script&gt;steal()&lt;/script&gt;&lt;img src="/cat.png" onerror="steal()"&gt;</description>
+			<enclosure url="/episode.mp3" type="audio/mpeg" length="1234" />
+		</item>
+	</channel>
+</rss>`
+	atomWithHub = `<feed xmlns="http://www.w3.org/2005/Atom">
+	<title type="text">Some title</title>
+	<id>http://www.example.com/feed/atom/</id>
+	<link rel="hub" href="%s" />
+	<link rel="self" href="%s" />
+	<updated>2099-11-09T17:23:12Z</updated>
+	<entry>
+		<title>First title</title>
+		<id>1</id>
+		<updated>2003-11-09T17:23:02Z</updated>
+	</entry>
+</feed>`
+	atomPushed = `<feed xmlns="http://www.w3.org/2005/Atom">
+	<title type="text">Some title</title>
+	<id>http://www.example.com/feed/atom/</id>
+	<updated>2099-11-09T17:23:12Z</updated>
+	<entry>
+		<title>%s</title>
+		<id>%s</id>
+		<updated>2003-11-10T17:23:02Z</updated>
+	</entry>
+</feed>`
+	rssWithTTL = `<rss version="2.0">
+	<channel>
+		<title>Some title</title>
+		<ttl>120</ttl>
+		<item>
+			<title>First title</title>
+			<guid>1</guid>
+			<pubDate>Sun, 09 Nov 2003 17:23:02 GMT</pubDate>
+		</item>
+	</channel>
+</rss>`
 )
 
 type handlerQueue struct {
@@ -51,13 +140,10 @@ func newHandlerQueue(handlers ...http.HandlerFunc) http.Handler {
 	return &handlerQueue{handlers: handlers}
 }
 
+// ServeHTTP calls each handler in turn, repeating the last handler for any
+// requests made after the queue is exhausted.
 func (q *handlerQueue) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	if q.idx > len(q.handlers) {
-		w.WriteHeader(http.StatusNotFound)
-		return
-	}
-
-	q.handlers[q.idx].ServeHTTP(w, r)
+	q.handlers[min(q.idx, len(q.handlers)-1)].ServeHTTP(w, r)
 	q.idx++
 }
 
@@ -68,12 +154,29 @@ func contextWithDelayedCancel() (context.Context, context.CancelFunc) {
 		// call in go routine so http handler finishes
 		go func() {
 			// don't cancel immediately as everything still needs to process
-			time.Sleep(time.Millisecond)
+			time.Sleep(10 * time.Millisecond)
 			cancel()
 		}()
 	}
 }
 
+// testUser returns the user that tests subscribe feeds for.
+func testUser(t *testing.T, db *data.DB) data.User {
+	user, err := db.UnclaimedUser(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return user
+}
+
+// asUser serves h as if the request was for the garden of user.
+func asUser(user data.User, h http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h.ServeHTTP(w, r.WithContext(users.With(r.Context(), user)))
+	}
+}
+
 func TestGardenLatestWithNoFeeds(t *testing.T) {
 	db, err := data.Open(":memory:")
 	if err != nil {
@@ -82,9 +185,11 @@ func TestGardenLatestWithNoFeeds(t *testing.T) {
 	}
 	defer db.Close()
 
+	user := testUser(t, db)
+
 	garden := garden.New(db, time.Millisecond)
 
-	result, err := garden.Latest(context.Background())
+	result, err := garden.Latest(context.Background(), user.ID)
 	if err != nil {
 		t.Error(err)
 		return
@@ -110,7 +215,9 @@ func TestGardenLatestWithFeed(t *testing.T) {
 	}
 	defer db.Close()
 
-	if err := db.Subscribe(ctx, feed.URL); err != nil {
+	user := testUser(t, db)
+
+	if err := db.Subscribe(ctx, user.ID, feed.URL); err != nil {
 		t.Error(err)
 		return
 	}
@@ -123,7 +230,7 @@ func TestGardenLatestWithFeed(t *testing.T) {
 
 	<-ctx.Done()
 
-	result, err := garden.Latest(context.Background())
+	result, err := garden.Latest(context.Background(), user.ID)
 	if err != nil {
 		t.Error(err)
 		return
@@ -134,6 +241,7 @@ func TestGardenLatestWithFeed(t *testing.T) {
 		Title:     "Some title",
 		UpdatedAt: time.Date(2003, time.November, 9, 17, 23, 2, 0, time.UTC),
 		Items: []gardenjs.Item{{
+			Key:       "1",
 			PermaLink: feed.URL,
 			Title:     "First title",
 			PubDate:   time.Date(2003, time.November, 9, 17, 23, 2, 0, time.UTC),
@@ -165,7 +273,9 @@ func TestGardenLatestWithFeedAndUpdate(t *testing.T) {
 	}
 	defer db.Close()
 
-	if err := db.Subscribe(ctx, feed.URL); err != nil {
+	user := testUser(t, db)
+
+	if err := db.Subscribe(ctx, user.ID, feed.URL); err != nil {
 		t.Error(err)
 		return
 	}
@@ -178,7 +288,7 @@ func TestGardenLatestWithFeedAndUpdate(t *testing.T) {
 
 	<-ctx.Done()
 
-	result, err := garden.Latest(context.Background())
+	result, err := garden.Latest(context.Background(), user.ID)
 	if err != nil {
 		t.Error(err)
 		return
@@ -189,11 +299,13 @@ func TestGardenLatestWithFeedAndUpdate(t *testing.T) {
 		Title:     "Some title",
 		UpdatedAt: time.Date(2003, time.November, 10, 17, 23, 2, 0, time.UTC),
 		Items: []gardenjs.Item{{
+			Key:       "2",
 			PermaLink: feed.URL,
 			Title:     "Second title",
 			PubDate:   time.Date(2003, time.November, 10, 17, 23, 2, 0, time.UTC),
 			Link:      feed.URL,
 		}, {
+			Key:       "1",
 			PermaLink: feed.URL,
 			Title:     "First title",
 			PubDate:   time.Date(2003, time.November, 9, 17, 23, 2, 0, time.UTC),
@@ -226,7 +338,9 @@ func TestGardenLatestWithFeedAndETagPreventingUpdate(t *testing.T) {
 	}
 	defer db.Close()
 
-	if err := db.Subscribe(ctx, feed.URL); err != nil {
+	user := testUser(t, db)
+
+	if err := db.Subscribe(ctx, user.ID, feed.URL); err != nil {
 		t.Error(err)
 		return
 	}
@@ -239,7 +353,7 @@ func TestGardenLatestWithFeedAndETagPreventingUpdate(t *testing.T) {
 
 	<-ctx.Done()
 
-	result, err := garden.Latest(context.Background())
+	result, err := garden.Latest(context.Background(), user.ID)
 	if err != nil {
 		t.Error(err)
 		return
@@ -250,6 +364,7 @@ func TestGardenLatestWithFeedAndETagPreventingUpdate(t *testing.T) {
 		Title:     "Some title",
 		UpdatedAt: time.Date(2003, time.November, 9, 17, 23, 2, 0, time.UTC),
 		Items: []gardenjs.Item{{
+			Key:       "1",
 			PermaLink: feed.URL,
 			Title:     "First title",
 			PubDate:   time.Date(2003, time.November, 9, 17, 23, 2, 0, time.UTC),
@@ -265,10 +380,11 @@ func TestGardenLatestWithFeedAndIfModifiedSincePreventingUpdate(t *testing.T) {
 	feed := httptest.NewServer(newHandlerQueue(
 		func(w http.ResponseWriter, r *http.Request) {
 			w.Header().Set("Content-Type", "application/xml")
+			w.Header().Set("Last-Modified", "Wed, 21 Oct 2015 07:28:00 GMT")
 			io.WriteString(w, atomOneItem)
 		},
 		func(w http.ResponseWriter, r *http.Request) {
-			assert.Equal(t, r.Header.Get("If-Modified-Since"), time.Now().Format(time.RFC1123))
+			assert.Equal(t, r.Header.Get("If-Modified-Since"), "Wed, 21 Oct 2015 07:28:00 GMT")
 			w.WriteHeader(http.StatusNotModified)
 			cancel()
 		},
@@ -281,7 +397,9 @@ func TestGardenLatestWithFeedAndIfModifiedSincePreventingUpdate(t *testing.T) {
 	}
 	defer db.Close()
 
-	if err := db.Subscribe(ctx, feed.URL); err != nil {
+	user := testUser(t, db)
+
+	if err := db.Subscribe(ctx, user.ID, feed.URL); err != nil {
 		t.Error(err)
 		return
 	}
@@ -294,7 +412,7 @@ func TestGardenLatestWithFeedAndIfModifiedSincePreventingUpdate(t *testing.T) {
 
 	<-ctx.Done()
 
-	result, err := garden.Latest(context.Background())
+	result, err := garden.Latest(context.Background(), user.ID)
 	if err != nil {
 		t.Error(err)
 		return
@@ -305,6 +423,7 @@ func TestGardenLatestWithFeedAndIfModifiedSincePreventingUpdate(t *testing.T) {
 		Title:     "Some title",
 		UpdatedAt: time.Date(2003, time.November, 9, 17, 23, 2, 0, time.UTC),
 		Items: []gardenjs.Item{{
+			Key:       "1",
 			PermaLink: feed.URL,
 			Title:     "First title",
 			PubDate:   time.Date(2003, time.November, 9, 17, 23, 2, 0, time.UTC),
@@ -312,3 +431,2207 @@ func TestGardenLatestWithFeedAndIfModifiedSincePreventingUpdate(t *testing.T) {
 		}},
 	}}, result.Feeds)
 }
+
+func TestGardenLatestWithValidatorsFromPreviousRun(t *testing.T) {
+	ctx, cancel := contextWithDelayedCancel()
+	defer cancel()
+
+	feed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, r.Header.Get("If-None-Match"), "an-etag")
+		assert.Equal(t, r.Header.Get("If-Modified-Since"), "Wed, 21 Oct 2015 07:28:00 GMT")
+		w.WriteHeader(http.StatusNotModified)
+		cancel()
+	}))
+
+	db, err := data.Open(":memory:")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer db.Close()
+
+	user := testUser(t, db)
+
+	if err := db.Subscribe(ctx, user.ID, feed.URL); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := db.SetValidators(ctx, feed.URL, "an-etag", "Wed, 21 Oct 2015 07:28:00 GMT"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	garden := garden.New(db, time.Millisecond)
+	go func() {
+		garden.Run(ctx)
+	}()
+	garden.Subscribe(ctx, feed.URL)
+
+	<-ctx.Done()
+}
+
+func TestGardenLatestWithJSONFeed(t *testing.T) {
+	ctx, cancel := contextWithDelayedCancel()
+	defer cancel()
+
+	feed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/feed+json")
+		io.WriteString(w, jsonFeedTwoItem)
+		cancel()
+	}))
+
+	db, err := data.Open(":memory:")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer db.Close()
+
+	user := testUser(t, db)
+
+	if err := db.Subscribe(ctx, user.ID, feed.URL); err != nil {
+		t.Error(err)
+		return
+	}
+
+	garden := garden.New(db, time.Hour)
+	go func() {
+		garden.Run(ctx)
+	}()
+	garden.Subscribe(ctx, feed.URL)
+
+	<-ctx.Done()
+
+	result, err := garden.Latest(context.Background(), user.ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	assert.Equal(t, []gardenjs.Feed{{
+		URL:        feed.URL,
+		WebsiteURL: "https://example.com/",
+		Title:      "Some title",
+		UpdatedAt:  time.Date(2003, time.November, 10, 17, 23, 2, 0, time.UTC),
+		Items: []gardenjs.Item{{
+			Key:       "2",
+			PermaLink: feed.URL + "/posts/2",
+			Title:     "a post",
+			PubDate:   time.Date(2003, time.November, 10, 17, 23, 2, 0, time.UTC),
+			Link:      "https://example.org/elsewhere",
+			Summary:   "No title here",
+			Content:   "<p>No title here</p>",
+		}, {
+			Key:       "1",
+			PermaLink: feed.URL + "/posts/1",
+			Title:     "First title",
+			PubDate:   time.Date(2003, time.November, 9, 17, 23, 2, 0, time.UTC),
+			Link:      feed.URL + "/posts/1",
+		}},
+	}}, result.Feeds)
+}
+
+func TestGardenLatestWithSanitizedContent(t *testing.T) {
+	ctx, cancel := contextWithDelayedCancel()
+	defer cancel()
+
+	feed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, rssWithContent)
+		cancel()
+	}))
+
+	db, err := data.Open(":memory:")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer db.Close()
+
+	user := testUser(t, db)
+
+	if err := db.Subscribe(ctx, user.ID, feed.URL); err != nil {
+		t.Error(err)
+		return
+	}
+
+	garden := garden.New(db, time.Hour)
+	go func() {
+		garden.Run(ctx)
+	}()
+	garden.Subscribe(ctx, feed.URL)
+
+	<-ctx.Done()
+
+	result, err := garden.Latest(context.Background(), user.ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if !assert.Len(t, result.Feeds, 1) || !assert.Len(t, result.Feeds[0].Items, 1) {
+		return
+	}
+
+	item := result.Feeds[0].Items[0]
+	assert.Equal(t, "Hello there about", item.Summary)
+	assert.Equal(t, `<p>Hello <a rel="noopener noreferrer nofollow">there</a> <a href="http://example.com/about" rel="noopener noreferrer nofollow">about</a></p><img src="http://example.com/cat.png"/>`, item.Content)
+	assert.Equal(t, "jane@example.com (Jane)", item.Author)
+	assert.Equal(t, &gardenjs.Enclosure{
+		URL:    feed.URL + "/episode.mp3",
+		Type:   "audio/mpeg",
+		Length: 1234,
+	}, item.Enclosure)
+
+	var rendered strings.Builder
+	if _, err := page.Garden(false, "garden", result.Feeds).WriteTo(&rendered); err != nil {
+		t.Error(err)
+		return
+	}
+
+	assert.True(t, strings.Contains(rendered.String(), `<details class="preview">`))
+	assert.True(t, strings.Contains(rendered.String(), item.Content))
+	assert.False(t, strings.Contains(rendered.String(), "<script>"))
+	assert.False(t, regexp.MustCompile(`\son[a-z]+=`).MatchString(rendered.String()))
+}
+
+func TestGardenSubscribesToHub(t *testing.T) {
+	ctx, cancel := contextWithDelayedCancel()
+	defer cancel()
+
+	var webSub http.HandlerFunc
+	arboretum := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		webSub(w, r)
+	}))
+	defer arboretum.Close()
+
+	var hubURL string
+	feed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, atomWithHub, hubURL, "http://"+r.Host)
+	}))
+	defer feed.Close()
+
+	hub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "subscribe", r.FormValue("hub.mode"))
+		assert.Equal(t, feed.URL, r.FormValue("hub.topic"))
+
+		callback := r.FormValue("hub.callback")
+		secret := r.FormValue("hub.secret")
+
+		resp, err := http.Get(callback + "?" + url.Values{
+			"hub.mode":          {"subscribe"},
+			"hub.topic":         {feed.URL},
+			"hub.challenge":     {"a-challenge"},
+			"hub.lease_seconds": {"864000"},
+		}.Encode())
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		challenge, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		assert.Equal(t, "a-challenge", string(challenge))
+
+		w.WriteHeader(http.StatusAccepted)
+
+		deliver := func(body, signature string) {
+			req, _ := http.NewRequest("POST", callback, strings.NewReader(body))
+			req.Header.Set("Content-Type", "application/atom+xml")
+			req.Header.Set("X-Hub-Signature", signature)
+
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			resp.Body.Close()
+			assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+		}
+
+		go func() {
+			forged := fmt.Sprintf(atomPushed, "Forged title", "3")
+			deliver(forged, "sha256="+strings.Repeat("00", sha256.Size))
+
+			pushed := fmt.Sprintf(atomPushed, "Pushed title", "2")
+			mac := hmac.New(sha256.New, []byte(secret))
+			mac.Write([]byte(pushed))
+			deliver(pushed, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+
+			cancel()
+		}()
+	}))
+	defer hub.Close()
+	hubURL = hub.URL
+
+	db, err := data.Open(":memory:")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer db.Close()
+
+	user := testUser(t, db)
+
+	if err := db.Subscribe(ctx, user.ID, feed.URL); err != nil {
+		t.Error(err)
+		return
+	}
+
+	garden := garden.New(db, time.Hour,
+		garden.WithWebSub(arboretum.URL, 24*time.Hour))
+	webSub = garden.WebSubHandler()
+
+	go func() {
+		garden.Run(ctx)
+	}()
+	garden.Subscribe(ctx, feed.URL)
+
+	<-ctx.Done()
+
+	result, err := garden.Latest(context.Background(), user.ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if !assert.Len(t, result.Feeds, 1) {
+		return
+	}
+
+	var titles []string
+	for _, item := range result.Feeds[0].Items {
+		titles = append(titles, item.Title)
+	}
+	assert.Equal(t, []string{"Pushed title", "First title"}, titles)
+
+	sub, err := db.HubSubscription(context.Background(), feed.URL)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	assert.True(t, sub.Active(time.Now()))
+
+	// polled as a safety net, rather than every hour
+	nextPoll, err := db.NextPoll(context.Background(), feed.URL)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	assert.True(t, time.Until(nextPoll) > 23*time.Hour+59*time.Minute)
+	assert.True(t, time.Until(nextPoll) <= 24*time.Hour)
+}
+
+func TestGardenSchedulesFromFeedAdvice(t *testing.T) {
+	ctx, cancel := contextWithDelayedCancel()
+	defer cancel()
+
+	feed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/rss+xml")
+		w.Header().Set("Cache-Control", "max-age=60")
+		io.WriteString(w, rssWithTTL)
+		cancel()
+	}))
+
+	db, err := data.Open(":memory:")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer db.Close()
+
+	user := testUser(t, db)
+
+	if err := db.Subscribe(ctx, user.ID, feed.URL); err != nil {
+		t.Error(err)
+		return
+	}
+
+	garden := garden.New(db, time.Millisecond,
+		garden.WithRefreshBounds(time.Minute, 24*time.Hour))
+	go func() {
+		garden.Run(ctx)
+	}()
+	garden.Subscribe(ctx, feed.URL)
+
+	<-ctx.Done()
+
+	nextPoll, err := db.NextPoll(context.Background(), feed.URL)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// the <ttl> of 2h is longer than the max-age so is preferred
+	assert.True(t, time.Until(nextPoll) > 119*time.Minute)
+	assert.True(t, time.Until(nextPoll) <= 120*time.Minute)
+}
+
+func TestGardenClampsAdviceToBounds(t *testing.T) {
+	testCases := map[string]struct {
+		cacheControl string
+		next         time.Duration
+	}{
+		"no advice":         {"", time.Hour},
+		"below min-refresh": {"max-age=60", 30 * time.Minute},
+		"below refresh":     {"max-age=2400", 40 * time.Minute},
+		"above refresh":     {"max-age=7200", 2 * time.Hour},
+		"above max-refresh": {"max-age=172800", 24 * time.Hour},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := contextWithDelayedCancel()
+			defer cancel()
+
+			feed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/xml")
+				if tc.cacheControl != "" {
+					w.Header().Set("Cache-Control", tc.cacheControl)
+				}
+				io.WriteString(w, atomOneItem)
+				cancel()
+			}))
+			defer feed.Close()
+
+			db, err := data.Open(":memory:")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer db.Close()
+
+			user := testUser(t, db)
+
+			if err := db.Subscribe(ctx, user.ID, feed.URL); err != nil {
+				t.Error(err)
+				return
+			}
+
+			garden := garden.New(db, time.Hour,
+				garden.WithRefreshBounds(30*time.Minute, 24*time.Hour),
+				garden.WithJitter(0))
+			go func() {
+				garden.Run(ctx)
+			}()
+			garden.Subscribe(ctx, feed.URL)
+
+			<-ctx.Done()
+
+			nextPoll, err := db.NextPoll(context.Background(), feed.URL)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			assert.True(t, time.Until(nextPoll) > tc.next-time.Minute)
+			assert.True(t, time.Until(nextPoll) <= tc.next)
+		})
+	}
+}
+
+func TestGardenSchedulesFromRetryAfterWithinBounds(t *testing.T) {
+	ctx, cancel := contextWithDelayedCancel()
+	defer cancel()
+
+	feed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "86400")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		cancel()
+	}))
+
+	db, err := data.Open(":memory:")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer db.Close()
+
+	user := testUser(t, db)
+
+	if err := db.Subscribe(ctx, user.ID, feed.URL); err != nil {
+		t.Error(err)
+		return
+	}
+
+	garden := garden.New(db, time.Millisecond,
+		garden.WithRefreshBounds(time.Minute, time.Hour),
+		garden.WithBackoff(time.Hour, 0))
+	go func() {
+		garden.Run(ctx)
+	}()
+	garden.Subscribe(ctx, feed.URL)
+
+	<-ctx.Done()
+
+	nextPoll, err := db.NextPoll(context.Background(), feed.URL)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	assert.True(t, time.Until(nextPoll) > 59*time.Minute)
+	assert.True(t, time.Until(nextPoll) <= time.Hour)
+}
+
+func TestGardenTracksFailures(t *testing.T) {
+	ctx, cancel := contextWithDelayedCancel()
+	defer cancel()
+
+	feed := httptest.NewServer(newHandlerQueue(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+		},
+		func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+			cancel()
+		},
+	))
+
+	db, err := data.Open(":memory:")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer db.Close()
+
+	user := testUser(t, db)
+
+	if err := db.Subscribe(ctx, user.ID, feed.URL); err != nil {
+		t.Error(err)
+		return
+	}
+
+	garden := garden.New(db, time.Millisecond,
+		garden.WithBackoff(time.Hour, time.Nanosecond))
+	go func() {
+		garden.Run(ctx)
+	}()
+	garden.Subscribe(ctx, feed.URL)
+
+	<-ctx.Done()
+
+	status, err := db.FeedStatus(context.Background(), feed.URL)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	assert.True(t, status.ErrorCount >= 2)
+	assert.Equal(t, "unexpected status 500 Internal Server Error", status.LastError)
+	assert.True(t, status.Dead)
+	assert.True(t, status.LastSuccessAt.IsZero())
+
+	nextPoll, err := db.NextPoll(context.Background(), feed.URL)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// 1ms doubled for each failure
+	assert.True(t, time.Until(nextPoll) <= time.Duration(1<<status.ErrorCount)*time.Millisecond)
+
+	// never having been fetched, the feed is still shown as dead
+	result, err := garden.Latest(context.Background(), user.ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(result.Feeds) != 1 {
+		t.Errorf("expected 1 feed, got %d", len(result.Feeds))
+		return
+	}
+	assert.Equal(t, feed.URL, result.Feeds[0].Title)
+	assert.True(t, result.Feeds[0].Dead)
+	assert.Equal(t, "unexpected status 500 Internal Server Error", result.Feeds[0].Error)
+	assert.Len(t, result.Feeds[0].Items, 0)
+}
+
+func TestGardenFollowsPermanentRedirect(t *testing.T) {
+	ctx, cancel := contextWithDelayedCancel()
+	defer cancel()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/old", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/older", http.StatusMovedPermanently)
+	})
+	mux.HandleFunc("/older", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/new", http.StatusPermanentRedirect)
+	})
+	mux.HandleFunc("/new", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/xml")
+		io.WriteString(w, atomOneItem)
+		cancel()
+	})
+	feed := httptest.NewServer(mux)
+
+	db, err := data.Open(":memory:")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer db.Close()
+
+	user := testUser(t, db)
+
+	if err := db.Subscribe(ctx, user.ID, feed.URL+"/old"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	garden := garden.New(db, time.Hour)
+	go func() {
+		garden.Run(ctx)
+	}()
+	garden.Subscribe(ctx, feed.URL+"/old")
+
+	<-ctx.Done()
+
+	subs, err := db.Subscriptions(context.Background())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	assert.Equal(t, []string{feed.URL + "/new"}, subs)
+
+	result, err := garden.Latest(context.Background(), user.ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	assert.Len(t, result.Feeds, 1)
+	assert.Equal(t, feed.URL+"/new", result.Feeds[0].URL)
+}
+
+func TestGardenKeepsURLForTemporaryRedirect(t *testing.T) {
+	ctx, cancel := contextWithDelayedCancel()
+	defer cancel()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/old", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/older", http.StatusMovedPermanently)
+	})
+	mux.HandleFunc("/older", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/new", http.StatusFound)
+	})
+	mux.HandleFunc("/new", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/xml")
+		io.WriteString(w, atomOneItem)
+		cancel()
+	})
+	feed := httptest.NewServer(mux)
+
+	db, err := data.Open(":memory:")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer db.Close()
+
+	user := testUser(t, db)
+
+	if err := db.Subscribe(ctx, user.ID, feed.URL+"/old"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	garden := garden.New(db, time.Hour)
+	go func() {
+		garden.Run(ctx)
+	}()
+	garden.Subscribe(ctx, feed.URL+"/old")
+
+	<-ctx.Done()
+
+	subs, err := db.Subscriptions(context.Background())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	assert.Equal(t, []string{feed.URL + "/old"}, subs)
+}
+
+func TestGardenStopsPollingWhenGone(t *testing.T) {
+	ctx, cancel := contextWithDelayedCancel()
+	defer cancel()
+
+	var requests atomic.Int32
+	feed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusGone)
+		cancel()
+	}))
+
+	db, err := data.Open(":memory:")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer db.Close()
+
+	user := testUser(t, db)
+
+	if err := db.Subscribe(ctx, user.ID, feed.URL); err != nil {
+		t.Error(err)
+		return
+	}
+
+	garden := garden.New(db, time.Millisecond)
+	go func() {
+		garden.Run(ctx)
+	}()
+	garden.Subscribe(ctx, feed.URL)
+
+	<-ctx.Done()
+
+	status, err := db.FeedStatus(context.Background(), feed.URL)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	assert.True(t, status.Gone)
+	assert.Equal(t, int32(1), requests.Load())
+}
+
+func TestGardenLimitsConcurrentFetchesPerHost(t *testing.T) {
+	ctx, cancel := contextWithDelayedCancel()
+	defer cancel()
+
+	const feeds = 6
+
+	var inflight, maxInflight, served atomic.Int32
+	feed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := inflight.Add(1)
+		for {
+			m := maxInflight.Load()
+			if n <= m || maxInflight.CompareAndSwap(m, n) {
+				break
+			}
+		}
+
+		time.Sleep(5 * time.Millisecond)
+		inflight.Add(-1)
+
+		w.Header().Set("Content-Type", "application/xml")
+		io.WriteString(w, atomOneItem)
+
+		if served.Add(1) == feeds {
+			cancel()
+		}
+	}))
+
+	db, err := data.Open(":memory:")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer db.Close()
+
+	user := testUser(t, db)
+
+	garden := garden.New(db, time.Hour,
+		garden.WithConcurrency(4, 2))
+	go func() {
+		garden.Run(ctx)
+	}()
+
+	for i := 0; i < feeds; i++ {
+		uri := fmt.Sprintf("%s/%d", feed.URL, i)
+		if err := db.Subscribe(ctx, user.ID, uri); err != nil {
+			t.Error(err)
+			return
+		}
+		garden.Subscribe(ctx, uri)
+	}
+
+	<-ctx.Done()
+
+	assert.Equal(t, int32(feeds), served.Load())
+	assert.Equal(t, int32(2), maxInflight.Load())
+}
+
+func TestAddDiscoversFeeds(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/feed", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/xml")
+		io.WriteString(w, atomOneItem)
+	})
+	mux.HandleFunc("/comments", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/xml")
+		io.WriteString(w, atomTwoItem)
+	})
+	mux.HandleFunc("/one", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		io.WriteString(w, `<html><head>
+<link rel="alternate" type="application/atom+xml" href="/feed">
+</head></html>`)
+	})
+	mux.HandleFunc("/two", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		io.WriteString(w, `<html><head>
+<link rel="alternate" type="application/atom+xml" href="/feed">
+<link rel="alternate" type="application/atom+xml" href="/comments">
+</head></html>`)
+	})
+	mux.HandleFunc("/json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		io.WriteString(w, `<html><head>
+<link rel="alternate" type="application/feed+json" href="/posts.json">
+</head></html>`)
+	})
+	mux.HandleFunc("/posts.json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/feed+json")
+		io.WriteString(w, jsonFeedTwoItem)
+	})
+	mux.HandleFunc("/blog/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		io.WriteString(w, `<html><head><title>No links</title></head></html>`)
+	})
+	mux.Handle("/moved", http.RedirectHandler("/feed", http.StatusMovedPermanently))
+	mux.Handle("/moving", http.RedirectHandler("/moved", http.StatusPermanentRedirect))
+	mux.Handle("/elsewhere", http.RedirectHandler("/feed", http.StatusFound))
+	mux.Handle("/moved-elsewhere", http.RedirectHandler("/elsewhere", http.StatusMovedPermanently))
+	site := httptest.NewServer(mux)
+	defer site.Close()
+
+	testCases := map[string]struct {
+		url    string
+		status int
+		subs   []string
+	}{
+		"feed": {
+			url:    site.URL + "/feed",
+			status: http.StatusFound,
+			subs:   []string{site.URL + "/feed"},
+		},
+		"page linking to feed": {
+			url:    site.URL + "/one",
+			status: http.StatusFound,
+			subs:   []string{site.URL + "/feed"},
+		},
+		"page linking to many feeds": {
+			url:    site.URL + "/two",
+			status: http.StatusOK,
+		},
+		"page linking to json feed": {
+			url:    site.URL + "/json",
+			status: http.StatusFound,
+			subs:   []string{site.URL + "/posts.json"},
+		},
+		"page at site with feed at common path": {
+			url:    site.URL + "/blog/",
+			status: http.StatusFound,
+			subs:   []string{site.URL + "/feed"},
+		},
+		"missing page": {
+			url:    site.URL + "/missing",
+			status: http.StatusBadRequest,
+		},
+		"feed permanently moved": {
+			url:    site.URL + "/moving",
+			status: http.StatusFound,
+			subs:   []string{site.URL + "/feed"},
+		},
+		"feed temporarily moved": {
+			url:    site.URL + "/elsewhere",
+			status: http.StatusFound,
+			subs:   []string{site.URL + "/elsewhere"},
+		},
+		"feed permanently moved then temporarily": {
+			url:    site.URL + "/moved-elsewhere",
+			status: http.StatusFound,
+			subs:   []string{site.URL + "/moved-elsewhere"},
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			db, err := data.Open(":memory:")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer db.Close()
+
+			user := testUser(t, db)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("POST", "/add", strings.NewReader("url="+tc.url))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+			asUser(user, subscriptions.Add(http.DefaultClient, db)).ServeHTTP(w, r)
+
+			assert.Equal(t, tc.status, w.Code)
+
+			subs, err := db.Subscriptions(context.Background())
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			assert.Equal(t, tc.subs, subs)
+		})
+	}
+}
+
+func TestGardenJSONHandler(t *testing.T) {
+	ctx := context.Background()
+
+	db, err := data.Open(":memory:")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer db.Close()
+
+	user := testUser(t, db)
+
+	if err := db.Subscribe(ctx, user.ID, "http://example.com/feed"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	pubDate := time.Date(2003, time.November, 9, 17, 23, 2, 0, time.UTC)
+	if err := db.UpdateFeed(ctx, data.Feed{
+		URL:        "http://example.com/feed",
+		WebsiteURL: "http://example.com",
+		Title:      "Some title",
+		UpdatedAt:  time.Now(),
+		Items: []data.FeedItem{{
+			Key:       "1",
+			PermaLink: "http://example.com/1",
+			PubDate:   pubDate,
+			Title:     "First title",
+			Link:      "http://example.com/1",
+		}},
+	}); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := db.SetFeedStatus(ctx, "http://example.com/feed", data.FeedStatus{ErrorCount: 1, LastError: "oops"}); err != nil {
+		t.Error(err)
+		return
+	}
+	if _, err := db.SetFeedSettings(ctx, user.ID, "http://example.com/feed", data.FeedSettings{Paused: true}); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := db.MarkItemRead(ctx, user.ID, "http://example.com/feed", "1"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	garden := garden.New(db, time.Millisecond)
+
+	get := func(signedIn bool) []gardenjs.Feed {
+		s := httptest.NewServer(asUser(user, garden.JSONHandler(signedIn)))
+		defer s.Close()
+
+		resp, err := http.Get(s.URL)
+		if err != nil {
+			t.Error(err)
+			return nil
+		}
+		defer resp.Body.Close()
+
+		assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+
+		var result gardenjs.Garden
+		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+			t.Error(err)
+		}
+		return result.Feeds
+	}
+
+	expected := gardenjs.Feed{
+		URL:        "http://example.com/feed",
+		WebsiteURL: "http://example.com",
+		Title:      "Some title",
+		UpdatedAt:  pubDate,
+		Items: []gardenjs.Item{{
+			Key:       "1",
+			PermaLink: "http://example.com/1",
+			Title:     "First title",
+			PubDate:   pubDate,
+			Link:      "http://example.com/1",
+		}},
+	}
+	assert.Equal(t, []gardenjs.Feed{expected}, get(false))
+
+	expected.Error = "oops"
+	expected.Paused = true
+	expected.Items[0].Read = true
+	assert.Equal(t, []gardenjs.Feed{expected}, get(true))
+}
+
+func TestGardenJSONHandlerWithCallback(t *testing.T) {
+	db, err := data.Open(":memory:")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer db.Close()
+
+	user := testUser(t, db)
+
+	garden := garden.New(db, time.Millisecond)
+	s := httptest.NewServer(asUser(user, garden.JSONHandler(true)))
+	defer s.Close()
+
+	resp, err := http.Get(s.URL + "?callback=handleGarden")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer resp.Body.Close()
+
+	assert.Equal(t, "application/javascript", resp.Header.Get("Content-Type"))
+
+	body, _ := io.ReadAll(resp.Body)
+	assert.True(t, strings.HasPrefix(string(body), "/**/handleGarden({"))
+	assert.True(t, strings.HasSuffix(string(body), ");"))
+
+	resp, err = http.Get(s.URL + "?callback=alert(1)")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	resp.Body.Close()
+
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestGardenAtomHandler(t *testing.T) {
+	ctx := context.Background()
+
+	db, err := data.Open(":memory:")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer db.Close()
+
+	user := testUser(t, db)
+
+	first := time.Date(2003, time.November, 9, 17, 23, 2, 0, time.UTC)
+	second := time.Date(2003, time.November, 10, 17, 23, 2, 0, time.UTC)
+
+	for _, feed := range []data.Feed{{
+		URL:        "http://example.com/feed",
+		WebsiteURL: "http://example.com",
+		Title:      "Example",
+		UpdatedAt:  time.Now(),
+		Items: []data.FeedItem{{
+			Key:       "1",
+			PermaLink: "http://example.com/1",
+			PubDate:   first,
+			Title:     "First title",
+			Link:      "http://example.com/1",
+		}},
+	}, {
+		URL:        "http://example.org/feed",
+		WebsiteURL: "http://example.org",
+		Title:      "Other",
+		UpdatedAt:  time.Now(),
+		Items: []data.FeedItem{{
+			Key:       "2",
+			PermaLink: "http://example.org/2",
+			PubDate:   second,
+			Title:     "Second title",
+			Link:      "http://example.org/2",
+		}},
+	}} {
+		if err := db.Subscribe(ctx, user.ID, feed.URL); err != nil {
+			t.Error(err)
+			return
+		}
+		if err := db.UpdateFeed(ctx, feed); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	garden := garden.New(db, time.Millisecond)
+	s := httptest.NewServer(asUser(user, garden.AtomHandler("http://arboretum.example/")))
+	defer s.Close()
+
+	resp, err := http.Get(s.URL + "/feed.atom")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "application/atom+xml", resp.Header.Get("Content-Type"))
+	assert.Equal(t, second.Format(http.TimeFormat), resp.Header.Get("Last-Modified"))
+
+	var atom struct {
+		ID      string `xml:"id"`
+		Entries []struct {
+			ID     string `xml:"id"`
+			Title  string `xml:"title"`
+			Author string `xml:"author>name"`
+			Source struct {
+				Title string `xml:"title"`
+			} `xml:"source"`
+		} `xml:"entry"`
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&atom); err != nil {
+		t.Error(err)
+		return
+	}
+
+	assert.Equal(t, "http://arboretum.example/feed.atom", atom.ID)
+	if !assert.Len(t, atom.Entries, 2) {
+		return
+	}
+	assert.Equal(t, "http://example.org/2", atom.Entries[0].ID)
+	assert.Equal(t, "Second title", atom.Entries[0].Title)
+	assert.Equal(t, "Other", atom.Entries[0].Author)
+	assert.Equal(t, "Other", atom.Entries[0].Source.Title)
+	assert.Equal(t, "http://example.com/1", atom.Entries[1].ID)
+	assert.Equal(t, "Example", atom.Entries[1].Source.Title)
+
+	etag := resp.Header.Get("ETag")
+	assert.NotEqual(t, "", etag)
+
+	req, _ := http.NewRequest("GET", s.URL, nil)
+	req.Header.Set("If-None-Match", etag)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotModified, resp.StatusCode)
+
+	req, _ = http.NewRequest("GET", s.URL, nil)
+	req.Header.Set("If-Modified-Since", second.Format(http.TimeFormat))
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotModified, resp.StatusCode)
+}
+
+func TestGardenRSSHandler(t *testing.T) {
+	ctx := context.Background()
+
+	db, err := data.Open(":memory:")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer db.Close()
+
+	user := testUser(t, db)
+
+	if err := db.Subscribe(ctx, user.ID, "http://example.com/feed"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	pubDate := time.Date(2003, time.November, 9, 17, 23, 2, 0, time.UTC)
+	if err := db.UpdateFeed(ctx, data.Feed{
+		URL:        "http://example.com/feed",
+		WebsiteURL: "http://example.com",
+		Title:      "Example",
+		UpdatedAt:  time.Now(),
+		Items: []data.FeedItem{{
+			Key:       "1",
+			PermaLink: "http://example.com/1",
+			PubDate:   pubDate,
+			Title:     "First title",
+			Link:      "http://example.com/1",
+		}},
+	}); err != nil {
+		t.Error(err)
+		return
+	}
+
+	garden := garden.New(db, time.Millisecond)
+	s := httptest.NewServer(asUser(user, garden.RSSHandler("http://arboretum.example/")))
+	defer s.Close()
+
+	resp, err := http.Get(s.URL)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer resp.Body.Close()
+
+	assert.Equal(t, "application/rss+xml", resp.Header.Get("Content-Type"))
+
+	var rss struct {
+		Items []struct {
+			Title   string `xml:"title"`
+			Link    string `xml:"link"`
+			PubDate string `xml:"pubDate"`
+			Source  struct {
+				URL   string `xml:"url,attr"`
+				Value string `xml:",chardata"`
+			} `xml:"source"`
+		} `xml:"channel>item"`
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&rss); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if !assert.Len(t, rss.Items, 1) {
+		return
+	}
+	assert.Equal(t, "First title", rss.Items[0].Title)
+	assert.Equal(t, "http://example.com/1", rss.Items[0].Link)
+	assert.Equal(t, pubDate.Format(time.RFC1123Z), rss.Items[0].PubDate)
+	assert.Equal(t, "http://example.com/feed", rss.Items[0].Source.URL)
+	assert.Equal(t, "Example", rss.Items[0].Source.Value)
+}
+
+func TestMarkRead(t *testing.T) {
+	ctx := context.Background()
+
+	db, err := data.Open(":memory:")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer db.Close()
+
+	user := testUser(t, db)
+
+	for _, uri := range []string{"http://example.com/feed", "http://example.org/feed"} {
+		if err := db.Subscribe(ctx, user.ID, uri); err != nil {
+			t.Error(err)
+			return
+		}
+		if err := db.UpdateFeed(ctx, data.Feed{
+			URL:       uri,
+			Title:     "Some title",
+			UpdatedAt: time.Now(),
+			Items: []data.FeedItem{
+				{Key: "1", PubDate: time.Now()},
+				{Key: "2", PubDate: time.Now().Add(-time.Hour)},
+			},
+		}); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	unread := func() int {
+		feeds, err := db.ReadAll(ctx, user.ID)
+		if err != nil {
+			t.Error(err)
+		}
+
+		n := 0
+		for _, feed := range feeds {
+			for _, item := range feed.Items {
+				if !item.Read {
+					n++
+				}
+			}
+		}
+		return n
+	}
+
+	mark := func(method, form string) int {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, "/read", strings.NewReader(form))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		asUser(user, readstate.Mark(db)).ServeHTTP(w, r)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusMethodNotAllowed, mark("GET", ""))
+	assert.Equal(t, 4, unread())
+
+	assert.Equal(t, http.StatusFound, mark("POST", "url=http://example.com/feed&key=1"))
+	assert.Equal(t, 3, unread())
+
+	assert.Equal(t, http.StatusFound, mark("POST", "url=http://example.org/feed"))
+	assert.Equal(t, 1, unread())
+
+	assert.Equal(t, http.StatusFound, mark("POST", ""))
+	assert.Equal(t, 0, unread())
+}
+
+func TestAccounts(t *testing.T) {
+	ctx := context.Background()
+
+	db, err := data.Open(":memory:")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer db.Close()
+
+	allowed := allowList("https://alice.example/, bob.example", "http://bob.example", "https://alice.example")
+	assert.Equal(t, []string{"https://alice.example/", "https://bob.example/", "http://bob.example/"}, allowed)
+
+	assert.Equal(t, "example.com-john", userName("https://www.Example.com/~john/"))
+	assert.Equal(t, "user", userName("https:///"))
+
+	accounts := accounts{db: db, allowed: allowed}
+
+	_, ok, err := accounts.user(ctx, "https://mallory.example/")
+	assert.Equal(t, nil, err)
+	assert.False(t, ok)
+
+	bob, ok, err := accounts.user(ctx, "https://bob.example/")
+	assert.Equal(t, nil, err)
+	assert.True(t, ok)
+	assert.Equal(t, "bob.example", bob.Name)
+
+	again, _, err := accounts.user(ctx, "bob.example")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, bob, again)
+
+	other, ok, err := accounts.user(ctx, "http://bob.example/")
+	assert.Equal(t, nil, err)
+	assert.True(t, ok)
+	assert.Equal(t, "bob.example-2", other.Name)
+}
+
+// gardenRemovals records the feeds a garden is told to stop fetching.
+type gardenRemovals []string
+
+func (g *gardenRemovals) Unsubscribe(ctx context.Context, uri string) error {
+	*g = append(*g, uri)
+	return nil
+}
+
+func (g *gardenRemovals) SettingsChanged(ctx context.Context, uri string) error {
+	return nil
+}
+
+func TestUnsubscribeDuringFetch(t *testing.T) {
+	ctx := context.Background()
+
+	db, err := data.Open(":memory:")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer db.Close()
+
+	user := testUser(t, db)
+
+	// the last subscriber leaves while the feed is being fetched
+	feed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := db.Unsubscribe(ctx, user.ID, "http://"+r.Host); err != nil {
+			t.Error(err)
+		}
+		w.Header().Set("Content-Type", "application/xml")
+		io.WriteString(w, atomOneItem)
+	}))
+	defer feed.Close()
+
+	if err := db.Subscribe(ctx, user.ID, feed.URL); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if _, err := garden.FetchOnce(ctx, db, time.Hour, feed.URL); err != nil {
+		t.Error(err)
+		return
+	}
+
+	subs, err := db.Subscriptions(ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	assert.Len(t, subs, 0)
+
+	feeds, err := db.ListFeeds(ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	assert.Len(t, feeds, 0)
+
+	items, err := db.ItemCount(ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	assert.Equal(t, 0, items)
+}
+
+func TestRemoveSharedFeed(t *testing.T) {
+	ctx := context.Background()
+
+	db, err := data.Open(":memory:")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer db.Close()
+
+	alice, err := db.AddUser(ctx, "alice", "https://alice.example/")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	bob, err := db.AddUser(ctx, "bob", "https://bob.example/")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	for _, user := range []data.User{alice, bob} {
+		if err := db.Subscribe(ctx, user.ID, "http://example.com/feed"); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	var removals gardenRemovals
+	remove := func(user data.User) {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("POST", "/remove", strings.NewReader("url=http://example.com/feed"))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		asUser(user, subscriptions.Remove(db, &removals)).ServeHTTP(w, r)
+		assert.Equal(t, http.StatusFound, w.Code)
+	}
+
+	// bob still subscribes, so the feed is still fetched
+	remove(alice)
+	assert.Len(t, removals, 0)
+
+	subs, err := db.SubscriptionDetails(ctx, bob.ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	assert.Len(t, subs, 1)
+
+	remove(bob)
+	assert.Equal(t, gardenRemovals{"http://example.com/feed"}, removals)
+}
+
+func TestImportAndExportOpml(t *testing.T) {
+	dir := t.TempDir()
+	opmlPath := filepath.Join(dir, "subscriptions.opml")
+	dbPath := filepath.Join(dir, "arboretum.db")
+
+	if err := os.WriteFile(opmlPath, []byte(`<?xml version="1.0" encoding="UTF-8"?>
+<opml version="1.0">
+  <head><title>subscriptions</title></head>
+  <body>
+    <outline text="Top" xmlUrl="http://example.com/top" htmlUrl="http://example.com"/>
+    <outline text="News">
+      <outline text="Daily" title="The Daily" xmlUrl="http://example.com/daily"/>
+      <outline text="Tech">
+        <outline text="Gadgets" xmlUrl="http://example.com/gadgets"/>
+      </outline>
+    </outline>
+  </body>
+</opml>`), 0o600); err != nil {
+		t.Error(err)
+		return
+	}
+
+	n, err := importOpml(context.Background(), opmlPath, dbPath, "")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	assert.Equal(t, 3, n)
+
+	db, err := data.Open(dbPath)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer db.Close()
+
+	user := testUser(t, db)
+
+	w := httptest.NewRecorder()
+	asUser(user, subscriptions.List(db)).ServeHTTP(w, httptest.NewRequest("GET", "/subscriptions.opml", nil))
+
+	doc, err := opml.Read(w.Body)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	assert.Equal(t, []opml.Outline{{
+		Text:  "News",
+		Title: "News",
+		Outline: []opml.Outline{{
+			Type:   "rss",
+			Text:   "The Daily",
+			Title:  "The Daily",
+			XMLURL: "http://example.com/daily",
+		}, {
+			Text:  "Tech",
+			Title: "Tech",
+			Outline: []opml.Outline{{
+				Type:   "rss",
+				Text:   "Gadgets",
+				Title:  "Gadgets",
+				XMLURL: "http://example.com/gadgets",
+			}},
+		}},
+	}, {
+		Type:    "rss",
+		Text:    "Top",
+		Title:   "Top",
+		XMLURL:  "http://example.com/top",
+		HTMLURL: "http://example.com",
+	}}, doc.Body.Outline)
+}
+
+func TestSearchJSONHandler(t *testing.T) {
+	ctx := context.Background()
+
+	db, err := data.Open(":memory:")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer db.Close()
+
+	user := testUser(t, db)
+
+	if err := db.Subscribe(ctx, user.ID, "http://example.com/feed"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	pubDate := time.Date(2003, time.November, 9, 17, 23, 2, 0, time.UTC)
+	if err := db.UpdateFeed(ctx, data.Feed{
+		URL:        "http://example.com/feed",
+		WebsiteURL: "http://example.com",
+		Title:      "Some title",
+		UpdatedAt:  time.Now(),
+		Items: []data.FeedItem{{
+			Key:       "1",
+			PermaLink: "http://example.com/1",
+			PubDate:   pubDate,
+			Title:     "Planting trees",
+			Link:      "http://example.com/1",
+		}, {
+			Key:       "2",
+			PermaLink: "http://example.com/2",
+			PubDate:   pubDate,
+			Title:     "Pruning hedges",
+			Link:      "http://example.com/2",
+		}},
+	}); err != nil {
+		t.Error(err)
+		return
+	}
+
+	s := httptest.NewServer(asUser(user, search.JSONHandler(db)))
+	defer s.Close()
+
+	resp, err := http.Get(s.URL + "?q=trees")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotImplemented {
+		t.Skip("search is not available")
+	}
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+
+	var v struct {
+		Query   string `json:"query"`
+		Results []struct {
+			FeedURL   string    `json:"feedUrl"`
+			FeedTitle string    `json:"feedTitle"`
+			PermaLink string    `json:"permaLink"`
+			PubDate   time.Time `json:"pubDate"`
+			Title     string    `json:"title"`
+		} `json:"results"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&v); err != nil {
+		t.Error(err)
+		return
+	}
+
+	assert.Equal(t, "trees", v.Query)
+	if !assert.Len(t, v.Results, 1) {
+		return
+	}
+	assert.Equal(t, "http://example.com/feed", v.Results[0].FeedURL)
+	assert.Equal(t, "Some title", v.Results[0].FeedTitle)
+	assert.Equal(t, "http://example.com/1", v.Results[0].PermaLink)
+	assert.Equal(t, pubDate, v.Results[0].PubDate)
+	assert.Equal(t, "Planting trees", v.Results[0].Title)
+
+	resp, err = http.Get(s.URL + "?q=trees&since=yesterday")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	resp.Body.Close()
+
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	// without a user nobody's feeds are searched, rather than everybody's
+	noUser := httptest.NewServer(search.JSONHandler(db))
+	defer noUser.Close()
+
+	resp, err = http.Get(noUser.URL + "?q=trees")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer resp.Body.Close()
+
+	v.Results = nil
+	if err := json.NewDecoder(resp.Body).Decode(&v); err != nil {
+		t.Error(err)
+		return
+	}
+	assert.Len(t, v.Results, 0)
+}
+
+func TestCommands(t *testing.T) {
+	feed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/atom+xml")
+		io.WriteString(w, atomTwoItem)
+	}))
+	defer feed.Close()
+
+	db, err := data.Open(":memory:")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer db.Close()
+
+	var out strings.Builder
+	c := cli{
+		db:      db,
+		out:     &out,
+		client:  http.DefaultClient,
+		refresh: time.Hour,
+	}
+
+	run := func(args ...string) (string, error) {
+		out.Reset()
+		err := c.run(context.Background(), args)
+		return out.String(), err
+	}
+
+	output, err := run("add", feed.URL)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "subscribed to "+feed.URL+"\n", output)
+
+	output, err = run("list")
+	assert.Equal(t, nil, err)
+	assert.True(t, strings.Contains(output, feed.URL))
+	assert.True(t, strings.Contains(output, "not fetched"))
+
+	output, err = run("refresh", feed.URL)
+	assert.Equal(t, nil, err)
+	assert.True(t, strings.HasPrefix(output, "Some title (2 items)\n"))
+	assert.True(t, strings.Contains(output, "Second title"))
+
+	output, err = run("list")
+	assert.Equal(t, nil, err)
+	assert.True(t, strings.Contains(output, "ok"))
+
+	// only the feeds of the chosen user are listed
+	if _, err := db.AddUser(context.Background(), "user", "https://user.example/"); err != nil {
+		t.Error(err)
+		return
+	}
+	if _, err := db.AddUser(context.Background(), "other", "https://other.example/"); err != nil {
+		t.Error(err)
+		return
+	}
+	c.user = "other"
+	output, err = run("list")
+	assert.Equal(t, nil, err)
+	assert.False(t, strings.Contains(output, feed.URL))
+	c.user = "user"
+
+	output, err = run("stats")
+	assert.Equal(t, nil, err)
+	assert.True(t, strings.Contains(output, "items        2\n"))
+
+	output, err = run("export")
+	assert.Equal(t, nil, err)
+	doc, err := opml.Read(strings.NewReader(output))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if assert.Len(t, doc.Body.Outline, 1) {
+		assert.Equal(t, feed.URL, doc.Body.Outline[0].XMLURL)
+	}
+
+	output, err = run("users")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "NAME   ME\nother  https://other.example/\nuser   https://user.example/\n", output)
+
+	output, err = run("prune")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "removed 0 items\n", output)
+
+	output, err = run("retention", feed.URL, "1")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "retention for "+feed.URL+" set to 1, removed 1 items\n", output)
+
+	output, err = run("stats")
+	assert.Equal(t, nil, err)
+	assert.True(t, strings.Contains(output, "items        1\n"))
+
+	_, err = run("retention", feed.URL, "soon")
+	assert.NotEqual(t, nil, err)
+
+	_, err = run("retention", "http://example.com/not-subscribed", "1")
+	assert.NotEqual(t, nil, err)
+
+	output, err = run("retention", feed.URL, "default")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "retention for "+feed.URL+" set to default, removed 0 items\n", output)
+
+	output, err = run("remove", feed.URL)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "unsubscribed from "+feed.URL+"\n", output)
+
+	_, err = run("remove", feed.URL)
+	assert.NotEqual(t, nil, err)
+
+	_, err = run("refresh", feed.URL)
+	assert.NotEqual(t, nil, err)
+
+	_, err = run("list", "extra")
+	assert.NotEqual(t, nil, err)
+
+	_, err = run("unknown")
+	assert.NotEqual(t, nil, err)
+}
+
+func TestConfigure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(`{
+	"refresh": "1h",
+	"keep-items": 10,
+	"private": true,
+	"port": "9000"
+}`), 0o600); err != nil {
+		t.Error(err)
+		return
+	}
+
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	var (
+		refresh   = flags.String("refresh", "6h", "")
+		keepItems = flags.Int("keep-items", 7, "")
+		private   = flags.Bool("private", false, "")
+		port      = flags.String("port", "8080", "")
+		url       = flags.String("url", "http://localhost:8080", "")
+	)
+	if err := flags.Parse([]string{"--port", "7000"}); err != nil {
+		t.Error(err)
+		return
+	}
+
+	env := map[string]string{
+		"ARBORETUM_REFRESH": "2h",
+		"ARBORETUM_PORT":    "8000",
+	}
+	lookupEnv := func(key string) (string, bool) {
+		value, ok := env[key]
+		return value, ok
+	}
+
+	if err := configure(flags, path, lookupEnv); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// flag over environment over file over default
+	assert.Equal(t, "7000", *port)
+	assert.Equal(t, "2h", *refresh)
+	assert.Equal(t, 10, *keepItems)
+	assert.Equal(t, true, *private)
+	assert.Equal(t, "http://localhost:8080", *url)
+
+	if err := os.WriteFile(path, []byte(`{"unknown": "value"}`), 0o600); err != nil {
+		t.Error(err)
+		return
+	}
+	assert.NotEqual(t, nil, configure(flags, path, lookupEnv))
+}
+
+func TestCookieSecret(t *testing.T) {
+	db, err := data.Open(":memory:")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+
+	_, err = cookieSecret(ctx, db, devSecret, false)
+	assert.NotEqual(t, nil, err)
+
+	secret, err := cookieSecret(ctx, db, devSecret, true)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, devSecret, secret)
+
+	secret, err = cookieSecret(ctx, db, "given", false)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "given", secret)
+
+	generated, err := cookieSecret(ctx, db, "", false)
+	assert.Equal(t, nil, err)
+	assert.NotEqual(t, "", generated)
+	assert.NotEqual(t, devSecret, generated)
+
+	// kept for the next run
+	secret, err = cookieSecret(ctx, db, "", false)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, generated, secret)
+}
+
+func TestServeWaitsForRequestsOnShutdown(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	socket := filepath.Join(t.TempDir(), "arboretum.sock")
+	started := make(chan struct{})
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(50 * time.Millisecond)
+		io.WriteString(w, "done")
+	})
+
+	listener, err := listen("", socket)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	served := make(chan error, 1)
+	go func() {
+		served <- serve(ctx, listener, handler, time.Second)
+	}()
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socket)
+			},
+		},
+	}
+
+	go func() {
+		<-started
+		cancel()
+	}()
+
+	resp, err := client.Get("http://arboretum/")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	assert.Equal(t, "done", string(body))
+	assert.Equal(t, nil, <-served)
+
+	_, err = os.Stat(socket)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestMetrics(t *testing.T) {
+	ctx, cancel := contextWithDelayedCancel()
+	defer cancel()
+
+	feed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/xml")
+		io.WriteString(w, atomOneItem)
+		cancel()
+	}))
+	defer feed.Close()
+
+	db, err := data.Open(":memory:")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer db.Close()
+
+	user := testUser(t, db)
+
+	if err := db.Subscribe(ctx, user.ID, feed.URL); err != nil {
+		t.Error(err)
+		return
+	}
+
+	garden := garden.New(db, time.Millisecond)
+	go func() {
+		garden.Run(ctx)
+	}()
+	garden.Subscribe(ctx, feed.URL)
+
+	<-ctx.Done()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /feeds/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+	mux.Handle("GET /metrics", metrics.Handler())
+
+	s := httptest.NewServer(metrics.Instrument(mux))
+	defer s.Close()
+
+	resp, err := http.Get(s.URL + "/feeds/1")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	resp.Body.Close()
+
+	resp, err = http.Get(s.URL + "/metrics")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	assert.True(t, strings.Contains(string(body), `arboretum_fetches_total{code="200"}`))
+	assert.True(t, strings.Contains(string(body), `arboretum_http_request_duration_seconds_count{code="418",handler="GET /feeds/{id}"} 1`))
+}
+
+func TestGardenStatus(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	fetched := make(chan struct{}, 1)
+	feed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/xml")
+		io.WriteString(w, atomOneItem)
+		select {
+		case fetched <- struct{}{}:
+		default:
+		}
+	}))
+	defer feed.Close()
+
+	db, err := data.Open(":memory:")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer db.Close()
+
+	user := testUser(t, db)
+
+	if err := db.Subscribe(ctx, user.ID, feed.URL); err != nil {
+		t.Error(err)
+		return
+	}
+
+	garden := garden.New(db, time.Hour)
+
+	ready := httptest.NewRecorder()
+	garden.ReadyHandler()(ready, httptest.NewRequest("GET", "/readyz", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, ready.Code)
+
+	go func() {
+		garden.Run(ctx)
+	}()
+	if err := addSubs(ctx, db, garden); err != nil {
+		t.Error(err)
+		return
+	}
+	garden.Loaded()
+
+	ready = httptest.NewRecorder()
+	garden.ReadyHandler()(ready, httptest.NewRequest("GET", "/readyz", nil))
+	assert.Equal(t, http.StatusOK, ready.Code)
+
+	<-fetched
+
+	// the report is updated once the poll has finished
+	var status struct {
+		Ready bool `json:"ready"`
+		Feeds []struct {
+			URL        string    `json:"url"`
+			LastFetch  time.Time `json:"lastFetch"`
+			StatusCode int       `json:"statusCode"`
+			Error      string    `json:"error"`
+			NextPoll   time.Time `json:"nextPoll"`
+		} `json:"feeds"`
+	}
+	for range 100 {
+		resp := httptest.NewRecorder()
+		asUser(user, garden.StatusJSONHandler())(resp, httptest.NewRequest("GET", "/status.json", nil))
+		if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+			t.Error(err)
+			return
+		}
+		if len(status.Feeds) == 1 && status.Feeds[0].StatusCode != 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	assert.True(t, status.Ready)
+	if len(status.Feeds) != 1 {
+		t.Errorf("expected 1 feed, got %d", len(status.Feeds))
+		return
+	}
+	assert.Equal(t, feed.URL, status.Feeds[0].URL)
+	assert.Equal(t, http.StatusOK, status.Feeds[0].StatusCode)
+	assert.Equal(t, "", status.Feeds[0].Error)
+	assert.True(t, status.Feeds[0].NextPoll.After(status.Feeds[0].LastFetch))
+
+	page := httptest.NewRecorder()
+	asUser(user, garden.StatusHandler())(page, httptest.NewRequest("GET", "/status", nil))
+	assert.Equal(t, http.StatusOK, page.Code)
+
+	// others only see the feeds they subscribe to. user is claimed first, so
+	// that other is a different user
+	if _, err := db.AddUser(ctx, "user", "https://user.example/"); err != nil {
+		t.Error(err)
+		return
+	}
+	other, err := db.AddUser(ctx, "other", "https://other.example/")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	reports, err := garden.Status(ctx, other.ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	assert.Len(t, reports, 0)
+}
+
+func TestGardenRefresh(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var fetches atomic.Int32
+	fetched := make(chan struct{}, 1)
+	feed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		w.Header().Set("Content-Type", "application/xml")
+		io.WriteString(w, atomOneItem)
+		select {
+		case fetched <- struct{}{}:
+		default:
+		}
+	}))
+	defer feed.Close()
+
+	db, err := data.Open(":memory:")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer db.Close()
+
+	user := testUser(t, db)
+
+	if err := db.Subscribe(ctx, user.ID, feed.URL); err != nil {
+		t.Error(err)
+		return
+	}
+
+	garden := garden.New(db, time.Hour)
+	go func() {
+		garden.Run(ctx)
+	}()
+	garden.Subscribe(ctx, feed.URL)
+
+	<-fetched
+
+	// the first poll may still be finishing, in which case its result is
+	// returned
+	if _, err := garden.Refresh(ctx, user.ID, feed.URL); err != nil {
+		t.Error(err)
+		return
+	}
+	polled := fetches.Load()
+
+	before := time.Now()
+	fetch, err := garden.Refresh(ctx, user.ID, feed.URL)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	assert.Equal(t, feed.URL, fetch.URL)
+	assert.Equal(t, http.StatusOK, fetch.StatusCode)
+	assert.True(t, fetch.NextPoll.After(before.Add(time.Hour)))
+	assert.Equal(t, polled+1, fetches.Load())
+
+	resp := httptest.NewRecorder()
+	asUser(user, garden.RefreshJSONHandler())(resp, httptest.NewRequest("POST", "/refresh.json?url=http://example.com/not-subscribed", nil))
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+
+	// another user can not refresh feeds they do not subscribe to. user is
+	// claimed first, so that other is a different user
+	if _, err := db.AddUser(ctx, "user", "https://user.example/"); err != nil {
+		t.Error(err)
+		return
+	}
+	other, err := db.AddUser(ctx, "other", "https://other.example/")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	resp = httptest.NewRecorder()
+	asUser(other, garden.RefreshJSONHandler())(resp, httptest.NewRequest("POST", "/refresh.json?url="+feed.URL, nil))
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+
+	all, err := garden.RefreshAll(ctx, other.ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	assert.Len(t, all, 0)
+	assert.Equal(t, polled+1, fetches.Load())
+
+	resp = httptest.NewRecorder()
+	asUser(user, garden.RefreshJSONHandler())(resp, httptest.NewRequest("POST", "/refresh.json", nil))
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	var results []struct {
+		URL        string `json:"url"`
+		StatusCode int    `json:"statusCode"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&results); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if len(results) != 1 {
+		t.Errorf("expected 1 result, got %d", len(results))
+		return
+	}
+	assert.Equal(t, feed.URL, results[0].URL)
+	assert.Equal(t, http.StatusOK, results[0].StatusCode)
+	assert.Equal(t, polled+2, fetches.Load())
+}
+
+func TestGardenFeedSettings(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	fetched := make(chan struct{}, 1)
+	feed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/xml")
+		io.WriteString(w, atomOneItem)
+		select {
+		case fetched <- struct{}{}:
+		default:
+		}
+	}))
+	defer feed.Close()
+
+	db, err := data.Open(":memory:")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer db.Close()
+
+	user := testUser(t, db)
+
+	if err := db.Subscribe(ctx, user.ID, feed.URL); err != nil {
+		t.Error(err)
+		return
+	}
+
+	garden := garden.New(db, time.Hour)
+	go func() {
+		garden.Run(ctx)
+	}()
+	garden.Subscribe(ctx, feed.URL)
+
+	<-fetched
+
+	// wait for the first poll to finish, so that the next uses the settings
+	if _, err := garden.Refresh(ctx, user.ID, feed.URL); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// user is claimed first, so that other is a different user
+	if _, err := db.AddUser(ctx, "user", "https://user.example/"); err != nil {
+		t.Error(err)
+		return
+	}
+	other, err := db.AddUser(ctx, "other", "https://other.example/")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	post := func(user data.User, form string) int {
+		r := httptest.NewRequest("POST", "/settings", strings.NewReader(form))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		asUser(user, subscriptions.Settings(db, garden)).ServeHTTP(w, r)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusBadRequest, post(user, "url="+feed.URL+"&refresh=soon"))
+	assert.Equal(t, http.StatusNotFound, post(other, "url="+feed.URL+"&paused=on"))
+	assert.Equal(t, http.StatusFound, post(user, "url="+feed.URL+"&title=Mine&refresh=2h&paused=on"))
+
+	settings, err := db.FeedSettings(ctx, user.ID, feed.URL)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	assert.Equal(t, data.FeedSettings{Title: "Mine", Refresh: 2 * time.Hour, Paused: true}, settings)
+
+	latest, err := garden.Latest(ctx, user.ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(latest.Feeds) != 1 {
+		t.Errorf("expected 1 feed, got %d", len(latest.Feeds))
+		return
+	}
+	assert.Equal(t, "Mine", latest.Feeds[0].Title)
+	assert.True(t, latest.Feeds[0].Paused)
+
+	// a paused feed can still be refreshed by hand, and is then polled on its
+	// own refresh
+	before := time.Now()
+	fetch, err := garden.Refresh(ctx, user.ID, feed.URL)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	assert.True(t, fetch.NextPoll.After(before.Add(2*time.Hour)))
+	assert.True(t, fetch.NextPoll.Before(time.Now().Add(2*time.Hour+time.Minute)))
+}
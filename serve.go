@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+)
+
+// listen opens the unix socket, or the port when no socket is given.
+func listen(port, socket string) (net.Listener, error) {
+	if socket != "" {
+		return net.Listen("unix", socket)
+	}
+
+	return net.Listen("tcp", ":"+port)
+}
+
+// serve handles requests on listener until ctx is cancelled. It then stops
+// accepting connections and waits up to timeout for the requests in progress
+// to finish.
+func serve(ctx context.Context, listener net.Listener, handler http.Handler, timeout time.Duration) error {
+	server := &http.Server{Handler: handler}
+
+	served := make(chan error, 1)
+	go func() {
+		served <- server.Serve(listener)
+	}()
+	slog.Info("listening", slog.String("addr", listener.Addr().String()))
+
+	select {
+	case err := <-served:
+		return err
+	case <-ctx.Done():
+	}
+
+	slog.Info("shutting down", slog.String("addr", listener.Addr().String()))
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		return err
+	}
+	if err := <-served; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	return nil
+}
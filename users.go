@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"hawx.me/code/arboretum/internal/data"
+	"hawx.me/code/arboretum/internal/page"
+)
+
+// accounts decides who may sign in, giving each profile URL on the allowlist
+// their own user the first time they do.
+type accounts struct {
+	db interface {
+		UserByMe(context.Context, string) (data.User, bool, error)
+		UserByName(context.Context, string) (data.User, bool, error)
+		AddUser(ctx context.Context, name, me string) (data.User, error)
+	}
+	allowed []string
+}
+
+// allowList combines the comma separated lists of profile URLs into one.
+func allowList(lists ...string) []string {
+	var allowed []string
+	for _, list := range lists {
+		for _, me := range strings.Split(list, ",") {
+			if me = canonicalMe(me); me != "" && !slices.Contains(allowed, me) {
+				allowed = append(allowed, me)
+			}
+		}
+	}
+
+	return allowed
+}
+
+// canonicalMe tidies a profile URL as typed, so "example.com" and
+// "https://example.com/" are treated as the same.
+func canonicalMe(me string) string {
+	me = strings.TrimSpace(me)
+	if me == "" {
+		return ""
+	}
+	if !strings.Contains(me, "://") {
+		me = "https://" + me
+	}
+
+	u, err := url.Parse(me)
+	if err != nil {
+		return me
+	}
+	u.Host = strings.ToLower(u.Host)
+	if u.Path == "" {
+		u.Path = "/"
+	}
+
+	return u.String()
+}
+
+// allows reports whether me may sign in.
+func (a accounts) allows(me string) bool {
+	return slices.Contains(a.allowed, canonicalMe(me))
+}
+
+// user returns the user that signs in as me, adding them if this is the first
+// time. It is false if me is not allowed to sign in.
+func (a accounts) user(ctx context.Context, me string) (data.User, bool, error) {
+	if !a.allows(me) {
+		return data.User{}, false, nil
+	}
+	me = canonicalMe(me)
+
+	if user, ok, err := a.db.UserByMe(ctx, me); err != nil || ok {
+		return user, ok, err
+	}
+
+	name := userName(me)
+	for i := 2; ; i++ {
+		_, taken, err := a.db.UserByName(ctx, name)
+		if err != nil {
+			return data.User{}, false, err
+		}
+		if !taken {
+			break
+		}
+		name = fmt.Sprintf("%s-%d", userName(me), i)
+	}
+
+	user, err := a.db.AddUser(ctx, name, me)
+	if err != nil {
+		// another request may have added them first
+		if user, ok, rerr := a.db.UserByMe(ctx, me); rerr == nil && ok {
+			return user, true, nil
+		}
+		return data.User{}, false, err
+	}
+
+	slog.Info("added user", slog.String("name", user.Name), slog.String("me", user.Me))
+	return user, true, nil
+}
+
+// userName picks a name for the garden of me from its host and path, so
+// "https://www.example.com/~john/" is called "example.com-john".
+func userName(me string) string {
+	u, err := url.Parse(me)
+	if err != nil {
+		return "user"
+	}
+
+	name := strings.TrimPrefix(u.Hostname(), "www.")
+	if path := strings.Trim(u.Path, "/"); path != "" {
+		name += "-" + path
+	}
+
+	name = strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '.', r == '-':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r - 'A' + 'a'
+		default:
+			return '-'
+		}
+	}, name)
+	name = strings.Join(strings.FieldsFunc(name, func(r rune) bool { return r == '-' }), "-")
+
+	if name == "" {
+		return "user"
+	}
+	return name
+}
+
+// usersIndex lists the gardens kept, for visitors that are not signed in.
+func usersIndex(db interface {
+	Users(context.Context) ([]data.User, error)
+}) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		list, err := db.Users(r.Context())
+		if err != nil {
+			slog.Error("list users", slog.Any("err", err))
+			http.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+		list = slices.DeleteFunc(list, func(user data.User) bool { return user.Name == "" })
+
+		if _, err := page.Users(list).WriteTo(w); err != nil {
+			slog.Error("render users", slog.Any("err", err))
+		}
+	}
+}